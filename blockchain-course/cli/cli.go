@@ -0,0 +1,889 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"blockchain-course/module1/keys"
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week1"
+	"blockchain-course/module1/week2"
+	"blockchain-course/module2/mempool"
+	"blockchain-course/module2/week3"
+	"blockchain-course/module3/week5"
+)
+
+// Exit codes returned by Run
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
+
+const (
+	defaultDataDir = "data"
+	// utxoFileName is the file of the data directory holding the UTXO set
+	utxoFileName = "utxo.dat"
+	// mempoolExpiryInterval is how often a node drops the expired transactions of its mempool
+	mempoolExpiryInterval = time.Minute
+)
+
+// Environment variables holding the secrets left out of the command line
+const (
+	envWalletPassphrase    = "BLOCKCHAIN_WALLET_PASSPHRASE"
+	envNewWalletPassphrase = "BLOCKCHAIN_NEW_WALLET_PASSPHRASE"
+	envMnemonic            = "BLOCKCHAIN_MNEMONIC"
+	envMnemonicPassphrase  = "BLOCKCHAIN_MNEMONIC_PASSPHRASE"
+)
+
+var (
+	errNoBlockchain = errors.New("no blockchain found, run createblockchain first")
+)
+
+// CLI is a non-interactive command line interface driven by subcommands
+type CLI struct {
+	stdin  *bufio.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+
+	dataDir    string
+	walletName string
+	jsonOutput bool
+}
+
+// command is a subcommand of the CLI
+type command struct {
+	name  string
+	usage string
+	run   func(cli *CLI, args []string) error
+}
+
+var commands = []command{
+	{"createblockchain", "-address ADDRESS  Create a blockchain and send the genesis reward to ADDRESS", (*CLI).createBlockchain},
+	{"createwallet", "[-scheme p256|secp256k1|ed25519|schnorr] [-passphrase PASSPHRASE] [-walletpassphrase PASSPHRASE] [-unencrypted]  Derive a new address, creating the recovery mnemonic on first use", (*CLI).createWallet},
+	{"restorewallet", "-mnemonic MNEMONIC [-passphrase PASSPHRASE] [-gap GAP] [-walletpassphrase PASSPHRASE] [-unencrypted]  Regenerate the addresses of a recovery mnemonic", (*CLI).restoreWallet},
+	{"changepassphrase", "[-old OLD] -new NEW [-unencrypted]  Change the passphrase encrypting the wallet file", (*CLI).changePassphrase},
+	{"listaddresses", "List all addresses from the wallet file", (*CLI).listAddresses},
+	{"listwallets", "List the wallets of the data directory", (*CLI).listWallets},
+	{"getpubkey", "-address ADDRESS [-walletpassphrase PASSPHRASE]  Print the public key of an address of the wallet", (*CLI).getPubKey},
+	{"createmultisig", "-m M -pubkeys HEX,HEX,...  Create the address spendable by M signatures of the public keys", (*CLI).createMultiSig},
+	{"getbalance", "-address ADDRESS  Get the balance of ADDRESS", (*CLI).getBalance},
+	{"send", "-from FROM -to TO -amount AMOUNT [-fee FEE] [-walletpassphrase PASSPHRASE]  Send AMOUNT of coins from FROM to TO and mine a block", (*CLI).send},
+	{"printchain", "Print all the blocks of the blockchain", (*CLI).printChain},
+	{"validate", "Validate the blockchain", (*CLI).validate},
+	{"reindex", "Rebuild the UTXO set from the blockchain", (*CLI).reindex},
+	{"startnode", "-host HOST -port PORT  Start a node", (*CLI).startNode},
+}
+
+// usageError is returned for invalid command lines
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// NewCLI creates a CLI writing to the given outputs, reading secrets from the
+// standard input and the environment
+func NewCLI(stdout, stderr io.Writer) *CLI {
+	return &CLI{
+		stdin:   bufio.NewReader(os.Stdin),
+		stdout:  stdout,
+		stderr:  stderr,
+		getenv:  os.Getenv,
+		dataDir: defaultDataDir,
+	}
+}
+
+// Run executes the command line and returns the process exit code
+func (cli *CLI) Run(args []string) int {
+	global := flag.NewFlagSet("blockchain-course", flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	cli.addCommonFlags(global)
+
+	if err := global.Parse(args); err != nil {
+		return cli.fail(&usageError{err.Error()})
+	}
+	if global.NArg() == 0 {
+		cli.printUsage()
+		return ExitUsage
+	}
+
+	name := global.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(cli, global.Args()[1:]); err != nil {
+				return cli.fail(err)
+			}
+			return ExitOK
+		}
+	}
+
+	return cli.fail(&usageError{fmt.Sprintf("unknown command %q", name)})
+}
+
+// addCommonFlags registers the flags accepted before and after the command name
+func (cli *CLI) addCommonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cli.dataDir, "datadir", cli.dataDir, "directory holding the blockchain data and the wallets")
+	fs.StringVar(&cli.walletName, "wallet", cli.walletName, "name of the wallet to use instead of the default one")
+	fs.BoolVar(&cli.jsonOutput, "json", cli.jsonOutput, "print machine-readable JSON output")
+}
+
+// parseFlags parses the flags of a subcommand
+func (cli *CLI) parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	cli.addCommonFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return &usageError{fmt.Sprintf("%s: %s", fs.Name(), err)}
+	}
+	if fs.NArg() > 0 {
+		return &usageError{fmt.Sprintf("%s: unexpected argument %q", fs.Name(), fs.Arg(0))}
+	}
+	return nil
+}
+
+// fail reports an error and returns the matching exit code
+func (cli *CLI) fail(err error) int {
+	code := ExitError
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		code = ExitUsage
+	}
+
+	if cli.jsonOutput {
+		cli.writeJSON(map[string]string{"error": err.Error()})
+	} else {
+		fmt.Fprintf(cli.stderr, "Error: %s\n", err)
+		if code == ExitUsage {
+			cli.printUsage()
+		}
+	}
+
+	return code
+}
+
+func (cli *CLI) printUsage() {
+	fmt.Fprintln(cli.stderr, "Usage: blockchain-course [-datadir DIR] [-json] COMMAND [flags]")
+	fmt.Fprintln(cli.stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(cli.stderr, "  %-18s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(cli.stderr, "Passphrases and mnemonics set to - are read from the standard input, and when")
+	fmt.Fprintf(cli.stderr, "omitted from %s, %s,\n%s and %s.\n",
+		envWalletPassphrase, envNewWalletPassphrase, envMnemonic, envMnemonicPassphrase)
+}
+
+// secret returns the value of a secret flag, which the other users of the
+// machine can see on the command line: "-" prompts for a line of the standard
+// input, and an empty value falls back to the environment variable env.
+func (cli *CLI) secret(value, prompt, env string) (string, error) {
+	switch value {
+	case "-":
+		fmt.Fprintf(cli.stderr, "%s: ", prompt)
+		line, err := cli.stdin.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", fmt.Errorf("reading %s: %w", strings.ToLower(prompt), err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	case "":
+		return cli.getenv(env), nil
+	}
+	return value, nil
+}
+
+// output prints value as JSON in JSON mode, or the text otherwise
+func (cli *CLI) output(value interface{}, text string) {
+	if cli.jsonOutput {
+		cli.writeJSON(value)
+		return
+	}
+	fmt.Fprintln(cli.stdout, text)
+}
+
+func (cli *CLI) writeJSON(value interface{}) {
+	encoder := json.NewEncoder(cli.stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// openBlockchain opens the existing blockchain of the data directory
+func (cli *CLI) openBlockchain() (*week3.Blockchain, error) {
+	store, err := week2.OpenFileStorage(cli.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if store.Height() < 0 {
+		store.Close()
+		return nil, errNoBlockchain
+	}
+
+	chain, err := week2.NewBlockchainWithStorage(store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	bc, err := week3.OpenBlockchain(chain, cli.utxoPath())
+	if err != nil {
+		chain.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+func (cli *CLI) utxoPath() string {
+	return filepath.Join(cli.dataDir, utxoFileName)
+}
+
+// coinbaseData commits the block height in the coinbase so that rewards paid
+// to the same address in different blocks get distinct transaction IDs
+func coinbaseData(address string, height int64) string {
+	return fmt.Sprintf("Reward to \"%s\" at height %d", address, height)
+}
+
+// pubKeyHashOf validates an address and returns the public key hash it encodes
+func pubKeyHashOf(address string) ([]byte, error) {
+	if !week3.ValidateAddress(address) {
+		return nil, fmt.Errorf("%w: %s", week3.ErrInvalidAddress, address)
+	}
+
+	_, pubKeyHash, err := week3.Base58CheckDecode([]byte(address))
+	return pubKeyHash, err
+}
+
+func (cli *CLI) createBlockchain(args []string) error {
+	fs := flag.NewFlagSet("createblockchain", flag.ContinueOnError)
+	address := fs.String("address", "", "address receiving the genesis reward")
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *address == "" {
+		return &usageError{"createblockchain: -address is required"}
+	}
+	if _, err := pubKeyHashOf(*address); err != nil {
+		return err
+	}
+
+	coinbase, err := week3.NewCoinbaseTX(*address, coinbaseData(*address, 0))
+	if err != nil {
+		return err
+	}
+	store, err := week2.OpenFileStorage(cli.dataDir)
+	if err != nil {
+		return err
+	}
+
+	genesis := week1.NewGenesisBlock(coinbase)
+	chain, err := week2.CreateBlockchain(store, week2.DefaultParams(), genesis)
+	if err != nil {
+		store.Close()
+		return err
+	}
+
+	bc, err := week3.OpenBlockchain(chain, cli.utxoPath())
+	if err != nil {
+		chain.Close()
+		return err
+	}
+	if err := bc.Close(); err != nil {
+		return err
+	}
+
+	cli.output(map[string]string{"genesis": hex.EncodeToString(genesis.Hash)},
+		fmt.Sprintf("Blockchain created, genesis block %x", genesis.Hash))
+	return nil
+}
+
+func (cli *CLI) createWallet(args []string) error {
+	fs := flag.NewFlagSet("createwallet", flag.ContinueOnError)
+	schemeName := fs.String("scheme", keys.SchemeP256.String(), "signature scheme of the key")
+	passphrase := fs.String("passphrase", "", "passphrase protecting the mnemonic, only used when creating it")
+	walletPassphrase := fs.String("walletpassphrase", "", "passphrase of the wallet file")
+	unencrypted := fs.Bool("unencrypted", false, "allow a new wallet file without a passphrase")
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+	scheme, err := keys.ParseScheme(*schemeName)
+	if err != nil {
+		return &usageError{fmt.Sprintf("createwallet: %s", err)}
+	}
+
+	wallets, err := cli.createWallets(*walletPassphrase, *unencrypted)
+	if err != nil {
+		return err
+	}
+
+	// Addresses are only derived from the mnemonic on P-256, keys of the
+	// other schemes are random and must be backed up with the wallet file
+	if scheme != keys.SchemeP256 {
+		address, err := wallets.CreateWalletWithScheme(scheme)
+		if err != nil {
+			return err
+		}
+		if err := wallets.SaveToFile(); err != nil {
+			return err
+		}
+		cli.output(map[string]string{"address": address, "scheme": scheme.String()},
+			fmt.Sprintf("Your new %s address: %s\nIt is not covered by the recovery mnemonic, back up the wallet file", scheme, address))
+		return nil
+	}
+
+	result := make(map[string]string)
+	var text []string
+	if !wallets.HasSeed() {
+		seedPassphrase, err := cli.secret(*passphrase, "Mnemonic passphrase", envMnemonicPassphrase)
+		if err != nil {
+			return err
+		}
+		mnemonic, err := wallets.GenerateSeed(seedPassphrase)
+		if err != nil {
+			return err
+		}
+		result["mnemonic"] = mnemonic
+		text = append(text, "Write down your recovery mnemonic: "+mnemonic)
+	}
+
+	address, err := wallets.DeriveWallet(0, week3.ExternalChain)
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		return err
+	}
+
+	result["address"] = address
+	text = append(text, fmt.Sprintf("Your new address: %s", address))
+	cli.output(result, strings.Join(text, "\n"))
+	return nil
+}
+
+func (cli *CLI) restoreWallet(args []string) error {
+	fs := flag.NewFlagSet("restorewallet", flag.ContinueOnError)
+	mnemonic := fs.String("mnemonic", "", "recovery mnemonic")
+	passphrase := fs.String("passphrase", "", "passphrase protecting the mnemonic")
+	gap := fs.Int("gap", 20, "number of consecutive unused addresses ending the scan")
+	walletPassphrase := fs.String("walletpassphrase", "", "passphrase of the wallet file")
+	unencrypted := fs.Bool("unencrypted", false, "allow a new wallet file without a passphrase")
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+	words, err := cli.secret(*mnemonic, "Recovery mnemonic", envMnemonic)
+	if err != nil {
+		return err
+	}
+	if words == "" {
+		return &usageError{"restorewallet requires -mnemonic or " + envMnemonic}
+	}
+	seedPassphrase, err := cli.secret(*passphrase, "Mnemonic passphrase", envMnemonicPassphrase)
+	if err != nil {
+		return err
+	}
+
+	wallets, err := cli.createWallets(*walletPassphrase, *unencrypted)
+	if err != nil {
+		return err
+	}
+	if err := wallets.RestoreSeed(words, seedPassphrase); err != nil {
+		return err
+	}
+
+	used, err := cli.usedPubKeyHashes()
+	if err != nil && !errors.Is(err, errNoBlockchain) {
+		return err
+	}
+	err = wallets.Discover(0, *gap, func(pubKeyHash []byte) bool {
+		return used[hex.EncodeToString(pubKeyHash)]
+	})
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		return err
+	}
+
+	addresses := make([]string, 0, len(wallets.Paths))
+	for address := range wallets.Paths {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	cli.output(map[string][]string{"addresses": addresses},
+		fmt.Sprintf("Restored %d used addresses", len(addresses)))
+	return nil
+}
+
+func (cli *CLI) changePassphrase(args []string) error {
+	fs := flag.NewFlagSet("changepassphrase", flag.ContinueOnError)
+	oldPassphrase := fs.String("old", "", "current passphrase of the wallet file")
+	newPassphrase := fs.String("new", "", "new passphrase of the wallet file")
+	unencrypted := fs.Bool("unencrypted", false, "allow the empty passphrase, leaving the file unencrypted")
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+	old, err := cli.secret(*oldPassphrase, "Current wallet passphrase", envWalletPassphrase)
+	if err != nil {
+		return err
+	}
+	passphrase, err := cli.secret(*newPassphrase, "New wallet passphrase", envNewWalletPassphrase)
+	if err != nil {
+		return err
+	}
+	if passphrase == "" && !*unencrypted {
+		return &usageError{"changepassphrase: -new is empty, pass -unencrypted to remove the passphrase"}
+	}
+
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	if err := wallets.ChangePassphrase(old, passphrase); err != nil {
+		return err
+	}
+	if passphrase == "" {
+		cli.warnUnencrypted()
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		return err
+	}
+
+	cli.output(map[string]bool{"changed": true}, "Wallet passphrase changed")
+	return nil
+}
+
+// loadWallets loads the selected wallet of the data directory, locked if it has a passphrase
+func (cli *CLI) loadWallets() (*week3.Wallets, error) {
+	return week3.NewWallets(week3.WithDataDir(cli.dataDir), week3.WithName(cli.walletName))
+}
+
+// openWallets loads the selected wallet and unlocks it with the passphrase of
+// the -walletpassphrase flag value
+func (cli *CLI) openWallets(value string) (*week3.Wallets, error) {
+	passphrase, err := cli.secret(value, "Wallet passphrase", envWalletPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return nil, err
+	}
+	if err := wallets.Unlock(passphrase); err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// createWallets opens the selected wallet like openWallets, protecting a new
+// wallet with the passphrase. A new wallet is only left unencrypted when
+// unencrypted is set.
+func (cli *CLI) createWallets(value string, unencrypted bool) (*week3.Wallets, error) {
+	passphrase, err := cli.secret(value, "Wallet passphrase", envWalletPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return nil, err
+	}
+	if wallets.HasPassphrase() {
+		if err := wallets.Unlock(passphrase); err != nil {
+			return nil, err
+		}
+		return wallets, nil
+	}
+
+	if passphrase == "" {
+		if !unencrypted {
+			return nil, &usageError{fmt.Sprintf("a new wallet needs -walletpassphrase or %s, or -unencrypted to store its keys without a passphrase", envWalletPassphrase)}
+		}
+		cli.warnUnencrypted()
+	}
+	if err := wallets.ChangePassphrase("", passphrase); err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// warnUnencrypted warns that the keys of the wallet file are readable by anyone copying it
+func (cli *CLI) warnUnencrypted() {
+	fmt.Fprintln(cli.stderr, "Warning: the wallet file has no passphrase, anyone reading it can spend its coins; set one with changepassphrase")
+}
+
+// usedPubKeyHashes returns the hex public key hashes paid by any output of the blockchain
+func (cli *CLI) usedPubKeyHashes() (map[string]bool, error) {
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		return nil, err
+	}
+	defer bc.Close()
+
+	used := make(map[string]bool)
+	bci := bc.Iterator()
+	for block := bci.Next(); block != nil; block = bci.Next() {
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Vout {
+				used[hex.EncodeToString(out.PubKeyHash)] = true
+			}
+		}
+	}
+	return used, nil
+}
+
+func (cli *CLI) listAddresses(args []string) error {
+	fs := flag.NewFlagSet("listaddresses", flag.ContinueOnError)
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	wallets, err := cli.loadWallets()
+	if err != nil {
+		return err
+	}
+	addresses := wallets.GetAllAddresses()
+	sort.Strings(addresses)
+	if addresses == nil {
+		addresses = []string{}
+	}
+
+	cli.output(map[string][]string{"addresses": addresses}, strings.Join(addresses, "\n"))
+	return nil
+}
+
+func (cli *CLI) listWallets(args []string) error {
+	fs := flag.NewFlagSet("listwallets", flag.ContinueOnError)
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	names, err := week3.ListWallets(cli.dataDir)
+	if err != nil {
+		return err
+	}
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = name
+		if name == "" {
+			lines[i] = "(default)"
+		}
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	cli.output(map[string][]string{"wallets": names}, strings.Join(lines, "\n"))
+	return nil
+}
+
+func (cli *CLI) getPubKey(args []string) error {
+	fs := flag.NewFlagSet("getpubkey", flag.ContinueOnError)
+	address := fs.String("address", "", "address of the wallet")
+	walletPassphrase := fs.String("walletpassphrase", "", "passphrase of the wallet file")
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *address == "" {
+		return &usageError{"getpubkey: -address is required"}
+	}
+
+	wallets, err := cli.openWallets(*walletPassphrase)
+	if err != nil {
+		return err
+	}
+	wallet, err := wallets.GetWallet(*address)
+	if err != nil {
+		return err
+	}
+
+	pubKey := hex.EncodeToString(wallet.PublicKey)
+	cli.output(map[string]string{"address": *address, "pubkey": pubKey}, pubKey)
+	return nil
+}
+
+func (cli *CLI) createMultiSig(args []string) error {
+	fs := flag.NewFlagSet("createmultisig", flag.ContinueOnError)
+	m := fs.Int("m", 0, "number of signatures required")
+	pubKeysFlag := fs.String("pubkeys", "", "comma-separated hex public keys of the holders")
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *m <= 0 || *pubKeysFlag == "" {
+		return &usageError{"createmultisig: a positive -m and -pubkeys are required"}
+	}
+
+	var pubKeys [][]byte
+	for _, field := range strings.Split(*pubKeysFlag, ",") {
+		pubKey, err := hex.DecodeString(strings.TrimSpace(field))
+		if err != nil {
+			return fmt.Errorf("%w: %q", week3.ErrInvalidPublicKey, field)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	ms, err := week3.NewMultiSig(*m, pubKeys)
+	if err != nil {
+		return err
+	}
+
+	address := string(ms.Address())
+	redeemScript := hex.EncodeToString(ms.RedeemScript())
+	cli.output(map[string]string{"address": address, "redeemScript": redeemScript},
+		fmt.Sprintf("Address: %s\nRedeem script: %s", address, redeemScript))
+	return nil
+}
+
+func (cli *CLI) getBalance(args []string) error {
+	fs := flag.NewFlagSet("getbalance", flag.ContinueOnError)
+	address := fs.String("address", "", "address to query")
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *address == "" {
+		return &usageError{"getbalance: -address is required"}
+	}
+
+	pubKeyHash, err := pubKeyHashOf(*address)
+	if err != nil {
+		return err
+	}
+
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	balance := bc.GetBalance(pubKeyHash)
+	if week3.IsScriptHashAddress(*address) {
+		balance = 0
+		for _, utxo := range bc.FindScriptUTXO(pubKeyHash) {
+			balance += utxo.Output.Value
+		}
+	}
+	cli.output(map[string]interface{}{"address": *address, "balance": balance},
+		fmt.Sprintf("Balance of '%s': %d", *address, balance))
+	return nil
+}
+
+func (cli *CLI) send(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	from := fs.String("from", "", "source wallet address")
+	to := fs.String("to", "", "destination wallet address")
+	amount := fs.Int("amount", 0, "amount to send")
+	fee := fs.Int("fee", 0, "fee left to the miner")
+	walletPassphrase := fs.String("walletpassphrase", "", "passphrase of the wallet file")
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" || *amount <= 0 {
+		return &usageError{"send: -from, -to and a positive -amount are required"}
+	}
+	if *fee < 0 {
+		return &usageError{"send: -fee cannot be negative"}
+	}
+	if _, err := pubKeyHashOf(*from); err != nil {
+		return err
+	}
+	if _, err := pubKeyHashOf(*to); err != nil {
+		return err
+	}
+
+	wallets, err := cli.openWallets(*walletPassphrase)
+	if err != nil {
+		return err
+	}
+	wallet, err := wallets.GetWallet(*from)
+	if err != nil {
+		return err
+	}
+
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	tx, err := week3.NewTransactionFromWallet(wallet, *to, *amount, *fee, bc)
+	if err != nil {
+		return err
+	}
+
+	// The sender mines the block and collects the subsidy and the fee
+	height := bc.Height() + 1
+	reward := bc.Params().BlockSubsidy(height) + *fee
+	coinbase, err := week3.NewCoinbaseTXWithReward(*from, coinbaseData(*from, height), reward)
+	if err != nil {
+		return err
+	}
+	if err := bc.AddBlockWithTransactions("", []*transaction.Transaction{coinbase, tx}); err != nil {
+		return err
+	}
+
+	cli.output(map[string]interface{}{"txid": hex.EncodeToString(tx.ID), "height": bc.Height()},
+		fmt.Sprintf("Success! Transaction %x mined in block %d", tx.ID, bc.Height()))
+	return nil
+}
+
+// blockJSON is the JSON representation of a block
+type blockJSON struct {
+	Height       int64    `json:"height"`
+	Hash         string   `json:"hash"`
+	PrevHash     string   `json:"prevHash"`
+	MerkleRoot   string   `json:"merkleRoot"`
+	Timestamp    int64    `json:"timestamp"`
+	Bits         uint32   `json:"bits"`
+	Nonce        int      `json:"nonce"`
+	Data         string   `json:"data"`
+	Transactions []string `json:"transactions"`
+}
+
+func (cli *CLI) printChain(args []string) error {
+	fs := flag.NewFlagSet("printchain", flag.ContinueOnError)
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	blocks := []blockJSON{}
+	var text strings.Builder
+	bci := bc.Iterator()
+	for block := bci.Next(); block != nil; block = bci.Next() {
+		txids := []string{}
+		for _, tx := range block.Transactions {
+			txids = append(txids, hex.EncodeToString(tx.ID))
+		}
+
+		blocks = append(blocks, blockJSON{
+			Height:       block.Index,
+			Hash:         hex.EncodeToString(block.Hash),
+			PrevHash:     hex.EncodeToString(block.PrevBlockHash),
+			MerkleRoot:   hex.EncodeToString(block.MerkleRoot),
+			Timestamp:    block.Timestamp,
+			Bits:         block.Bits,
+			Nonce:        block.Nonce,
+			Data:         string(block.Data),
+			Transactions: txids,
+		})
+
+		fmt.Fprintf(&text, "============ Block %x ============\n", block.Hash)
+		fmt.Fprintf(&text, "Height: %d\n", block.Index)
+		fmt.Fprintf(&text, "Prev. block: %x\n", block.PrevBlockHash)
+		fmt.Fprintf(&text, "Timestamp: %d\n", block.Timestamp)
+		fmt.Fprintf(&text, "Data: %s\n", block.Data)
+		for _, txid := range txids {
+			fmt.Fprintf(&text, "Transaction: %s\n", txid)
+		}
+		fmt.Fprintln(&text)
+	}
+
+	cli.output(map[string][]blockJSON{"blocks": blocks}, strings.TrimRight(text.String(), "\n"))
+	return nil
+}
+
+func (cli *CLI) validate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	if err := bc.Validate(); err != nil {
+		return fmt.Errorf("blockchain is invalid: %w", err)
+	}
+
+	cli.output(map[string]interface{}{"valid": true, "height": bc.Height()}, "Blockchain is valid!")
+	return nil
+}
+
+func (cli *CLI) reindex(args []string) error {
+	fs := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		return err
+	}
+
+	if err := bc.Reindex(); err != nil {
+		bc.Close()
+		return err
+	}
+	if err := bc.Close(); err != nil {
+		return err
+	}
+
+	count := bc.UTXO.Count()
+	cli.output(map[string]interface{}{"outputs": count},
+		fmt.Sprintf("Done! There are %d unspent outputs in the UTXO set.", count))
+	return nil
+}
+
+func (cli *CLI) startNode(args []string) error {
+	fs := flag.NewFlagSet("startnode", flag.ContinueOnError)
+	host := fs.String("host", "localhost", "address to listen on")
+	port := fs.Int("port", 3000, "port to listen on")
+	if err := cli.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	node := newNode(*host, *port, bc)
+	done := make(chan struct{})
+	defer close(done)
+	go expireMempool(node.Mempool, mempoolExpiryInterval, done)
+
+	return node.Start()
+}
+
+// newNode creates a node relaying transactions through a mempool. The mempool
+// observes the chain after the UTXO set registered by OpenBlockchain, so that
+// it sees the outputs of the blocks it is notified of.
+func newNode(host string, port int, bc *week3.Blockchain) *week5.Node {
+	node := week5.NewNode(host, port, bc.Blockchain)
+	node.Mempool = mempool.New(bc.UTXO, mempool.DefaultConfig())
+	bc.AddObserver(node.Mempool)
+	return node
+}
+
+// expireMempool drops the expired transactions of pool every interval until done is closed
+func expireMempool(pool *mempool.Mempool, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			pool.Expire(now)
+		case <-done:
+			return
+		}
+	}
+}
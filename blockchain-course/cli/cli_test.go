@@ -0,0 +1,333 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module2/week3"
+)
+
+// runCLI runs a command line in JSON mode and decodes its output
+func runCLI(t *testing.T, dataDir string, args ...string) (int, map[string]interface{}) {
+	t.Helper()
+	return runCLIWithSecrets(t, "", nil, dataDir, args...)
+}
+
+// runCLIWithSecrets runs a command line reading stdin and the environment env
+func runCLIWithSecrets(t *testing.T, stdin string, env map[string]string, dataDir string, args ...string) (int, map[string]interface{}) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	cli := NewCLI(&stdout, &stderr)
+	cli.stdin = bufio.NewReader(strings.NewReader(stdin))
+	cli.getenv = func(key string) string { return env[key] }
+	code := cli.Run(append([]string{"-datadir", dataDir, "-json"}, args...))
+
+	result := make(map[string]interface{})
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("Output of %v is not JSON: %q", args, stdout.String())
+	}
+	return code, result
+}
+
+func TestSendAndBalances(t *testing.T) {
+	dataDir := t.TempDir()
+
+	_, alice := runCLI(t, dataDir, "createwallet", "-unencrypted")
+	_, bob := runCLI(t, dataDir, "createwallet", "-unencrypted")
+	aliceAddress := alice["address"].(string)
+	bobAddress := bob["address"].(string)
+
+	if code, _ := runCLI(t, dataDir, "createblockchain", "-address", aliceAddress); code != ExitOK {
+		t.Fatalf("createblockchain failed with code %d", code)
+	}
+
+	code, result := runCLI(t, dataDir, "getbalance", "-address", aliceAddress)
+	if code != ExitOK || result["balance"].(float64) != 10 {
+		t.Errorf("Expected genesis reward of 10, got %v", result)
+	}
+
+	code, result = runCLI(t, dataDir, "send", "-from", aliceAddress, "-to", bobAddress, "-amount", "3", "-fee", "1")
+	if code != ExitOK {
+		t.Fatalf("send failed with code %d: %v", code, result)
+	}
+
+	// Alice keeps the change and mines the block, collecting the subsidy and the fee back
+	_, result = runCLI(t, dataDir, "getbalance", "-address", aliceAddress)
+	if result["balance"].(float64) != 17 {
+		t.Errorf("Expected Alice balance of 17, got %v", result["balance"])
+	}
+	_, result = runCLI(t, dataDir, "getbalance", "-address", bobAddress)
+	if result["balance"].(float64) != 3 {
+		t.Errorf("Expected Bob balance of 3, got %v", result["balance"])
+	}
+
+	code, result = runCLI(t, dataDir, "printchain")
+	if code != ExitOK || len(result["blocks"].([]interface{})) != 2 {
+		t.Errorf("Expected two blocks, got %v", result)
+	}
+
+	// The reward and change of Alice and the payment to Bob are unspent
+	code, result = runCLI(t, dataDir, "reindex")
+	if code != ExitOK || result["outputs"].(float64) != 3 {
+		t.Errorf("Expected three unspent outputs after reindex, got %v", result)
+	}
+
+	if code, _ := runCLI(t, dataDir, "validate"); code != ExitOK {
+		t.Errorf("validate failed with code %d", code)
+	}
+
+	code, result = runCLI(t, dataDir, "listaddresses")
+	if code != ExitOK || len(result["addresses"].([]interface{})) != 2 {
+		t.Errorf("Expected two addresses, got %v", result)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	dataDir := t.TempDir()
+
+	if code, _ := runCLI(t, dataDir, "unknown"); code != ExitUsage {
+		t.Errorf("Unknown command should exit with %d, got %d", ExitUsage, code)
+	}
+
+	if code, _ := runCLI(t, dataDir, "getbalance"); code != ExitUsage {
+		t.Errorf("Missing flag should exit with %d, got %d", ExitUsage, code)
+	}
+
+	code, result := runCLI(t, dataDir, "getbalance", "-address", "invalid")
+	if code != ExitError || result["error"] == nil {
+		t.Errorf("Invalid address should fail with an error, got %d %v", code, result)
+	}
+
+	_, wallet := runCLI(t, dataDir, "createwallet", "-unencrypted")
+	if code, _ := runCLI(t, dataDir, "getbalance", "-address", wallet["address"].(string)); code != ExitError {
+		t.Errorf("Missing blockchain should exit with %d, got %d", ExitError, code)
+	}
+}
+
+func TestRestoreWallet(t *testing.T) {
+	dataDir := t.TempDir()
+
+	_, first := runCLI(t, dataDir, "createwallet", "-unencrypted")
+	mnemonic, ok := first["mnemonic"].(string)
+	if !ok {
+		t.Fatalf("First wallet should come with a mnemonic, got %v", first)
+	}
+	_, second := runCLI(t, dataDir, "createwallet", "-unencrypted")
+	if second["mnemonic"] != nil {
+		t.Error("Mnemonic should only be shown once")
+	}
+
+	address := first["address"].(string)
+	if code, _ := runCLI(t, dataDir, "createblockchain", "-address", address); code != ExitOK {
+		t.Fatalf("createblockchain failed with code %d", code)
+	}
+
+	// Restoring into another wallet only recovers the used addresses
+	code, result := runCLI(t, dataDir, "-wallet", "restored", "restorewallet", "-unencrypted", "-mnemonic", mnemonic)
+	addresses, _ := result["addresses"].([]interface{})
+	if code != ExitOK || len(addresses) != 1 || addresses[0] != address {
+		t.Errorf("Expected %s to be restored, got %d %v", address, code, result)
+	}
+
+	code, result = runCLI(t, dataDir, "listwallets")
+	wallets, _ := result["wallets"].([]interface{})
+	if code != ExitOK || len(wallets) != 2 || wallets[0] != "" || wallets[1] != "restored" {
+		t.Errorf("Expected the default and restored wallets, got %v", result)
+	}
+
+	code, result = runCLI(t, dataDir, "restorewallet", "-mnemonic", "abandon abandon")
+	if code != ExitError || result["error"] == nil {
+		t.Errorf("Invalid mnemonic should fail, got %d %v", code, result)
+	}
+}
+
+func TestWalletPassphrase(t *testing.T) {
+	dataDir := t.TempDir()
+
+	_, wallet := runCLI(t, dataDir, "createwallet", "-unencrypted")
+	address := wallet["address"].(string)
+	if code, _ := runCLI(t, dataDir, "createblockchain", "-address", address); code != ExitOK {
+		t.Fatalf("createblockchain failed with code %d", code)
+	}
+
+	if code, result := runCLI(t, dataDir, "changepassphrase", "-new", "secret"); code != ExitOK {
+		t.Fatalf("changepassphrase failed with code %d: %v", code, result)
+	}
+	if code, _ := runCLI(t, dataDir, "changepassphrase", "-old", "wrong", "-new", "other"); code != ExitError {
+		t.Errorf("Wrong passphrase should exit with %d, got %d", ExitError, code)
+	}
+
+	// Addresses are listed without the passphrase, spending requires it
+	code, result := runCLI(t, dataDir, "listaddresses")
+	if code != ExitOK || len(result["addresses"].([]interface{})) != 1 {
+		t.Errorf("Expected one address, got %v", result)
+	}
+	if code, _ := runCLI(t, dataDir, "send", "-from", address, "-to", address, "-amount", "1"); code != ExitError {
+		t.Errorf("send without the passphrase should exit with %d, got %d", ExitError, code)
+	}
+	code, result = runCLI(t, dataDir, "send", "-from", address, "-to", address, "-amount", "1", "-walletpassphrase", "secret")
+	if code != ExitOK {
+		t.Errorf("send with the passphrase failed with code %d: %v", code, result)
+	}
+}
+
+func TestWalletSecrets(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// A new wallet needs a passphrase unless it is explicitly left unencrypted
+	if code, _ := runCLI(t, dataDir, "createwallet"); code != ExitUsage {
+		t.Errorf("createwallet without a passphrase should exit with %d, got %d", ExitUsage, code)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dataDir, "*.dat")); len(names) != 0 {
+		t.Errorf("No wallet file should be written, got %v", names)
+	}
+
+	env := map[string]string{envWalletPassphrase: "secret"}
+	code, wallet := runCLIWithSecrets(t, "", env, dataDir, "createwallet")
+	if code != ExitOK {
+		t.Fatalf("createwallet with the passphrase in the environment failed with code %d: %v", code, wallet)
+	}
+	address := wallet["address"].(string)
+
+	if code, _ := runCLI(t, dataDir, "getpubkey", "-address", address); code != ExitError {
+		t.Errorf("getpubkey without the passphrase should exit with %d, got %d", ExitError, code)
+	}
+	if code, result := runCLIWithSecrets(t, "secret\n", nil, dataDir, "getpubkey", "-address", address, "-walletpassphrase", "-"); code != ExitOK {
+		t.Errorf("getpubkey with the passphrase on stdin failed with code %d: %v", code, result)
+	}
+	if code, _ := runCLIWithSecrets(t, "", nil, dataDir, "getpubkey", "-address", address, "-walletpassphrase", "-"); code != ExitError {
+		t.Errorf("getpubkey with an empty stdin should exit with %d, got %d", ExitError, code)
+	}
+
+	// Removing the passphrase must be explicit too
+	if code, _ := runCLIWithSecrets(t, "", env, dataDir, "changepassphrase"); code != ExitUsage {
+		t.Errorf("changepassphrase to the empty passphrase should exit with %d, got %d", ExitUsage, code)
+	}
+	if code, result := runCLIWithSecrets(t, "", env, dataDir, "changepassphrase", "-unencrypted"); code != ExitOK {
+		t.Errorf("changepassphrase -unencrypted failed with code %d: %v", code, result)
+	}
+	if code, result := runCLI(t, dataDir, "getpubkey", "-address", address); code != ExitOK {
+		t.Errorf("getpubkey of an unencrypted wallet failed with code %d: %v", code, result)
+	}
+}
+
+func TestMultiSigAddress(t *testing.T) {
+	dataDir := t.TempDir()
+
+	var pubKeys []string
+	for i := 0; i < 3; i++ {
+		_, wallet := runCLI(t, dataDir, "createwallet", "-unencrypted")
+		code, result := runCLI(t, dataDir, "getpubkey", "-address", wallet["address"].(string))
+		if code != ExitOK {
+			t.Fatalf("getpubkey failed with code %d: %v", code, result)
+		}
+		pubKeys = append(pubKeys, result["pubkey"].(string))
+	}
+
+	code, multisig := runCLI(t, dataDir, "createmultisig", "-m", "2", "-pubkeys", strings.Join(pubKeys, ","))
+	if code != ExitOK {
+		t.Fatalf("createmultisig failed with code %d: %v", code, multisig)
+	}
+	address := multisig["address"].(string)
+
+	// The treasury receives the genesis reward
+	if code, _ := runCLI(t, dataDir, "createblockchain", "-address", address); code != ExitOK {
+		t.Fatalf("createblockchain failed with code %d", code)
+	}
+	code, result := runCLI(t, dataDir, "getbalance", "-address", address)
+	if code != ExitOK || result["balance"].(float64) != 10 {
+		t.Errorf("Expected multisig balance of 10, got %v", result)
+	}
+
+	if code, _ := runCLI(t, dataDir, "createmultisig", "-m", "4", "-pubkeys", strings.Join(pubKeys, ",")); code != ExitError {
+		t.Errorf("Expected 4 of 3 to fail with ExitError, got %d", code)
+	}
+}
+
+func TestWalletSchemes(t *testing.T) {
+	dataDir := t.TempDir()
+
+	_, alice := runCLI(t, dataDir, "createwallet", "-unencrypted", "-scheme", "ed25519")
+	_, bob := runCLI(t, dataDir, "createwallet", "-unencrypted", "-scheme", "secp256k1")
+	aliceAddress := alice["address"].(string)
+	bobAddress := bob["address"].(string)
+	if alice["scheme"] != "ed25519" || bob["scheme"] != "secp256k1" {
+		t.Fatalf("Unexpected schemes %v and %v", alice, bob)
+	}
+
+	if code, _ := runCLI(t, dataDir, "createblockchain", "-address", aliceAddress); code != ExitOK {
+		t.Fatalf("createblockchain failed with code %d", code)
+	}
+	if code, result := runCLI(t, dataDir, "send", "-from", aliceAddress, "-to", bobAddress, "-amount", "4"); code != ExitOK {
+		t.Fatalf("send failed with code %d: %v", code, result)
+	}
+	if code, result := runCLI(t, dataDir, "send", "-from", bobAddress, "-to", aliceAddress, "-amount", "1"); code != ExitOK {
+		t.Fatalf("send failed with code %d: %v", code, result)
+	}
+	// Bob keeps 3 and collects the subsidy of the block mining the payment
+	if _, result := runCLI(t, dataDir, "getbalance", "-address", bobAddress); result["balance"].(float64) != 13 {
+		t.Errorf("Expected Bob balance of 13, got %v", result["balance"])
+	}
+	if code, _ := runCLI(t, dataDir, "validate"); code != ExitOK {
+		t.Errorf("validate failed with code %d", code)
+	}
+
+	if code, _ := runCLI(t, dataDir, "createwallet", "-scheme", "rsa"); code != ExitUsage {
+		t.Errorf("Unknown scheme should exit with %d, got %d", ExitUsage, code)
+	}
+}
+
+func TestNodeMempool(t *testing.T) {
+	dataDir := t.TempDir()
+
+	_, wallet := runCLI(t, dataDir, "createwallet", "-unencrypted")
+	address := wallet["address"].(string)
+	if code, _ := runCLI(t, dataDir, "createblockchain", "-address", address); code != ExitOK {
+		t.Fatalf("createblockchain failed with code %d", code)
+	}
+
+	cli := NewCLI(io.Discard, io.Discard)
+	cli.dataDir = dataDir
+	bc, err := cli.openBlockchain()
+	if err != nil {
+		t.Fatalf("Failed to open blockchain: %v", err)
+	}
+	defer bc.Close()
+	node := newNode("localhost", 0, bc)
+
+	// The node pools relayed transactions
+	wallets, err := cli.openWallets("")
+	if err != nil {
+		t.Fatalf("Failed to open wallets: %v", err)
+	}
+	sender, err := wallets.GetWallet(address)
+	if err != nil {
+		t.Fatalf("Failed to get wallet: %v", err)
+	}
+	tx, err := week3.NewTransactionFromWallet(sender, address, 3, 1, bc)
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := node.Mempool.Add(tx); err != nil || node.Mempool.Count() != 1 {
+		t.Fatalf("Failed to pool transaction: %v", err)
+	}
+
+	// and forgets them once they are mined
+	coinbase, err := week3.NewCoinbaseTXWithReward(address, "", bc.Params().BlockSubsidy(1)+1)
+	if err != nil {
+		t.Fatalf("Failed to create coinbase: %v", err)
+	}
+	if err := bc.AddBlockWithTransactions("", []*transaction.Transaction{coinbase, tx}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+	if count := node.Mempool.Count(); count != 0 {
+		t.Errorf("Mined transaction should leave the mempool, %d left", count)
+	}
+}
@@ -2,4 +2,11 @@ module blockchain-course
 
 go 1.25.2
 
-require golang.org/x/crypto v0.44.0
+require (
+	github.com/btcsuite/btcd/btcec/v2 v2.3.4
+	github.com/btcsuite/btcd/chaincfg/chainhash v1.2.0
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.1
+	golang.org/x/crypto v0.44.0
+)
+
+require github.com/decred/dcrd/crypto/blake256 v1.1.0 // indirect
@@ -0,0 +1,105 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// encodeSignature returns r and s padded to 32 bytes each. Both (r, s) and
+// (r, n-s) are valid, so s is normalised to the lower half of the order n to
+// leave a single valid encoding.
+func encodeSignature(n, r, s *big.Int) []byte {
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s = new(big.Int).Sub(n, s)
+	}
+
+	signature := make([]byte, SignatureSize)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature
+}
+
+// parseSignature splits a signature written by encodeSignature. Signatures
+// with r or s out of range or with s in the upper half of the order are
+// rejected, so that a third party cannot change the ID of a transaction by
+// negating s.
+func parseSignature(n *big.Int, signature []byte) (r, s *big.Int, ok bool) {
+	if len(signature) != SignatureSize {
+		return nil, nil, false
+	}
+	r = new(big.Int).SetBytes(signature[:32])
+	s = new(big.Int).SetBytes(signature[32:])
+	if r.Sign() == 0 || r.Cmp(n) >= 0 || s.Sign() == 0 || s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		return nil, nil, false
+	}
+	return r, s, true
+}
+
+// p256Signer signs with ECDSA on P-256
+type p256Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+func generateP256() (Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &p256Signer{key}, nil
+}
+
+func newP256Signer(key []byte) (Signer, error) {
+	private, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrivateKey, err)
+	}
+	return &p256Signer{private}, nil
+}
+
+func (s *p256Signer) Scheme() Scheme { return SchemeP256 }
+
+func (s *p256Signer) Public() Verifier {
+	return &p256Verifier{&s.key.PublicKey}
+}
+
+func (s *p256Signer) Sign(digest []byte) ([]byte, error) {
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSignature(s.key.Curve.Params().N, r, sig), nil
+}
+
+func (s *p256Signer) Bytes() []byte {
+	// The key was created on P-256 so it always has a raw encoding
+	key, _ := s.key.Bytes()
+	return tagged(SchemeP256, key)
+}
+
+// p256Verifier checks ECDSA signatures on P-256
+type p256Verifier struct {
+	key *ecdsa.PublicKey
+}
+
+func newP256Verifier(pubKey []byte) (Verifier, error) {
+	curve := elliptic.P256()
+	x, y := elliptic.UnmarshalCompressed(curve, pubKey)
+	if x == nil {
+		return nil, fmt.Errorf("%w: %x", ErrInvalidPublicKey, pubKey)
+	}
+	return &p256Verifier{&ecdsa.PublicKey{Curve: curve, X: x, Y: y}}, nil
+}
+
+func (v *p256Verifier) Scheme() Scheme { return SchemeP256 }
+
+func (v *p256Verifier) Verify(digest, signature []byte) bool {
+	r, s, ok := parseSignature(v.key.Curve.Params().N, signature)
+	return ok && ecdsa.Verify(v.key, digest, r, s)
+}
+
+func (v *p256Verifier) Bytes() []byte {
+	return tagged(SchemeP256, elliptic.MarshalCompressed(v.key.Curve, v.key.X, v.key.Y))
+}
@@ -0,0 +1,65 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+)
+
+// ed25519Signer signs with Ed25519. Ed25519 hashes what it signs itself, the
+// digest is signed as the message.
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func generateEd25519() (Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &ed25519Signer{key}, nil
+}
+
+func newEd25519Signer(seed []byte) (Signer, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%w: %d bytes, expected %d", ErrInvalidPrivateKey, len(seed), ed25519.SeedSize)
+	}
+	return &ed25519Signer{ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func (s *ed25519Signer) Scheme() Scheme { return SchemeEd25519 }
+
+func (s *ed25519Signer) Public() Verifier {
+	return &ed25519Verifier{s.key.Public().(ed25519.PublicKey)}
+}
+
+func (s *ed25519Signer) Sign(digest []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, digest), nil
+}
+
+func (s *ed25519Signer) Bytes() []byte {
+	return tagged(SchemeEd25519, s.key.Seed())
+}
+
+// ed25519Verifier checks Ed25519 signatures. Verification rejects a second
+// encoding of s, so that signatures cannot be malleated.
+type ed25519Verifier struct {
+	key ed25519.PublicKey
+}
+
+func newEd25519Verifier(pubKey []byte) (Verifier, error) {
+	if len(pubKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: %x", ErrInvalidPublicKey, pubKey)
+	}
+	return &ed25519Verifier{ed25519.PublicKey(append([]byte(nil), pubKey...))}, nil
+}
+
+func (v *ed25519Verifier) Scheme() Scheme { return SchemeEd25519 }
+
+func (v *ed25519Verifier) Verify(digest, signature []byte) bool {
+	return len(signature) == SignatureSize && ed25519.Verify(v.key, digest, signature)
+}
+
+func (v *ed25519Verifier) Bytes() []byte {
+	return tagged(SchemeEd25519, v.key)
+}
@@ -0,0 +1,192 @@
+// Package keys implements the signature schemes of the chain behind the
+// Signer and Verifier interfaces: ECDSA on P-256 and on secp256k1, Ed25519
+// and Schnorr on secp256k1.
+//
+// Keys are encoded with their scheme as first byte, so that a public key in
+// an input or a script records how its signatures are checked.
+package keys
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+)
+
+// Scheme identifies a signature scheme
+type Scheme byte
+
+const (
+	// SchemeP256 is ECDSA on NIST P-256
+	SchemeP256 Scheme = 0x01
+	// SchemeSecp256k1 is ECDSA on secp256k1, the curve of Bitcoin and Ethereum
+	SchemeSecp256k1 Scheme = 0x02
+	// SchemeEd25519 is Ed25519
+	SchemeEd25519 Scheme = 0x03
+	// SchemeSchnorr is BIP340 Schnorr on secp256k1, whose signatures can be
+	// verified in batches
+	SchemeSchnorr Scheme = 0x04
+)
+
+// SignatureSize is the length of the signatures of every scheme
+const SignatureSize = 64
+
+var (
+	// ErrUnsupportedScheme is returned for unknown schemes, or schemes an
+	// operation is not available for
+	ErrUnsupportedScheme = errors.New("unsupported signature scheme")
+	// ErrInvalidPrivateKey is returned for private keys that are out of range
+	// or of the wrong length
+	ErrInvalidPrivateKey = errors.New("invalid private key")
+	// ErrInvalidPublicKey is returned for public keys that are not a point of the curve
+	ErrInvalidPublicKey = errors.New("invalid public key")
+)
+
+// Schemes lists the supported schemes
+var Schemes = []Scheme{SchemeP256, SchemeSecp256k1, SchemeEd25519, SchemeSchnorr}
+
+var schemeNames = map[Scheme]string{
+	SchemeP256:      "p256",
+	SchemeSecp256k1: "secp256k1",
+	SchemeEd25519:   "ed25519",
+	SchemeSchnorr:   "schnorr",
+}
+
+func (s Scheme) String() string {
+	if name, ok := schemeNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Scheme(%#x)", byte(s))
+}
+
+// ParseScheme returns the scheme named name, as printed by String
+func ParseScheme(name string) (Scheme, error) {
+	for scheme, schemeName := range schemeNames {
+		if schemeName == name {
+			return scheme, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnsupportedScheme, name)
+}
+
+// Signer signs digests with a private key
+type Signer interface {
+	Scheme() Scheme
+	// Public returns the Verifier of the public key
+	Public() Verifier
+	// Sign returns a SignatureSize-byte signature of digest
+	Sign(digest []byte) ([]byte, error)
+	// Bytes returns the scheme followed by the private key
+	Bytes() []byte
+}
+
+// Verifier checks signatures made by the Signer of a public key
+type Verifier interface {
+	Scheme() Scheme
+	Verify(digest, signature []byte) bool
+	// Bytes returns the scheme followed by the compressed public key
+	Bytes() []byte
+}
+
+// GenerateKey creates a random private key of scheme
+func GenerateKey(scheme Scheme) (Signer, error) {
+	switch scheme {
+	case SchemeP256:
+		return generateP256()
+	case SchemeSecp256k1:
+		return generateSecp256k1()
+	case SchemeEd25519:
+		return generateEd25519()
+	case SchemeSchnorr:
+		return generateSchnorr()
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, scheme)
+}
+
+// NewSigner returns the Signer of a raw private key of scheme: the 32-byte
+// scalar for the ECDSA and Schnorr schemes and the 32-byte seed for Ed25519
+func NewSigner(scheme Scheme, key []byte) (Signer, error) {
+	switch scheme {
+	case SchemeP256:
+		return newP256Signer(key)
+	case SchemeSecp256k1:
+		return newSecp256k1Signer(key)
+	case SchemeEd25519:
+		return newEd25519Signer(key)
+	case SchemeSchnorr:
+		return newSchnorrSigner(key)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, scheme)
+}
+
+// NewVerifier returns the Verifier of a raw public key of scheme: the
+// compressed SEC1 point for the ECDSA schemes, the 32-byte key for Ed25519
+// and the 32-byte x coordinate for Schnorr
+func NewVerifier(scheme Scheme, pubKey []byte) (Verifier, error) {
+	switch scheme {
+	case SchemeP256:
+		return newP256Verifier(pubKey)
+	case SchemeSecp256k1:
+		return newSecp256k1Verifier(pubKey)
+	case SchemeEd25519:
+		return newEd25519Verifier(pubKey)
+	case SchemeSchnorr:
+		return newSchnorrVerifier(pubKey)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, scheme)
+}
+
+// ParsePrivateKey decodes a private key written by Signer.Bytes
+func ParsePrivateKey(data []byte) (Signer, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidPrivateKey
+	}
+	return NewSigner(Scheme(data[0]), data[1:])
+}
+
+// ParsePublicKey decodes a public key written by Verifier.Bytes
+func ParsePublicKey(data []byte) (Verifier, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidPublicKey
+	}
+	return NewVerifier(Scheme(data[0]), data[1:])
+}
+
+// CryptoSigner returns the standard library key of a Signer, for use with
+// packages such as crypto/x509. The secp256k1 schemes have no such key.
+func CryptoSigner(s Signer) (crypto.Signer, error) {
+	switch s := s.(type) {
+	case *p256Signer:
+		return s.key, nil
+	case *ed25519Signer:
+		return s.key, nil
+	}
+	return nil, fmt.Errorf("%w: %s has no standard library key", ErrUnsupportedScheme, s.Scheme())
+}
+
+// BatchItem is a signature to check with VerifyBatch
+type BatchItem struct {
+	Verifier  Verifier
+	Digest    []byte
+	Signature []byte
+}
+
+// VerifyBatch reports whether every signature of items is valid. Schnorr
+// signatures are checked together, which is faster than one by one from a few
+// dozen signatures but does not tell which signature is invalid. The other
+// schemes are checked one by one.
+func VerifyBatch(items []BatchItem) bool {
+	var schnorr []BatchItem
+	for _, item := range items {
+		if _, ok := item.Verifier.(*schnorrVerifier); ok {
+			schnorr = append(schnorr, item)
+		} else if !item.Verifier.Verify(item.Digest, item.Signature) {
+			return false
+		}
+	}
+	return len(schnorr) == 0 || verifySchnorrBatch(schnorr)
+}
+
+// tagged prefixes a raw key with its scheme
+func tagged(scheme Scheme, key []byte) []byte {
+	return append([]byte{byte(scheme)}, key...)
+}
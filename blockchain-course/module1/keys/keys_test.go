@@ -0,0 +1,324 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"testing"
+
+	secp "github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	digest := sha256.Sum256([]byte("message"))
+	other := sha256.Sum256([]byte("other message"))
+
+	for _, scheme := range Schemes {
+		signer, err := GenerateKey(scheme)
+		if err != nil {
+			t.Fatalf("%s: failed to generate key: %v", scheme, err)
+		}
+		signature, err := signer.Sign(digest[:])
+		if err != nil || len(signature) != SignatureSize {
+			t.Fatalf("%s: failed to sign: %x, %v", scheme, signature, err)
+		}
+
+		verifier := signer.Public()
+		if verifier.Scheme() != scheme || !verifier.Verify(digest[:], signature) {
+			t.Errorf("%s: signature failed to verify", scheme)
+		}
+		if verifier.Verify(other[:], signature) {
+			t.Errorf("%s: signature should not verify another digest", scheme)
+		}
+		if verifier.Verify(digest[:], signature[:SignatureSize-1]) {
+			t.Errorf("%s: truncated signature should not verify", scheme)
+		}
+
+		// Keys survive a round trip through their encoding
+		parsed, err := ParsePrivateKey(signer.Bytes())
+		if err != nil || !bytes.Equal(parsed.Public().Bytes(), verifier.Bytes()) {
+			t.Errorf("%s: private key round trip failed: %v", scheme, err)
+		}
+		parsedPub, err := ParsePublicKey(verifier.Bytes())
+		if err != nil || !parsedPub.Verify(digest[:], signature) {
+			t.Errorf("%s: public key round trip failed: %v", scheme, err)
+		}
+		if verifier.Bytes()[0] != byte(scheme) {
+			t.Errorf("%s: public key should start with its scheme", scheme)
+		}
+
+		// A key of another scheme does not verify the signature
+		for _, otherScheme := range Schemes {
+			if otherScheme == scheme {
+				continue
+			}
+			otherSigner, _ := GenerateKey(otherScheme)
+			if otherSigner.Public().Verify(digest[:], signature) {
+				t.Errorf("%s: signature verified with a %s key", scheme, otherScheme)
+			}
+		}
+	}
+}
+
+func TestLowS(t *testing.T) {
+	digest := sha256.Sum256([]byte("message"))
+	for _, scheme := range []Scheme{SchemeP256, SchemeSecp256k1} {
+		signer, _ := GenerateKey(scheme)
+		n := secp.S256().N
+		if scheme == SchemeP256 {
+			n = signer.(*p256Signer).key.Curve.Params().N
+		}
+		for i := 0; i < 20; i++ {
+			signature, _ := signer.Sign(digest[:])
+			s := new(big.Int).SetBytes(signature[32:])
+			if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+				t.Fatalf("%s: signature has a high s", scheme)
+			}
+
+			// Negating s gives a valid ECDSA signature that is not accepted
+			malleated := bytes.Clone(signature)
+			new(big.Int).Sub(n, s).FillBytes(malleated[32:])
+			if signer.Public().Verify(digest[:], malleated) {
+				t.Fatalf("%s: signature with a high s should not verify", scheme)
+			}
+		}
+	}
+}
+
+func TestSecp256k1Vectors(t *testing.T) {
+	vectors := []struct {
+		key    int64
+		pubKey string
+	}{
+		{1, "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{2, "02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"},
+		{3, "02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"},
+		{7, "025cbdf0646e5db4eaa398f365f2ea7a0e3d419b7e0330e39ce92bddedcac4f9bc"},
+	}
+	for _, v := range vectors {
+		key := make([]byte, 32)
+		big.NewInt(v.key).FillBytes(key)
+		signer, err := NewSigner(SchemeSecp256k1, key)
+		if err != nil {
+			t.Fatalf("Failed to create signer: %v", err)
+		}
+		if pubKey := hex.EncodeToString(signer.Public().Bytes()[1:]); pubKey != v.pubKey {
+			t.Errorf("Unexpected public key of %d: %s, expected %s", v.key, pubKey, v.pubKey)
+		}
+	}
+
+	if _, err := NewSigner(SchemeSecp256k1, secp.S256().N.Bytes()); !errors.Is(err, ErrInvalidPrivateKey) {
+		t.Errorf("Expected ErrInvalidPrivateKey for n, got %v", err)
+	}
+}
+
+func TestEd25519Vector(t *testing.T) {
+	// Test 1 of RFC 8032
+	seed, _ := hex.DecodeString("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	signer, err := NewSigner(SchemeEd25519, seed)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	if pubKey := hex.EncodeToString(signer.Public().Bytes()[1:]); pubKey != "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a" {
+		t.Errorf("Unexpected public key %s", pubKey)
+	}
+	signature, _ := signer.Sign(nil)
+	if hex.EncodeToString(signature) != "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b" {
+		t.Errorf("Unexpected signature %x", signature)
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	if _, err := ParsePublicKey(nil); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("Expected ErrInvalidPublicKey for an empty key, got %v", err)
+	}
+	if _, err := ParsePublicKey([]byte{0x7f, 0x02}); !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("Expected ErrUnsupportedScheme, got %v", err)
+	}
+
+	// x = 5 has no point on secp256k1 since 5³ + 7 is not a square
+	notOnCurve := make([]byte, 33)
+	notOnCurve[0], notOnCurve[32] = 0x02, 5
+	if _, err := NewVerifier(SchemeSecp256k1, notOnCurve); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("Expected ErrInvalidPublicKey for a point off the curve, got %v", err)
+	}
+
+	for _, scheme := range Schemes {
+		signer, _ := GenerateKey(scheme)
+		uncompressed := append(signer.Public().Bytes()[1:], 0x00)
+		if _, err := NewVerifier(scheme, uncompressed); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("%s: expected ErrInvalidPublicKey for a key of the wrong length, got %v", scheme, err)
+		}
+	}
+
+	if scheme, err := ParseScheme("secp256k1"); err != nil || scheme != SchemeSecp256k1 {
+		t.Errorf("Unexpected scheme %s, %v", scheme, err)
+	}
+	if _, err := ParseScheme("rsa"); !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("Expected ErrUnsupportedScheme, got %v", err)
+	}
+}
+
+func TestSchnorrVectors(t *testing.T) {
+	// Test vectors 0 and 1 of BIP340
+	vectors := []struct {
+		key, pubKey, aux, digest, signature string
+	}{
+		{
+			"0000000000000000000000000000000000000000000000000000000000000003",
+			"f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"e907831f80848d1069a5371b402410364bdf1c5f8307b0084c55f1ce2dca821525f66a4a85ea8b71e482a74f382d2ce5ebeee8fdb2172f477df4900d310536c0",
+		},
+		{
+			"b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef",
+			"dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659",
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			"6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de33418906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a",
+		},
+	}
+	for i, v := range vectors {
+		key, _ := hex.DecodeString(v.key)
+		var aux [32]byte
+		hex.Decode(aux[:], []byte(v.aux))
+		digest, _ := hex.DecodeString(v.digest)
+		signer, err := NewSigner(SchemeSchnorr, key)
+		if err != nil {
+			t.Fatalf("Vector %d: failed to create signer: %v", i, err)
+		}
+		if pubKey := hex.EncodeToString(signer.Public().Bytes()[1:]); pubKey != v.pubKey {
+			t.Errorf("Vector %d: unexpected public key %s", i, pubKey)
+		}
+		signature, err := signer.(*schnorrSigner).sign(digest, aux)
+		if err != nil {
+			t.Fatalf("Vector %d: failed to sign: %v", i, err)
+		}
+		if hex.EncodeToString(signature) != v.signature {
+			t.Errorf("Vector %d: unexpected signature %x", i, signature)
+		}
+		if !signer.Public().Verify(digest, signature) {
+			t.Errorf("Vector %d: signature should verify", i)
+		}
+	}
+}
+
+func TestMultiScalarMult(t *testing.T) {
+	var points []secp.JacobianPoint
+	var scalars []secp.ModNScalar
+	var expected secp.JacobianPoint
+	for i := 0; i < 40; i++ {
+		var pt, product secp.JacobianPoint
+		secp.ScalarBaseMultNonConst(new(secp.ModNScalar).SetInt(uint32(i+1)), &pt)
+		key, _ := secp.GeneratePrivateKey()
+		points = append(points, pt)
+		scalars = append(scalars, key.Key)
+		secp.ScalarMultNonConst(&key.Key, &pt, &product)
+		secp.AddNonConst(&expected, &product, &expected)
+
+		result := multiScalarMult(points, scalars)
+		if !result.EquivalentNonConst(&expected) {
+			t.Fatalf("Multi-scalar multiplication of %d points differs from the sum of scalar multiplications", i+1)
+		}
+	}
+
+	// n·G is the point at infinity
+	var n secp.ModNScalar
+	n.SetInt(1).Negate()
+	result := multiScalarMult([]secp.JacobianPoint{points[0], points[0]}, []secp.ModNScalar{n, *new(secp.ModNScalar).SetInt(1)})
+	if !isInfinity(&result) {
+		t.Error("(n-1)·G + G should be the point at infinity")
+	}
+}
+
+func TestVerifyBatch(t *testing.T) {
+	var items []BatchItem
+	for i := 0; i < 20; i++ {
+		// Ed25519 signatures are mixed in and checked one by one
+		scheme := SchemeSchnorr
+		if i%5 == 4 {
+			scheme = SchemeEd25519
+		}
+		signer, _ := GenerateKey(scheme)
+		digest := sha256.Sum256([]byte{byte(i)})
+		signature, _ := signer.Sign(digest[:])
+		items = append(items, BatchItem{Verifier: signer.Public(), Digest: digest[:], Signature: signature})
+	}
+	if !VerifyBatch(items) {
+		t.Fatal("Batch of valid signatures should verify")
+	}
+	if !VerifyBatch(nil) {
+		t.Error("Empty batch should verify")
+	}
+
+	for _, i := range []int{0, 7, 14} {
+		bad := slices.Clone(items)
+		bad[i].Digest = items[i+1].Digest
+		if VerifyBatch(bad) {
+			t.Errorf("Batch with an invalid signature at %d should not verify", i)
+		}
+	}
+
+	// Two invalid signatures that cancel out in an unweighted sum are still caught
+	bad := slices.Clone(items)
+	var s0, s1 secp.ModNScalar
+	s0.SetByteSlice(items[0].Signature[32:])
+	s1.SetByteSlice(items[1].Signature[32:])
+	one := new(secp.ModNScalar).SetInt(1)
+	s0.Add(one)
+	s1.Add(new(secp.ModNScalar).NegateVal(one))
+	s0Bytes, s1Bytes := s0.Bytes(), s1.Bytes()
+	bad[0].Signature = append(bytes.Clone(items[0].Signature[:32]), s0Bytes[:]...)
+	bad[1].Signature = append(bytes.Clone(items[1].Signature[:32]), s1Bytes[:]...)
+	if VerifyBatch(bad) {
+		t.Error("Batch with compensating invalid signatures should not verify")
+	}
+}
+
+// BenchmarkVerifyBatch compares a batch of Schnorr signatures with their
+// verification one by one and with P-256 ECDSA signatures verified one by one
+func BenchmarkVerifyBatch(b *testing.B) {
+	batch := func(scheme Scheme, n int) []BatchItem {
+		var items []BatchItem
+		for i := 0; i < n; i++ {
+			signer, err := GenerateKey(scheme)
+			if err != nil {
+				b.Fatal(err)
+			}
+			digest := sha256.Sum256([]byte{byte(i), byte(i >> 8)})
+			signature, err := signer.Sign(digest[:])
+			if err != nil {
+				b.Fatal(err)
+			}
+			items = append(items, BatchItem{Verifier: signer.Public(), Digest: digest[:], Signature: signature})
+		}
+		return items
+	}
+	oneByOne := func(b *testing.B, items []BatchItem) {
+		for i := 0; i < b.N; i++ {
+			for _, item := range items {
+				if !item.Verifier.Verify(item.Digest, item.Signature) {
+					b.Fatal("Valid signature rejected")
+				}
+			}
+		}
+	}
+
+	for _, n := range []int{10, 100, 500} {
+		schnorr, p256 := batch(SchemeSchnorr, n), batch(SchemeP256, n)
+		b.Run(fmt.Sprintf("schnorr-batch/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !VerifyBatch(schnorr) {
+					b.Fatal("Valid batch rejected")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("schnorr/%d", n), func(b *testing.B) { oneByOne(b, schnorr) })
+		b.Run(fmt.Sprintf("p256/%d", n), func(b *testing.B) { oneByOne(b, p256) })
+	}
+}
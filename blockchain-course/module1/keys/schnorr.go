@@ -0,0 +1,209 @@
+package keys
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	secp "github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Schnorr signatures follow BIP340 on secp256k1. Public keys are the x
+// coordinate of a point with an even y, and signatures are R.x ‖ s with
+// s·G = R + e·P, where R has an even y and e hashes R, P and the digest.
+// Signing and single verification use the BIP340 package of btcd. Unlike
+// ECDSA the equation is linear, so that many signatures can be checked at once
+// by VerifyBatch.
+
+// schnorrSigner signs with BIP340 Schnorr signatures
+type schnorrSigner struct {
+	key *secp.PrivateKey
+}
+
+func generateSchnorr() (Signer, error) {
+	key, err := secp.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &schnorrSigner{key}, nil
+}
+
+func newSchnorrSigner(key []byte) (Signer, error) {
+	scalar, err := parsePrivateScalar(key)
+	if err != nil {
+		return nil, err
+	}
+	return &schnorrSigner{secp.NewPrivateKey(scalar)}, nil
+}
+
+func (s *schnorrSigner) Scheme() Scheme { return SchemeSchnorr }
+
+func (s *schnorrSigner) Public() Verifier {
+	// The public key is the point of the key with an even y
+	public, _ := schnorr.ParsePubKey(schnorr.SerializePubKey(s.key.PubKey()))
+	return &schnorrVerifier{public}
+}
+
+func (s *schnorrSigner) Sign(digest []byte) ([]byte, error) {
+	var aux [32]byte
+	if _, err := rand.Read(aux[:]); err != nil {
+		return nil, err
+	}
+	return s.sign(digest, aux)
+}
+
+// sign signs digest with the auxiliary randomness aux, as specified by BIP340
+func (s *schnorrSigner) sign(digest []byte, aux [32]byte) ([]byte, error) {
+	sig, err := schnorr.Sign(s.key, digest, schnorr.CustomNonce(aux))
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+func (s *schnorrSigner) Bytes() []byte {
+	return tagged(SchemeSchnorr, s.key.Serialize())
+}
+
+// schnorrVerifier checks BIP340 Schnorr signatures
+type schnorrVerifier struct {
+	key *secp.PublicKey
+}
+
+func newSchnorrVerifier(pubKey []byte) (Verifier, error) {
+	key, err := schnorr.ParsePubKey(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %x", ErrInvalidPublicKey, pubKey)
+	}
+	return &schnorrVerifier{key}, nil
+}
+
+func (v *schnorrVerifier) Scheme() Scheme { return SchemeSchnorr }
+
+func (v *schnorrVerifier) Verify(digest, signature []byte) bool {
+	sig, err := schnorr.ParseSignature(signature)
+	return err == nil && sig.Verify(digest, v.key)
+}
+
+func (v *schnorrVerifier) Bytes() []byte {
+	return tagged(SchemeSchnorr, schnorr.SerializePubKey(v.key))
+}
+
+// verifySchnorrBatch checks Schnorr signatures together: with random a_i and
+// a_0 = 1, every signature is valid, except with negligible probability, if
+// (Σ a_i·s_i)·G = Σ a_i·R_i + Σ (a_i·e_i)·P_i. A single multi-scalar
+// multiplication replaces two scalar multiplications per signature.
+func verifySchnorrBatch(items []BatchItem) bool {
+	var g secp.JacobianPoint
+	secp.ScalarBaseMultNonConst(new(secp.ModNScalar).SetInt(1), &g)
+	points := []secp.JacobianPoint{g}
+	sum := new(secp.ModNScalar)
+	scalars := []secp.ModNScalar{{}}
+
+	for i, item := range items {
+		if len(item.Digest) != 32 || len(item.Signature) != SignatureSize {
+			return false
+		}
+
+		// R is the point of r with an even y, s must be below n
+		var r secp.FieldVal
+		if overflow := r.SetByteSlice(item.Signature[:32]); overflow {
+			return false
+		}
+		var ry secp.FieldVal
+		if !secp.DecompressY(&r, false, &ry) {
+			return false
+		}
+		var s secp.ModNScalar
+		if overflow := s.SetByteSlice(item.Signature[32:]); overflow {
+			return false
+		}
+
+		pubKey := item.Verifier.(*schnorrVerifier).key
+		var e secp.ModNScalar
+		e.SetByteSlice(chainhash.TaggedHash(chainhash.TagBIP0340Challenge,
+			item.Signature[:32], schnorr.SerializePubKey(pubKey), item.Digest)[:])
+
+		a := new(secp.ModNScalar).SetInt(1)
+		if i > 0 {
+			// 128 random bits make a forged batch pass with probability 2⁻¹²⁸
+			var random [16]byte
+			if _, err := rand.Read(random[:]); err != nil {
+				return false
+			}
+			a.SetByteSlice(random[:])
+		}
+
+		sum.Add(new(secp.ModNScalar).Mul2(a, &s))
+		var rPoint, pPoint secp.JacobianPoint
+		rPoint.X.Set(&r)
+		rPoint.Y.Set(&ry)
+		rPoint.Z.SetInt(1)
+		pubKey.AsJacobian(&pPoint)
+		points = append(points, rPoint, pPoint)
+		scalars = append(scalars, *a, *new(secp.ModNScalar).Mul2(a, &e))
+	}
+
+	// The sum is moved to the right side to compare with the point at infinity
+	scalars[0].NegateVal(sum)
+	result := multiScalarMult(points, scalars)
+	return isInfinity(&result)
+}
+
+// isInfinity reports whether pt is the point at infinity
+func isInfinity(pt *secp.JacobianPoint) bool {
+	return pt.Z.IsZero() || (pt.X.IsZero() && pt.Y.IsZero())
+}
+
+// multiScalarMult returns Σ scalars[i]·points[i] with the bucket method of
+// Pippenger. Each window of w bits of the scalars costs one addition per
+// point and 2^(w+1) additions to sum the buckets, instead of one scalar
+// multiplication per point.
+func multiScalarMult(points []secp.JacobianPoint, scalars []secp.ModNScalar) secp.JacobianPoint {
+	w := 2
+	for n := len(points); w < 16 && 1<<(w+2) < n; w++ {
+	}
+	digits := make([][32]byte, len(scalars))
+	for i := range scalars {
+		digits[i] = scalars[i].Bytes()
+	}
+	// digit returns bits [start, start+w) of the big-endian scalar b
+	digit := func(b *[32]byte, start int) int {
+		d := 0
+		for bit := start + w - 1; bit >= start; bit-- {
+			if bit < 256 {
+				d = d<<1 | int(b[31-bit/8]>>(bit%8)&1)
+			} else {
+				d <<= 1
+			}
+		}
+		return d
+	}
+
+	var result secp.JacobianPoint
+	buckets := make([]secp.JacobianPoint, 1<<w-1)
+	for start := 255 / w * w; start >= 0; start -= w {
+		for i := 0; i < w; i++ {
+			secp.DoubleNonConst(&result, &result)
+		}
+
+		for i := range buckets {
+			buckets[i] = secp.JacobianPoint{}
+		}
+		for i := range points {
+			if d := digit(&digits[i], start); d != 0 {
+				secp.AddNonConst(&buckets[d-1], &points[i], &buckets[d-1])
+			}
+		}
+
+		// Σ j·bucket[j] as a sum of running sums from the top bucket down
+		var running, window secp.JacobianPoint
+		for j := len(buckets) - 1; j >= 0; j-- {
+			secp.AddNonConst(&running, &buckets[j], &running)
+			secp.AddNonConst(&window, &running, &window)
+		}
+		secp.AddNonConst(&result, &window, &result)
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package keys
+
+import (
+	"fmt"
+
+	secp "github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secpecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// secp256k1Signer signs with ECDSA on secp256k1. Signing is done by the
+// secp256k1 package of dcrd, whose arithmetic on the private key and the nonce
+// runs in constant time, with deterministic RFC 6979 nonces and low s.
+type secp256k1Signer struct {
+	key *secp.PrivateKey
+}
+
+func generateSecp256k1() (Signer, error) {
+	key, err := secp.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &secp256k1Signer{key}, nil
+}
+
+func newSecp256k1Signer(key []byte) (Signer, error) {
+	scalar, err := parsePrivateScalar(key)
+	if err != nil {
+		return nil, err
+	}
+	return &secp256k1Signer{secp.NewPrivateKey(scalar)}, nil
+}
+
+// parsePrivateScalar decodes a 32-byte private key in [1, n-1]
+func parsePrivateScalar(key []byte) (*secp.ModNScalar, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w: %d bytes, expected 32", ErrInvalidPrivateKey, len(key))
+	}
+	var scalar secp.ModNScalar
+	if overflow := scalar.SetByteSlice(key); overflow || scalar.IsZero() {
+		return nil, fmt.Errorf("%w: out of range", ErrInvalidPrivateKey)
+	}
+	return &scalar, nil
+}
+
+func (s *secp256k1Signer) Scheme() Scheme { return SchemeSecp256k1 }
+
+func (s *secp256k1Signer) Public() Verifier {
+	return &secp256k1Verifier{s.key.PubKey()}
+}
+
+func (s *secp256k1Signer) Sign(digest []byte) ([]byte, error) {
+	sig := secpecdsa.Sign(s.key, digest)
+	r, sigS := sig.R(), sig.S()
+	signature := make([]byte, SignatureSize)
+	r.PutBytesUnchecked(signature[:32])
+	sigS.PutBytesUnchecked(signature[32:])
+	return signature, nil
+}
+
+func (s *secp256k1Signer) Bytes() []byte {
+	return tagged(SchemeSecp256k1, s.key.Serialize())
+}
+
+// secp256k1Verifier checks ECDSA signatures on secp256k1
+type secp256k1Verifier struct {
+	key *secp.PublicKey
+}
+
+func newSecp256k1Verifier(pubKey []byte) (Verifier, error) {
+	if len(pubKey) != secp.PubKeyBytesLenCompressed {
+		return nil, fmt.Errorf("%w: %x", ErrInvalidPublicKey, pubKey)
+	}
+	key, err := secp.ParsePubKey(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %x", ErrInvalidPublicKey, pubKey)
+	}
+	return &secp256k1Verifier{key}, nil
+}
+
+func (v *secp256k1Verifier) Scheme() Scheme { return SchemeSecp256k1 }
+
+// Verify rejects r or s out of range and s in the upper half of the order, as
+// parseSignature does for P-256
+func (v *secp256k1Verifier) Verify(digest, signature []byte) bool {
+	if len(signature) != SignatureSize {
+		return false
+	}
+	var r, s secp.ModNScalar
+	if r.SetByteSlice(signature[:32]) || r.IsZero() || s.SetByteSlice(signature[32:]) || s.IsZero() || s.IsOverHalfOrder() {
+		return false
+	}
+	return secpecdsa.NewSignature(&r, &s).Verify(digest, v.key)
+}
+
+func (v *secp256k1Verifier) Bytes() []byte {
+	return tagged(SchemeSecp256k1, v.key.SerializeCompressed())
+}
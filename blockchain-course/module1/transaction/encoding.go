@@ -0,0 +1,357 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// encodingVersion is the version of the canonical transaction encoding
+	encodingVersion = 1
+	// lockTimeEncodingVersion extends encodingVersion with input sequences and
+	// the lock time. It is only used when one of them is set, so transactions
+	// without them keep their encoding and ID.
+	lockTimeEncodingVersion = 2
+	// scriptEncodingVersion extends lockTimeEncodingVersion with the scripts
+	// of inputs and outputs, and is only used when one of them is set
+	scriptEncodingVersion = 3
+)
+
+var (
+	// ErrUnsupportedVersion is returned when decoding data written with an unknown encoding version
+	ErrUnsupportedVersion = errors.New("unsupported encoding version")
+	// ErrTrailingData is returned when decoded data has bytes left after the last field
+	ErrTrailingData = errors.New("trailing data after decoded value")
+	// ErrNonCanonical is returned when decoded data is not the canonical encoding of its value
+	ErrNonCanonical = errors.New("non-canonical encoding")
+)
+
+// Encoder writes the canonical binary encoding: integers are varints and
+// byte strings are prefixed with their length
+type Encoder struct {
+	buf bytes.Buffer
+}
+
+// WriteUvarint writes an unsigned varint
+func (e *Encoder) WriteUvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf.Write(tmp[:n])
+}
+
+// WriteVarint writes a zig-zag encoded signed varint
+func (e *Encoder) WriteVarint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	e.buf.Write(tmp[:n])
+}
+
+// WriteBytes writes a length-prefixed byte string
+func (e *Encoder) WriteBytes(b []byte) {
+	e.WriteUvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+// Bytes returns the encoded data
+func (e *Encoder) Bytes() []byte {
+	return e.buf.Bytes()
+}
+
+// Decoder reads data written by an Encoder
+type Decoder struct {
+	r *bytes.Reader
+}
+
+// NewDecoder creates a Decoder over data
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{r: bytes.NewReader(data)}
+}
+
+// ReadUvarint reads an unsigned varint
+func (d *Decoder) ReadUvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(d.r)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// ReadVarint reads a zig-zag encoded signed varint
+func (d *Decoder) ReadVarint() (int64, error) {
+	v, err := binary.ReadVarint(d.r)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// ReadBytes reads a length-prefixed byte string
+func (d *Decoder) ReadBytes() ([]byte, error) {
+	n, err := d.ReadUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(d.r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readUint32 reads an unsigned varint that must fit in 32 bits
+func (d *Decoder) readUint32() (uint32, error) {
+	v, err := d.ReadUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: %d overflows 32 bits", ErrNonCanonical, v)
+	}
+	return uint32(v), nil
+}
+
+// ReadCount reads a collection length, rejecting counts that cannot fit in the
+// remaining data given the minimum encoded size of one element
+func (d *Decoder) ReadCount(minElemSize int) (int, error) {
+	n, err := d.ReadUvarint()
+	if err != nil {
+		return 0, err
+	}
+	// Dividing the remaining length cannot overflow like multiplying the count
+	if n > math.MaxInt || n > uint64(d.r.Len())/uint64(max(minElemSize, 1)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+// ReadVersion reads a format version and checks it is supported
+func (d *Decoder) ReadVersion(supported uint64) error {
+	v, err := d.ReadUvarint()
+	if err != nil {
+		return err
+	}
+	if v != supported {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	return nil
+}
+
+// Finish checks that all data has been consumed
+func (d *Decoder) Finish() error {
+	if d.r.Len() != 0 {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// Serialize returns the canonical encoding of the Transaction. The ID is not
+// part of the encoding since it is derived from it.
+func (tx Transaction) Serialize() []byte {
+	var e Encoder
+
+	version := tx.encodingVersion()
+	e.WriteUvarint(version)
+
+	e.WriteUvarint(uint64(len(tx.Vin)))
+	for _, in := range tx.Vin {
+		in.encode(&e, version)
+	}
+
+	e.WriteUvarint(uint64(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		out.encode(&e, version)
+	}
+
+	if version >= lockTimeEncodingVersion {
+		e.WriteUvarint(uint64(tx.LockTime))
+	}
+
+	return e.Bytes()
+}
+
+// encodingVersion returns the oldest encoding version able to represent tx
+func (tx Transaction) encodingVersion() uint64 {
+	for _, in := range tx.Vin {
+		if len(in.Script) != 0 {
+			return scriptEncodingVersion
+		}
+	}
+	for _, out := range tx.Vout {
+		if len(out.Script) != 0 {
+			return scriptEncodingVersion
+		}
+	}
+
+	if tx.LockTime != 0 {
+		return lockTimeEncodingVersion
+	}
+	for _, in := range tx.Vin {
+		if in.Sequence != 0 {
+			return lockTimeEncodingVersion
+		}
+	}
+	return encodingVersion
+}
+
+// DeserializeTransaction decodes a Transaction and restores its ID
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	d := NewDecoder(data)
+	version, err := d.ReadUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if version < encodingVersion || version > scriptEncodingVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	tx := &Transaction{}
+
+	// An input takes at least four bytes, an output at least two
+	inCount, err := d.ReadCount(4)
+	if err != nil {
+		return nil, err
+	}
+	tx.Vin = make([]TXInput, inCount)
+	for i := range tx.Vin {
+		if err := tx.Vin[i].decode(d, version); err != nil {
+			return nil, err
+		}
+	}
+
+	outCount, err := d.ReadCount(2)
+	if err != nil {
+		return nil, err
+	}
+	tx.Vout = make([]TXOutput, outCount)
+	for i := range tx.Vout {
+		if err := tx.Vout[i].decode(d, version); err != nil {
+			return nil, err
+		}
+	}
+
+	if version >= lockTimeEncodingVersion {
+		if tx.LockTime, err = d.readUint32(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	if tx.encodingVersion() != version {
+		return nil, fmt.Errorf("%w: version %d", ErrNonCanonical, version)
+	}
+
+	tx.ID = tx.Hash()
+	return tx, nil
+}
+
+// Serialize returns the canonical encoding of the input, sequence and script included
+func (in TXInput) Serialize() []byte {
+	var e Encoder
+	in.encode(&e, scriptEncodingVersion)
+	return e.Bytes()
+}
+
+// DeserializeTXInput decodes an input
+func DeserializeTXInput(data []byte) (*TXInput, error) {
+	var in TXInput
+	d := NewDecoder(data)
+	if err := in.decode(d, scriptEncodingVersion); err != nil {
+		return nil, err
+	}
+	return &in, d.Finish()
+}
+
+// encode writes the fields of the input that exist in the encoding version
+func (in TXInput) encode(e *Encoder, version uint64) {
+	e.WriteBytes(in.Txid)
+	e.WriteVarint(int64(in.Vout))
+	e.WriteBytes(in.Signature)
+	e.WriteBytes(in.PubKey)
+	if version >= lockTimeEncodingVersion {
+		e.WriteUvarint(uint64(in.Sequence))
+	}
+	if version >= scriptEncodingVersion {
+		e.WriteBytes(in.Script)
+	}
+}
+
+func (in *TXInput) decode(d *Decoder, version uint64) error {
+	var err error
+	if in.Txid, err = d.ReadBytes(); err != nil {
+		return err
+	}
+	vout, err := d.ReadVarint()
+	if err != nil {
+		return err
+	}
+	in.Vout = int(vout)
+	if in.Signature, err = d.ReadBytes(); err != nil {
+		return err
+	}
+	if in.PubKey, err = d.ReadBytes(); err != nil {
+		return err
+	}
+	if version >= lockTimeEncodingVersion {
+		if in.Sequence, err = d.readUint32(); err != nil {
+			return err
+		}
+	}
+	if version >= scriptEncodingVersion {
+		if in.Script, err = d.ReadBytes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serialize returns the canonical encoding of the output, script included
+func (out TXOutput) Serialize() []byte {
+	var e Encoder
+	out.encode(&e, scriptEncodingVersion)
+	return e.Bytes()
+}
+
+// DeserializeTXOutput decodes an output
+func DeserializeTXOutput(data []byte) (*TXOutput, error) {
+	var out TXOutput
+	d := NewDecoder(data)
+	if err := out.decode(d, scriptEncodingVersion); err != nil {
+		return nil, err
+	}
+	return &out, d.Finish()
+}
+
+func (out TXOutput) encode(e *Encoder, version uint64) {
+	e.WriteVarint(int64(out.Value))
+	e.WriteBytes(out.PubKeyHash)
+	if version >= scriptEncodingVersion {
+		e.WriteBytes(out.Script)
+	}
+}
+
+func (out *TXOutput) decode(d *Decoder, version uint64) error {
+	value, err := d.ReadVarint()
+	if err != nil {
+		return err
+	}
+	out.Value = int(value)
+	if out.PubKeyHash, err = d.ReadBytes(); err != nil {
+		return err
+	}
+	if version >= scriptEncodingVersion {
+		if out.Script, err = d.ReadBytes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
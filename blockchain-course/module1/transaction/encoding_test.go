@@ -0,0 +1,156 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+func sampleTransaction() Transaction {
+	return Transaction{
+		Vin: []TXInput{
+			{Txid: []byte{0xaa, 0xbb}, Vout: 1, Signature: []byte{0x01}, PubKey: []byte{0x02, 0x03}},
+		},
+		Vout: []TXOutput{
+			{Value: 10, PubKeyHash: []byte{0xcc}},
+		},
+	}
+}
+
+func TestSerializeIsCanonical(t *testing.T) {
+	tx := sampleTransaction()
+
+	// The encoding is part of the consensus rules and must never change silently
+	expected := "010102aabb020101020203011401cc"
+	if encoded := hex.EncodeToString(tx.Serialize()); encoded != expected {
+		t.Errorf("Unexpected encoding %s, expected %s", encoded, expected)
+	}
+
+	// The ID is derived data and does not affect the encoding
+	tx.ID = []byte("ignored")
+	if encoded := hex.EncodeToString(tx.Serialize()); encoded != expected {
+		t.Error("ID should not be part of the encoding")
+	}
+}
+
+func TestDeserializeTransaction(t *testing.T) {
+	tx := NewCoinbaseTX("address", "")
+
+	decoded, err := DeserializeTransaction(tx.Serialize())
+	if err != nil {
+		t.Fatalf("Failed to deserialize transaction: %v", err)
+	}
+
+	if !bytes.Equal(decoded.ID, tx.ID) {
+		t.Error("Decoded transaction ID does not match")
+	}
+
+	if !bytes.Equal(decoded.Serialize(), tx.Serialize()) {
+		t.Error("Round trip should preserve the encoding")
+	}
+
+	if !decoded.IsCoinbase() {
+		t.Error("Decoded transaction should be coinbase")
+	}
+}
+
+func TestDeserializeTransactionErrors(t *testing.T) {
+	tx := sampleTransaction()
+	data := tx.Serialize()
+
+	if _, err := DeserializeTransaction(data[:len(data)-1]); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF for truncated data, got %v", err)
+	}
+
+	if _, err := DeserializeTransaction(append(data, 0x00)); err != ErrTrailingData {
+		t.Errorf("Expected ErrTrailingData, got %v", err)
+	}
+
+	// A huge count must not overflow the length check and reach make
+	for _, count := range []uint64{1 << 62, math.MaxUint64} {
+		huge := binary.AppendUvarint([]byte{encodingVersion}, count)
+		if _, err := DeserializeTransaction(append(huge, 0, 0, 0, 0)); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF for an input count of %d, got %v", count, err)
+		}
+	}
+
+	badVersion := append([]byte{0x7f}, data[1:]...)
+	if _, err := DeserializeTransaction(badVersion); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestInputOutputRoundTrip(t *testing.T) {
+	tx := sampleTransaction()
+
+	in, err := DeserializeTXInput(tx.Vin[0].Serialize())
+	if err != nil {
+		t.Fatalf("Failed to deserialize input: %v", err)
+	}
+	if in.Vout != 1 || !bytes.Equal(in.PubKey, tx.Vin[0].PubKey) {
+		t.Error("Decoded input is incorrect")
+	}
+
+	out, err := DeserializeTXOutput(tx.Vout[0].Serialize())
+	if err != nil {
+		t.Fatalf("Failed to deserialize output: %v", err)
+	}
+	if out.Value != 10 || !bytes.Equal(out.PubKeyHash, tx.Vout[0].PubKeyHash) {
+		t.Error("Decoded output is incorrect")
+	}
+}
+
+func TestLockTimeEncoding(t *testing.T) {
+	tx := sampleTransaction()
+	tx.LockTime = 500
+	tx.Vin[0].Sequence = MaxSequence - 1
+
+	// Lock times switch to version 2, which appends the sequences and lock time
+	expected := "020102aabb020101020203feffffff0f011401cc" + "f403"
+	if encoded := hex.EncodeToString(tx.Serialize()); encoded != expected {
+		t.Errorf("Unexpected encoding %s, expected %s", encoded, expected)
+	}
+
+	decoded, err := DeserializeTransaction(tx.Serialize())
+	if err != nil {
+		t.Fatalf("Failed to deserialize transaction: %v", err)
+	}
+	if decoded.LockTime != tx.LockTime || decoded.Vin[0].Sequence != tx.Vin[0].Sequence {
+		t.Error("Round trip should preserve the lock time and sequences")
+	}
+
+	// Version 2 without lock time nor sequences has a shorter version 1 encoding
+	nonCanonical, _ := hex.DecodeString("020102aabb020101020203" + "00" + "011401cc" + "00")
+	if _, err := DeserializeTransaction(nonCanonical); !errors.Is(err, ErrNonCanonical) {
+		t.Errorf("Expected ErrNonCanonical, got %v", err)
+	}
+}
+
+func TestScriptEncoding(t *testing.T) {
+	tx := sampleTransaction()
+	tx.Vout[0].Script = []byte{0x51}
+
+	// Scripts switch to version 3, which appends them to inputs and outputs
+	expected := "030102aabb02010102020300" + "00" + "011401cc0151" + "00"
+	if encoded := hex.EncodeToString(tx.Serialize()); encoded != expected {
+		t.Errorf("Unexpected encoding %s, expected %s", encoded, expected)
+	}
+
+	decoded, err := DeserializeTransaction(tx.Serialize())
+	if err != nil {
+		t.Fatalf("Failed to deserialize transaction: %v", err)
+	}
+	if !bytes.Equal(decoded.Vout[0].Script, tx.Vout[0].Script) {
+		t.Error("Round trip should preserve the output script")
+	}
+
+	// Version 3 without scripts has a shorter encoding
+	nonCanonical, _ := hex.DecodeString("030102aabb0201010202030000011401cc0000")
+	if _, err := DeserializeTransaction(nonCanonical); !errors.Is(err, ErrNonCanonical) {
+		t.Errorf("Expected ErrNonCanonical, got %v", err)
+	}
+}
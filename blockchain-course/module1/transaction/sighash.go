@@ -0,0 +1,140 @@
+package transaction
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// SigHashType selects the parts of a transaction committed to by a signature.
+// It is appended to every signature so that verification hashes the same parts.
+type SigHashType byte
+
+const (
+	// SigHashAll commits to every input and output
+	SigHashAll SigHashType = 0x01
+	// SigHashNone commits to the inputs but no output, anyone may redirect the funds
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle commits to the inputs and to the output at the index of the signed input
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay is combined with the other types to commit to the signed
+	// input only, so that others can add inputs
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashBaseMask = 0x1f
+)
+
+// sigHashVersion is the version of the signature hash preimage
+const sigHashVersion = 1
+
+var (
+	// ErrInvalidSigHashType is returned for unknown signature hash types
+	ErrInvalidSigHashType = errors.New("invalid signature hash type")
+	// ErrSigHashSingle is returned when signing with SigHashSingle an input
+	// without the output of the same index
+	ErrSigHashSingle = errors.New("no output for SIGHASH_SINGLE input")
+)
+
+// base returns the type without the SigHashAnyoneCanPay flag
+func (t SigHashType) base() SigHashType {
+	return t & sigHashBaseMask
+}
+
+// Valid reports whether t is one of the three types, optionally with SigHashAnyoneCanPay
+func (t SigHashType) Valid() bool {
+	if t&^(SigHashAnyoneCanPay|sigHashBaseMask) != 0 {
+		return false
+	}
+	base := t.base()
+	return base == SigHashAll || base == SigHashNone || base == SigHashSingle
+}
+
+func (t SigHashType) String() string {
+	var name string
+	switch t.base() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("SigHashType(%#x)", byte(t))
+	}
+	if t&SigHashAnyoneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+// SignatureHash returns the hash signed by input inID, which spends an output
+// locked by subscript. The preimage uses the canonical encoding:
+//
+//	version, hash type
+//	inputs: every input, or only inID with SigHashAnyoneCanPay. Each is its
+//	  Txid, Vout, Sequence and the subscript for inID or an empty string for
+//	  the others. With SigHashNone and SigHashSingle the sequences of the
+//	  other inputs are 0 so that they can be updated.
+//	outputs: every output with SigHashAll, none with SigHashNone, and with
+//	  SigHashSingle the output of index inID preceded by the empty outputs
+//	  before it
+//	lock time
+//
+// Signatures, public keys and unlocking scripts are left out since they
+// cannot commit to themselves. The preimage is hashed with SHA-256.
+func (tx Transaction) SignatureHash(inID int, subscript []byte, hashType SigHashType) ([]byte, error) {
+	if !hashType.Valid() {
+		return nil, fmt.Errorf("%w: %#x", ErrInvalidSigHashType, byte(hashType))
+	}
+	if inID < 0 || inID >= len(tx.Vin) {
+		return nil, fmt.Errorf("input %d out of range", inID)
+	}
+	base := hashType.base()
+	if base == SigHashSingle && inID >= len(tx.Vout) {
+		return nil, fmt.Errorf("%w: input %d of %d outputs", ErrSigHashSingle, inID, len(tx.Vout))
+	}
+
+	var e Encoder
+	e.WriteUvarint(sigHashVersion)
+	e.WriteUvarint(uint64(hashType))
+
+	inputs := tx.Vin
+	signed := inID
+	if hashType&SigHashAnyoneCanPay != 0 {
+		inputs = tx.Vin[inID : inID+1]
+		signed = 0
+	}
+	e.WriteUvarint(uint64(len(inputs)))
+	for i, in := range inputs {
+		e.WriteBytes(in.Txid)
+		e.WriteVarint(int64(in.Vout))
+		sequence := in.Sequence
+		if i != signed && base != SigHashAll {
+			sequence = 0
+		}
+		e.WriteUvarint(uint64(sequence))
+		if i == signed {
+			e.WriteBytes(subscript)
+		} else {
+			e.WriteBytes(nil)
+		}
+	}
+
+	var outputs []TXOutput
+	switch base {
+	case SigHashAll:
+		outputs = tx.Vout
+	case SigHashSingle:
+		outputs = make([]TXOutput, inID+1)
+		outputs[inID] = tx.Vout[inID]
+	}
+	e.WriteUvarint(uint64(len(outputs)))
+	for _, out := range outputs {
+		out.encode(&e, scriptEncodingVersion)
+	}
+
+	e.WriteUvarint(uint64(tx.LockTime))
+
+	hash := sha256.Sum256(e.Bytes())
+	return hash[:], nil
+}
@@ -0,0 +1,131 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// sigHashTransaction has two inputs and two outputs, one of them locked by a script
+func sigHashTransaction() Transaction {
+	return Transaction{
+		Vin: []TXInput{
+			{Txid: []byte{0x01, 0x02}, Vout: 0, Signature: []byte{0xaa}, PubKey: []byte{0xbb}, Sequence: 7},
+			{Txid: []byte{0x03, 0x04}, Vout: 1, Script: []byte{0x51}, Sequence: MaxSequence - 1},
+		},
+		Vout: []TXOutput{
+			{Value: 5, PubKeyHash: []byte{0xcc}},
+			{Value: 3, Script: []byte{0x51}},
+		},
+		LockTime: 100,
+	}
+}
+
+func TestSignatureHashVectors(t *testing.T) {
+	tx := sigHashTransaction()
+	subscript := []byte{0x76, 0xa9}
+
+	// The signature hash is part of the consensus rules and must never change silently
+	vectors := []struct {
+		hashType SigHashType
+		expected string
+	}{
+		{SigHashAll, "02b9db7ff6b34e5ac2692fd8f84fda00fea056bcca2a49337e376e8319516e02"},
+		{SigHashNone, "6ec5906f1a69431b4e1fac2317d447e6aa5ac556cdb9f08bccb82f77e8e6dc29"},
+		{SigHashSingle, "cb5bc459304d0c246258e6ce3ac2e81254634911bf66b35aee3c4a1861067a32"},
+		{SigHashAll | SigHashAnyoneCanPay, "1dbeddf278b69f6ff4916f0e6eff2e69d1565611f73770c515cd993e7f4af591"},
+		{SigHashNone | SigHashAnyoneCanPay, "f637b1a295aca4df32f6382d54b10601a9f28626c76f2fc4c17734285e7e9c49"},
+		{SigHashSingle | SigHashAnyoneCanPay, "4f632acc6ab3846f61d442260bd4bfb47e0f9f00774ee7a6898032d60d18d3d0"},
+	}
+	for _, v := range vectors {
+		hash, err := tx.SignatureHash(1, subscript, v.hashType)
+		if err != nil {
+			t.Errorf("%s: %v", v.hashType, err)
+			continue
+		}
+		if encoded := hex.EncodeToString(hash); encoded != v.expected {
+			t.Errorf("%s: unexpected hash %s, expected %s", v.hashType, encoded, v.expected)
+		}
+	}
+}
+
+func TestSignatureHashCommitments(t *testing.T) {
+	subscript := []byte{0x76, 0xa9}
+	hash := func(tx Transaction, inID int, hashType SigHashType) []byte {
+		t.Helper()
+		h, err := tx.SignatureHash(inID, subscript, hashType)
+		if err != nil {
+			t.Fatalf("%s: %v", hashType, err)
+		}
+		return h
+	}
+
+	tx := sigHashTransaction()
+	all := hash(tx, 0, SigHashAll)
+	none := hash(tx, 0, SigHashNone)
+	single := hash(tx, 0, SigHashSingle)
+	anyone := hash(tx, 0, SigHashAll|SigHashAnyoneCanPay)
+
+	// The signature and public key are not signed
+	tx.Vin[0].Signature = []byte{0x01}
+	tx.Vin[0].PubKey = []byte{0x02}
+	tx.Vin[1].Script = []byte{0x52}
+	if !bytes.Equal(hash(tx, 0, SigHashAll), all) {
+		t.Error("Unlocking data should not change the signature hash")
+	}
+
+	// Only SigHashNone and SigHashSingle let the other inputs update their sequence
+	tx.Vin[1].Sequence = 1
+	if bytes.Equal(hash(tx, 0, SigHashAll), all) {
+		t.Error("SigHashAll should commit to every sequence")
+	}
+	if !bytes.Equal(hash(tx, 0, SigHashNone), none) || !bytes.Equal(hash(tx, 0, SigHashSingle), single) {
+		t.Error("SigHashNone and SigHashSingle should not commit to other sequences")
+	}
+
+	// SigHashSingle commits only to the output of the signed input
+	tx.Vout[1].Value = 1
+	if !bytes.Equal(hash(tx, 0, SigHashSingle), single) {
+		t.Error("SigHashSingle should not commit to other outputs")
+	}
+	tx.Vout[0].Value = 1
+	if bytes.Equal(hash(tx, 0, SigHashSingle), single) {
+		t.Error("SigHashSingle should commit to its output")
+	}
+	if !bytes.Equal(hash(tx, 0, SigHashNone), none) {
+		t.Error("SigHashNone should not commit to outputs")
+	}
+
+	// SigHashAnyoneCanPay lets others add inputs
+	tx = sigHashTransaction()
+	tx.Vin = append(tx.Vin, TXInput{Txid: []byte{0x05}, Vout: 2})
+	if !bytes.Equal(hash(tx, 0, SigHashAll|SigHashAnyoneCanPay), anyone) {
+		t.Error("SigHashAnyoneCanPay should not commit to other inputs")
+	}
+	if bytes.Equal(hash(tx, 0, SigHashAll), all) {
+		t.Error("SigHashAll should commit to every input")
+	}
+
+	// The hash type is part of the preimage
+	if bytes.Equal(all, anyone) || bytes.Equal(none, single) {
+		t.Error("Hash types should give different hashes")
+	}
+}
+
+func TestSignatureHashErrors(t *testing.T) {
+	tx := sigHashTransaction()
+	for _, hashType := range []SigHashType{0x00, 0x04, 0x41, SigHashAnyoneCanPay} {
+		if _, err := tx.SignatureHash(0, nil, hashType); !errors.Is(err, ErrInvalidSigHashType) {
+			t.Errorf("Expected ErrInvalidSigHashType for %s, got %v", hashType, err)
+		}
+	}
+
+	tx.Vout = tx.Vout[:1]
+	if _, err := tx.SignatureHash(1, nil, SigHashSingle); !errors.Is(err, ErrSigHashSingle) {
+		t.Errorf("Expected ErrSigHashSingle, got %v", err)
+	}
+	if _, err := tx.SignatureHash(2, nil, SigHashAll); err == nil {
+		t.Error("Expected an error for an input out of range")
+	}
+}
@@ -2,33 +2,54 @@ package transaction
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
+
+	"blockchain-course/module1/keys"
+)
+
+const (
+	// LockTimeThreshold separates the two meanings of LockTime: below it is a
+	// block height, from it on a Unix timestamp
+	LockTimeThreshold = 500000000
+	// MaxSequence marks an input as final. A transaction whose inputs are all
+	// final ignores its LockTime.
+	MaxSequence = 0xffffffff
 )
 
+// ErrMissingPrevTx is returned when the output spent by an input is not provided
+var ErrMissingPrevTx = errors.New("missing previous transaction")
+
 // Transaction represents a transaction in the blockchain
 type Transaction struct {
 	ID   []byte
 	Vin  []TXInput
 	Vout []TXOutput
+	// LockTime is the block height or timestamp before which the transaction
+	// cannot be mined, 0 means no lock
+	LockTime uint32
 }
 
-// TXOutput represents a transaction output
+// TXOutput represents a transaction output. It is locked either to the
+// owner of PubKeyHash or, when Script is set, by a locking script.
 type TXOutput struct {
 	Value      int
 	PubKeyHash []byte
+	Script     []byte
 }
 
-// TXInput represents a transaction input
+// TXInput represents a transaction input. Outputs locked to a PubKeyHash are
+// unlocked by Signature and PubKey, outputs locked by a script by the
+// unlocking Script.
 type TXInput struct {
 	Txid      []byte
 	Vout      int
 	Signature []byte
 	PubKey    []byte
+	Sequence  uint32
+	Script    []byte
 }
 
 // NewTransaction creates a new transaction
@@ -40,15 +61,28 @@ func NewTransaction() *Transaction {
 	}
 }
 
-// NewCoinbaseTX creates a new coinbase transaction
+// Subsidy is the block reward before the first halving
+const Subsidy = 10
+
+// MaxMoney bounds the value of an output and the sums of values checked by
+// validation, so that no sum can overflow
+const MaxMoney = 21_000_000
+
+// NewCoinbaseTX creates a new coinbase transaction paying the initial subsidy
 func NewCoinbaseTX(to, data string) *Transaction {
+	return NewCoinbaseTXWithReward(to, data, Subsidy)
+}
+
+// NewCoinbaseTXWithReward creates a coinbase transaction claiming reward, the
+// block subsidy plus the fees of the block
+func NewCoinbaseTXWithReward(to, data string, reward int) *Transaction {
 	if data == "" {
 		data = fmt.Sprintf("Reward to \"%s\"", to)
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := TXOutput{10, []byte(to)}
-	tx := Transaction{[]byte{}, []TXInput{txin}, []TXOutput{txout}}
+	txin := TXInput{Txid: []byte{}, Vout: -1, PubKey: []byte(data)}
+	txout := TXOutput{Value: reward, PubKeyHash: []byte(to)}
+	tx := Transaction{ID: []byte{}, Vin: []TXInput{txin}, Vout: []TXOutput{txout}}
 	tx.ID = tx.Hash()
 
 	return &tx
@@ -65,72 +99,89 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
-// Serialize returns a serialized Transaction
-func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
-
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		fmt.Printf("Error: %s\n", err)
-	}
-
-	return encoded.Bytes()
-}
-
 // IsCoinbase checks whether the transaction is coinbase
 func (tx Transaction) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-// Sign signs each input of a Transaction
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
-	if tx.IsCoinbase() {
-		return
+// IsFinal reports whether the transaction can be mined in a block at the given
+// height and timestamp
+func (tx Transaction) IsFinal(height, timestamp int64) bool {
+	if tx.LockTime == 0 {
+		return true
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			fmt.Printf("Error: Previous transaction is not correct\n")
-			return
+	limit := height
+	if tx.LockTime >= LockTimeThreshold {
+		limit = timestamp
+	}
+	if int64(tx.LockTime) < limit {
+		return true
+	}
+
+	for _, in := range tx.Vin {
+		if in.Sequence != MaxSequence {
+			return false
 		}
 	}
+	return true
+}
 
-	txCopy := tx.TrimmedCopy()
+// Sign signs each input of a Transaction with SigHashAll. The hash type is
+// appended to each signature. On error no input is modified.
+func (tx *Transaction) Sign(signer keys.Signer, prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
 
-	for inID, vin := range txCopy.Vin {
+	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+		if prevTx.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return fmt.Errorf("%w: input %d spends %x:%d", ErrMissingPrevTx, inID, vin.Txid, vin.Vout)
+		}
+	}
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+	signatures := make([][]byte, len(tx.Vin))
+	for inID, vin := range tx.Vin {
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
+		subscript := prevOut.PubKeyHash
+		if len(prevOut.Script) != 0 {
+			subscript = prevOut.Script
+		}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+		hash, err := tx.SignatureHash(inID, subscript, SigHashAll)
 		if err != nil {
-			fmt.Printf("Error: %s\n", err)
-			return
+			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
 
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
+		signature, err := signer.Sign(hash)
+		if err != nil {
+			return err
+		}
+		signatures[inID] = append(signature, byte(SigHashAll))
+	}
+
+	for inID := range tx.Vin {
+		tx.Vin[inID].Signature = signatures[inID]
 	}
+	return nil
 }
 
-// TrimmedCopy creates a trimmed copy of Transaction to be used in signing
+// TrimmedCopy creates a trimmed copy of Transaction to be used in signing.
+// The ID is left out since it commits to the signatures.
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{Txid: vin.Txid, Vout: vin.Vout, Sequence: vin.Sequence})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{Value: vout.Value, PubKeyHash: vout.PubKeyHash, Script: vout.Script})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{Vin: inputs, Vout: outputs, LockTime: tx.LockTime}
 
 	return txCopy
 }
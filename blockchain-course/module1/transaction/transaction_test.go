@@ -0,0 +1,98 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"blockchain-course/module1/keys"
+)
+
+func TestIsFinal(t *testing.T) {
+	tx := sampleTransaction()
+	if !tx.IsFinal(0, 0) {
+		t.Error("Transaction without lock time should be final")
+	}
+
+	tx.LockTime = 100
+	if tx.IsFinal(100, 0) {
+		t.Error("Transaction should be locked up to its lock height")
+	}
+	if !tx.IsFinal(101, 0) {
+		t.Error("Transaction should be final after its lock height")
+	}
+
+	tx.LockTime = LockTimeThreshold + 1000
+	if tx.IsFinal(1000000, LockTimeThreshold+1000) {
+		t.Error("Timestamp lock time should ignore the height")
+	}
+	if !tx.IsFinal(0, LockTimeThreshold+1001) {
+		t.Error("Transaction should be final after its lock timestamp")
+	}
+
+	// Final sequences disable the lock time
+	tx.Vin[0].Sequence = MaxSequence
+	if !tx.IsFinal(0, 0) {
+		t.Error("Transaction with final inputs should ignore its lock time")
+	}
+}
+
+func TestTrimmedCopyKeepsLockTime(t *testing.T) {
+	tx := sampleTransaction()
+	tx.LockTime = 7
+	tx.Vin[0].Sequence = 3
+
+	trimmed := tx.TrimmedCopy()
+	if trimmed.LockTime != 7 || trimmed.Vin[0].Sequence != 3 {
+		t.Error("Signed data should commit to the lock time and sequences")
+	}
+}
+
+// failingSigner fails to sign after its first signature
+type failingSigner struct {
+	keys.Signer
+	signed int
+}
+
+func (s *failingSigner) Sign(digest []byte) ([]byte, error) {
+	if s.signed++; s.signed > 1 {
+		return nil, errors.New("signer failure")
+	}
+	return s.Signer.Sign(digest)
+}
+
+func TestSign(t *testing.T) {
+	signer, err := keys.GenerateKey(keys.SchemeP256)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	prev := Transaction{ID: []byte("prev"), Vout: []TXOutput{{Value: 1, PubKeyHash: []byte("a")}, {Value: 2, PubKeyHash: []byte("b")}}}
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): prev}
+	tx := Transaction{
+		Vin:  []TXInput{{Txid: prev.ID, Vout: 0}, {Txid: prev.ID, Vout: 2}},
+		Vout: []TXOutput{{Value: 3, PubKeyHash: []byte("c")}},
+	}
+
+	// No input is signed when one spends a missing output
+	if err := tx.Sign(signer, prevTXs); !errors.Is(err, ErrMissingPrevTx) {
+		t.Errorf("Expected ErrMissingPrevTx, got %v", err)
+	}
+	tx.Vin[1].Vout = 1
+	if err := tx.Sign(&failingSigner{Signer: signer}, prevTXs); err == nil {
+		t.Error("Signer failure should be returned")
+	}
+	for inID, in := range tx.Vin {
+		if in.Signature != nil {
+			t.Errorf("Input %d should be left unsigned after a failure", inID)
+		}
+	}
+
+	if err := tx.Sign(signer, prevTXs); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	for inID, in := range tx.Vin {
+		if len(in.Signature) != keys.SignatureSize+1 || in.Signature[keys.SignatureSize] != byte(SigHashAll) {
+			t.Errorf("Input %d should carry a signature with SigHashAll, got %x", inID, in.Signature)
+		}
+	}
+}
@@ -9,12 +9,21 @@ import (
 	"blockchain-course/module1/transaction"
 )
 
+// BlockVersion is the version written into new block headers
+const BlockVersion = 1
+
+// HeaderLen is the size in bytes of a serialized block header
+const HeaderLen = 4 + 8 + 32 + 32 + 32 + 8 + 4 + 8
+
 // Block represents a block in the blockchain
 type Block struct {
+	Version       int32
 	Index         int64
 	Timestamp     int64
 	Data          []byte
 	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Bits          uint32
 	Hash          []byte
 	Nonce         int
 	Transactions  []*transaction.Transaction
@@ -22,30 +31,182 @@ type Block struct {
 
 // NewBlock creates and returns a new Block
 func NewBlock(data string, prevBlockHash []byte) *Block {
+	return NewBlockWithTransactions(data, prevBlockHash, make([]*transaction.Transaction, 0))
+}
+
+// NewBlockWithTransactions creates and returns a new Block carrying transactions
+func NewBlockWithTransactions(data string, prevBlockHash []byte, txs []*transaction.Transaction) *Block {
 	block := &Block{
+		Version:       BlockVersion,
 		Index:         0,
 		Timestamp:     time.Now().Unix(),
 		Data:          []byte(data),
 		PrevBlockHash: prevBlockHash,
 		Hash:          []byte{},
 		Nonce:         0,
-		Transactions:  make([]*transaction.Transaction, 0),
+		Transactions:  txs,
 	}
+	block.MerkleRoot = block.HashTransactions()
 	block.SetHash()
 	return block
 }
 
+// HashTransactions returns the Merkle root of the block transactions,
+// or an all-zero hash for a block without transactions
+func (b *Block) HashTransactions() []byte {
+	if len(b.Transactions) == 0 {
+		return make([]byte, sha256.Size)
+	}
+
+	var txs [][]byte
+	for _, tx := range b.Transactions {
+		txs = append(txs, tx.Serialize())
+	}
+
+	return NewMerkleTree(txs).Root.Data
+}
+
+// TransactionProof returns the Merkle proof that the transaction at index is
+// part of the block. The proof verifies against MerkleRoot with the transaction ID
+// as the leaf hash.
+func (b *Block) TransactionProof(index int) ([]ProofStep, error) {
+	if index < 0 || index >= len(b.Transactions) {
+		return nil, ErrLeafIndexOutOfRange
+	}
+
+	var txs [][]byte
+	for _, tx := range b.Transactions {
+		txs = append(txs, tx.Serialize())
+	}
+
+	return NewMerkleTree(txs).Proof(index)
+}
+
+// HeaderBytes returns the fixed-width block header for the given nonce:
+// version, height, previous hash, Merkle root, data hash, timestamp,
+// difficulty bits and nonce
+func (b *Block) HeaderBytes(nonce int) []byte {
+	header := make([]byte, 0, HeaderLen)
+	dataHash := sha256.Sum256(b.Data)
+
+	header = binary.BigEndian.AppendUint32(header, uint32(b.Version))
+	header = binary.BigEndian.AppendUint64(header, uint64(b.Index))
+	header = append(header, fixedHash(b.PrevBlockHash)...)
+	header = append(header, fixedHash(b.MerkleRoot)...)
+	header = append(header, dataHash[:]...)
+	header = binary.BigEndian.AppendUint64(header, uint64(b.Timestamp))
+	header = binary.BigEndian.AppendUint32(header, b.Bits)
+	header = binary.BigEndian.AppendUint64(header, uint64(nonce))
+
+	return header
+}
+
+// ComputeHash returns the hash of the block header
+func (b *Block) ComputeHash() []byte {
+	hash := sha256.Sum256(b.HeaderBytes(b.Nonce))
+	return hash[:]
+}
+
 // SetHash calculates and sets the hash of the block
 func (b *Block) SetHash() {
-	headers := [][]byte{
-		b.PrevBlockHash,
-		b.Data,
-		IntToHex(b.Timestamp),
-		IntToHex(int64(b.Nonce)),
+	b.Hash = b.ComputeHash()
+}
+
+// fixedHash pads or truncates a hash to 32 bytes so headers have a fixed width
+func fixedHash(hash []byte) []byte {
+	fixed := make([]byte, sha256.Size)
+	copy(fixed, hash)
+	return fixed
+}
+
+// blockEncodingVersion is the version of the canonical block encoding
+const blockEncodingVersion = 2
+
+// Serialize returns the canonical binary encoding of the block
+func (b *Block) Serialize() []byte {
+	var e transaction.Encoder
+
+	e.WriteUvarint(blockEncodingVersion)
+	e.WriteVarint(int64(b.Version))
+	e.WriteVarint(b.Index)
+	e.WriteVarint(b.Timestamp)
+	e.WriteBytes(b.Data)
+	e.WriteBytes(b.PrevBlockHash)
+	e.WriteBytes(b.MerkleRoot)
+	e.WriteUvarint(uint64(b.Bits))
+	e.WriteBytes(b.Hash)
+	e.WriteVarint(int64(b.Nonce))
+
+	e.WriteUvarint(uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		e.WriteBytes(tx.Serialize())
 	}
-	header := bytes.Join(headers, []byte{})
-	hash := sha256.Sum256(header)
-	b.Hash = hash[:]
+
+	return e.Bytes()
+}
+
+// DeserializeBlock decodes a block written by Serialize
+func DeserializeBlock(data []byte) (*Block, error) {
+	d := transaction.NewDecoder(data)
+	if err := d.ReadVersion(blockEncodingVersion); err != nil {
+		return nil, err
+	}
+
+	b := &Block{}
+	version, err := d.ReadVarint()
+	if err != nil {
+		return nil, err
+	}
+	b.Version = int32(version)
+	if b.Index, err = d.ReadVarint(); err != nil {
+		return nil, err
+	}
+	if b.Timestamp, err = d.ReadVarint(); err != nil {
+		return nil, err
+	}
+	if b.Data, err = d.ReadBytes(); err != nil {
+		return nil, err
+	}
+	if b.PrevBlockHash, err = d.ReadBytes(); err != nil {
+		return nil, err
+	}
+	if b.MerkleRoot, err = d.ReadBytes(); err != nil {
+		return nil, err
+	}
+	bits, err := d.ReadUvarint()
+	if err != nil {
+		return nil, err
+	}
+	b.Bits = uint32(bits)
+	if b.Hash, err = d.ReadBytes(); err != nil {
+		return nil, err
+	}
+	nonce, err := d.ReadVarint()
+	if err != nil {
+		return nil, err
+	}
+	b.Nonce = int(nonce)
+
+	txCount, err := d.ReadCount(1)
+	if err != nil {
+		return nil, err
+	}
+	b.Transactions = make([]*transaction.Transaction, txCount)
+	for i := range b.Transactions {
+		raw, err := d.ReadBytes()
+		if err != nil {
+			return nil, err
+		}
+		if b.Transactions[i], err = transaction.DeserializeTransaction(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
 }
 
 // IntToHex converts an int64 to a byte array
@@ -59,3 +220,8 @@ func IntToHex(num int64) []byte {
 func GenesisBlock() *Block {
 	return NewBlock("Genesis Block", []byte{})
 }
+
+// NewGenesisBlock creates a genesis block paying the coinbase transaction
+func NewGenesisBlock(coinbase *transaction.Transaction) *Block {
+	return NewBlockWithTransactions("Genesis Block", []byte{}, []*transaction.Transaction{coinbase})
+}
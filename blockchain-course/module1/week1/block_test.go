@@ -0,0 +1,93 @@
+package week1
+
+import (
+	"bytes"
+	"testing"
+
+	"blockchain-course/module1/transaction"
+)
+
+func TestBlockSerializeRoundTrip(t *testing.T) {
+	block := NewBlock("Test data", []byte{0x01, 0x02})
+	block.Index = 7
+	block.Nonce = 42
+	block.Transactions = append(block.Transactions, transaction.NewCoinbaseTX("miner", ""))
+
+	decoded, err := DeserializeBlock(block.Serialize())
+	if err != nil {
+		t.Fatalf("Failed to deserialize block: %v", err)
+	}
+
+	if decoded.Index != 7 || decoded.Nonce != 42 || decoded.Timestamp != block.Timestamp {
+		t.Error("Decoded block fields do not match")
+	}
+
+	if !bytes.Equal(decoded.Hash, block.Hash) || !bytes.Equal(decoded.PrevBlockHash, block.PrevBlockHash) {
+		t.Error("Decoded block hashes do not match")
+	}
+
+	if len(decoded.Transactions) != 1 || !bytes.Equal(decoded.Transactions[0].ID, block.Transactions[0].ID) {
+		t.Error("Decoded block transactions do not match")
+	}
+
+	if !bytes.Equal(decoded.Serialize(), block.Serialize()) {
+		t.Error("Round trip should preserve the encoding")
+	}
+}
+
+func TestDeserializeBlockTruncated(t *testing.T) {
+	data := GenesisBlock().Serialize()
+
+	if _, err := DeserializeBlock(data[:len(data)-2]); err == nil {
+		t.Error("Truncated block should fail to decode")
+	}
+}
+
+func TestHeaderCommitsToTransactions(t *testing.T) {
+	txs := []*transaction.Transaction{
+		transaction.NewCoinbaseTX("alice", ""),
+		transaction.NewCoinbaseTX("bob", ""),
+	}
+	block := NewBlockWithTransactions("", []byte{}, txs)
+
+	if len(block.HeaderBytes(block.Nonce)) != HeaderLen {
+		t.Errorf("Expected header length of %d", HeaderLen)
+	}
+
+	hash := block.ComputeHash()
+
+	// Replacing a transaction changes the Merkle root and therefore the hash
+	block.Transactions[1] = transaction.NewCoinbaseTX("mallory", "")
+	block.MerkleRoot = block.HashTransactions()
+
+	if bytes.Equal(hash, block.ComputeHash()) {
+		t.Error("Block hash should change when transactions change")
+	}
+
+	// The height is part of the header as well
+	block.SetHash()
+	block.Index++
+	if bytes.Equal(block.Hash, block.ComputeHash()) {
+		t.Error("Block hash should change when the height changes")
+	}
+}
+
+func TestTransactionProof(t *testing.T) {
+	txs := []*transaction.Transaction{
+		transaction.NewCoinbaseTX("alice", ""),
+		transaction.NewCoinbaseTX("bob", ""),
+		transaction.NewCoinbaseTX("carol", ""),
+	}
+	block := NewBlockWithTransactions("", []byte{}, txs)
+
+	for i, tx := range txs {
+		proof, err := block.TransactionProof(i)
+		if err != nil {
+			t.Fatalf("Failed to build proof: %v", err)
+		}
+
+		if !VerifyProof(block.MerkleRoot, tx.ID, proof) {
+			t.Errorf("Transaction %d should be proven against the block Merkle root", i)
+		}
+	}
+}
@@ -1,11 +1,11 @@
 package week1
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
+	"bytes"
 	"crypto/sha256"
-	"math/big"
+	"errors"
+
+	"blockchain-course/module1/keys"
 )
 
 // HashData computes the SHA-256 hash of the input data
@@ -14,32 +14,44 @@ func HashData(data []byte) []byte {
 	return hash[:]
 }
 
-// GenerateKeyPair generates a new ECDSA key pair
-func GenerateKeyPair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// GenerateKeyPair generates a new key pair of a signature scheme
+func GenerateKeyPair(scheme keys.Scheme) (keys.Signer, keys.Verifier, error) {
+	privateKey, err := keys.GenerateKey(scheme)
 	if err != nil {
 		return nil, nil, err
 	}
-	return privateKey, &privateKey.PublicKey, nil
+	return privateKey, privateKey.Public(), nil
 }
 
 // SignData signs the data with the private key
-func SignData(privateKey *ecdsa.PrivateKey, data []byte) (r, s *big.Int, err error) {
+func SignData(privateKey keys.Signer, data []byte) ([]byte, error) {
 	hash := sha256.Sum256(data)
-	return ecdsa.Sign(rand.Reader, privateKey, hash[:])
+	return privateKey.Sign(hash[:])
 }
 
 // VerifySignature verifies the signature with the public key
-func VerifySignature(publicKey *ecdsa.PublicKey, data []byte, r, s *big.Int) bool {
+func VerifySignature(publicKey keys.Verifier, data, signature []byte) bool {
 	hash := sha256.Sum256(data)
-	return ecdsa.Verify(publicKey, hash[:], r, s)
+	return publicKey.Verify(hash[:], signature)
 }
 
 // MerkleTree represents a Merkle tree structure
 type MerkleTree struct {
 	Root *MerkleNode
+
+	// levels holds the nodes of every level, starting with the leaves
+	levels [][]*MerkleNode
 }
 
+// ProofStep is a sibling hash on the path from a leaf to the Merkle root
+type ProofStep struct {
+	Hash []byte
+	Left bool // true when the sibling is the left operand
+}
+
+// ErrLeafIndexOutOfRange is returned when a proof is requested for a leaf that does not exist
+var ErrLeafIndexOutOfRange = errors.New("merkle leaf index out of range")
+
 // MerkleNode represents a node in the Merkle tree
 type MerkleNode struct {
 	Left  *MerkleNode
@@ -78,6 +90,7 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		node := NewMerkleNode(nil, nil, datum)
 		nodes = append(nodes, node)
 	}
+	levels := [][]*MerkleNode{nodes}
 
 	// Create internal nodes
 	for len(nodes) > 1 {
@@ -99,11 +112,58 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		}
 
 		nodes = newLevel
+		levels = append(levels, nodes)
 	}
 
 	tree := &MerkleTree{
-		Root: nodes[0],
+		Root:   nodes[0],
+		levels: levels,
 	}
 
 	return tree
 }
+
+// Proof returns the sibling path proving that the leaf at index is part of the tree
+func (t *MerkleTree) Proof(index int) ([]ProofStep, error) {
+	if len(t.levels) == 0 || index < 0 || index >= len(t.levels[0]) {
+		return nil, ErrLeafIndexOutOfRange
+	}
+
+	var proof []ProofStep
+	for _, level := range t.levels[:len(t.levels)-1] {
+		var step ProofStep
+		if index%2 == 0 {
+			// The last node of an odd level is paired with itself
+			sibling := index + 1
+			if sibling == len(level) {
+				sibling = index
+			}
+			step = ProofStep{Hash: level[sibling].Data, Left: false}
+		} else {
+			step = ProofStep{Hash: level[index-1].Data, Left: true}
+		}
+
+		proof = append(proof, step)
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyProof checks that leafHash, the hash of the leaf data (for example a
+// transaction ID), is included in the tree with the given root
+func VerifyProof(root, leafHash []byte, proof []ProofStep) bool {
+	current := leafHash
+	for _, step := range proof {
+		var combined []byte
+		if step.Left {
+			combined = append(append(combined, step.Hash...), current...)
+		} else {
+			combined = append(append(combined, current...), step.Hash...)
+		}
+		hash := sha256.Sum256(combined)
+		current = hash[:]
+	}
+
+	return bytes.Equal(current, root)
+}
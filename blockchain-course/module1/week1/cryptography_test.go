@@ -2,6 +2,8 @@ package week1
 
 import (
 	"testing"
+
+	"blockchain-course/module1/keys"
 )
 
 func TestHashData(t *testing.T) {
@@ -22,43 +24,47 @@ func TestHashData(t *testing.T) {
 }
 
 func TestGenerateKeyPair(t *testing.T) {
-	privateKey, publicKey, err := GenerateKeyPair()
+	for _, scheme := range keys.Schemes {
+		privateKey, publicKey, err := GenerateKeyPair(scheme)
 
-	if err != nil {
-		t.Fatalf("Failed to generate key pair: %v", err)
-	}
+		if err != nil {
+			t.Fatalf("Failed to generate %s key pair: %v", scheme, err)
+		}
 
-	if privateKey == nil {
-		t.Error("Private key is nil")
-	}
+		if privateKey == nil {
+			t.Error("Private key is nil")
+		}
 
-	if publicKey == nil {
-		t.Error("Public key is nil")
+		if publicKey == nil || publicKey.Scheme() != scheme {
+			t.Errorf("Expected a %s public key, got %v", scheme, publicKey)
+		}
 	}
 }
 
 func TestSignAndVerify(t *testing.T) {
-	privateKey, publicKey, err := GenerateKeyPair()
-	if err != nil {
-		t.Fatalf("Failed to generate key pair: %v", err)
-	}
+	for _, scheme := range keys.Schemes {
+		privateKey, publicKey, err := GenerateKeyPair(scheme)
+		if err != nil {
+			t.Fatalf("Failed to generate key pair: %v", err)
+		}
 
-	data := []byte("Data to sign")
-	r, s, err := SignData(privateKey, data)
-	if err != nil {
-		t.Fatalf("Failed to sign data: %v", err)
-	}
+		data := []byte("Data to sign")
+		signature, err := SignData(privateKey, data)
+		if err != nil {
+			t.Fatalf("Failed to sign data: %v", err)
+		}
 
-	valid := VerifySignature(publicKey, data, r, s)
-	if !valid {
-		t.Error("Signature verification failed")
-	}
+		valid := VerifySignature(publicKey, data, signature)
+		if !valid {
+			t.Errorf("%s signature verification failed", scheme)
+		}
 
-	// Test with wrong data
-	wrongData := []byte("Wrong data")
-	valid = VerifySignature(publicKey, wrongData, r, s)
-	if valid {
-		t.Error("Signature verification should fail with wrong data")
+		// Test with wrong data
+		wrongData := []byte("Wrong data")
+		valid = VerifySignature(publicKey, wrongData, signature)
+		if valid {
+			t.Errorf("%s signature verification should fail with wrong data", scheme)
+		}
 	}
 }
 
@@ -80,3 +86,51 @@ func TestMerkleTree(t *testing.T) {
 		t.Errorf("Expected root hash length of 32, got %d", len(tree.Root.Data))
 	}
 }
+
+func TestMerkleProof(t *testing.T) {
+	// Cover both even and odd numbers of leaves
+	for n := 1; n <= 7; n++ {
+		var data [][]byte
+		for i := 0; i < n; i++ {
+			data = append(data, []byte{byte(i)})
+		}
+
+		tree := NewMerkleTree(data)
+
+		for i := 0; i < n; i++ {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatalf("Failed to build proof for leaf %d of %d: %v", i, n, err)
+			}
+
+			leafHash := HashData(data[i])
+			if !VerifyProof(tree.Root.Data, leafHash, proof) {
+				t.Errorf("Proof for leaf %d of %d should verify", i, n)
+			}
+
+			if VerifyProof(tree.Root.Data, HashData([]byte("other")), proof) {
+				t.Errorf("Proof for leaf %d of %d should not verify another leaf", i, n)
+			}
+		}
+	}
+}
+
+func TestMerkleProofTampered(t *testing.T) {
+	data := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	tree := NewMerkleTree(data)
+
+	proof, err := tree.Proof(2)
+	if err != nil {
+		t.Fatalf("Failed to build proof: %v", err)
+	}
+
+	// Flipping a direction flag must break the proof
+	proof[0].Left = !proof[0].Left
+	if VerifyProof(tree.Root.Data, HashData(data[2]), proof) {
+		t.Error("Tampered proof should not verify")
+	}
+
+	if _, err := tree.Proof(4); err != ErrLeafIndexOutOfRange {
+		t.Errorf("Expected ErrLeafIndexOutOfRange, got %v", err)
+	}
+}
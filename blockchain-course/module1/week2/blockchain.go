@@ -2,21 +2,35 @@ package week2
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
-	"fmt"
-	"math"
+	"errors"
 	"math/big"
-	"os"
-	"strings"
+	"slices"
+	"sync"
 
+	"blockchain-course/module1/transaction"
 	"blockchain-course/module1/week1"
 )
 
 const targetBits = 8 // Reduced difficulty for testing - controls mining difficulty
 
+// defaultBits is the compact form of the target used when a block carries no difficulty
+var defaultBits = TargetBitsToCompact(targetBits)
+
+// ErrBlockchainExists is returned when creating a blockchain in a storage that already holds one
+var ErrBlockchainExists = errors.New("blockchain already exists")
+
 // Blockchain represents the blockchain structure
 type Blockchain struct {
-	Blocks []*week1.Block
+	store  Storage
+	params Params
+
+	mu        sync.Mutex
+	nodes     map[string]*blockNode
+	tip       *blockNode
+	orphans   map[string][]*week1.Block
+	observers []ChainObserver
 }
 
 // ProofOfWork represents the proof of work structure
@@ -25,40 +39,186 @@ type ProofOfWork struct {
 	target *big.Int
 }
 
-// NewProofOfWork creates a new ProofOfWork
+// NewProofOfWork creates a new ProofOfWork for the difficulty stored in the block header.
+// A block without difficulty bits is given the default difficulty.
 func NewProofOfWork(block *week1.Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	if block.Bits == 0 {
+		block.Bits = defaultBits
+	}
+	target := CompactToBig(block.Bits)
 
 	pow := &ProofOfWork{block: block, target: target}
 	return pow
 }
 
-// NewBlockchain creates a new Blockchain with genesis block
+// NewBlockchain creates a new in-memory Blockchain with genesis block
 func NewBlockchain() *Blockchain {
-	return &Blockchain{Blocks: []*week1.Block{week1.GenesisBlock()}}
+	bc, err := NewBlockchainWithStorage(NewMemoryStorage())
+	if err != nil {
+		// The in-memory storage cannot fail to store the genesis block
+		panic(err)
+	}
+	return bc
 }
 
-// AddBlock adds a new block to the blockchain
-func (bc *Blockchain) AddBlock(data string) {
-	prevBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := week1.NewBlock(data, prevBlock.Hash)
+// NewBlockchainWithStorage opens a Blockchain on top of the given storage.
+// An empty storage is initialised with the genesis block, otherwise the
+// chain resumes at the stored tip.
+func NewBlockchainWithStorage(store Storage) (*Blockchain, error) {
+	return NewBlockchainWithParams(store, DefaultParams())
+}
+
+// NewBlockchainWithParams opens a Blockchain on top of the given storage with
+// custom consensus parameters
+func NewBlockchainWithParams(store Storage, params Params) (*Blockchain, error) {
+	if store.Height() < 0 {
+		genesis := week1.GenesisBlock()
+		if err := store.PutBlock(genesis); err != nil {
+			return nil, err
+		}
+		if err := store.SetTip(genesis.Hash); err != nil {
+			return nil, err
+		}
+	}
+
+	bc := &Blockchain{store: store, params: params}
+	if err := bc.loadTree(); err != nil {
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+// CreateBlockchain initialises an empty storage with the given genesis block
+func CreateBlockchain(store Storage, params Params, genesis *week1.Block) (*Blockchain, error) {
+	if store.Height() >= 0 {
+		return nil, ErrBlockchainExists
+	}
+
+	if err := store.PutBlock(genesis); err != nil {
+		return nil, err
+	}
+	if err := store.SetTip(genesis.Hash); err != nil {
+		return nil, err
+	}
+
+	return NewBlockchainWithParams(store, params)
+}
+
+// AddBlock mines a new block with the given data on top of the tip and appends it to the blockchain
+func (bc *Blockchain) AddBlock(data string) error {
+	return bc.AddBlockWithTransactions(data, make([]*transaction.Transaction, 0))
+}
+
+// AddBlockWithTransactions mines a new block carrying transactions on top of the tip and appends it
+func (bc *Blockchain) AddBlockWithTransactions(data string, txs []*transaction.Transaction) error {
+	prevBlock, err := bc.TipBlock()
+	if err != nil {
+		return err
+	}
+
+	newBlock, err := bc.MineBlock(prevBlock, data, txs)
+	if err != nil {
+		return err
+	}
+
+	_, err = bc.ProcessBlock(newBlock)
+	return err
+}
+
+// MineBlock mines a new block on top of parent without adding it to the blockchain
+func (bc *Blockchain) MineBlock(parent *week1.Block, data string, txs []*transaction.Transaction) (*week1.Block, error) {
+	block, _, err := bc.MineBlockContext(context.Background(), parent, data, txs)
+	return block, err
+}
+
+// MineBlockContext mines a new block on top of parent using every CPU. Mining
+// is aborted with the context error when ctx is done, for example because a
+// competing block arrived.
+func (bc *Blockchain) MineBlockContext(ctx context.Context, parent *week1.Block, data string, txs []*transaction.Transaction) (*week1.Block, MiningStats, error) {
+	newBlock := week1.NewBlockWithTransactions(data, parent.Hash, txs)
+	newBlock.Index = parent.Index + 1
+
+	// Blocks mined within the same second still follow the median time of
+	// their parents, or the parent itself when its ancestors are unknown
+	medianTime, err := bc.MedianTimePast(parent.Hash)
+	if err != nil {
+		medianTime = parent.Timestamp
+	}
+	if newBlock.Timestamp <= medianTime {
+		newBlock.Timestamp = medianTime + 1
+	}
+
+	if newBlock.Bits, err = bc.NextBits(parent); err != nil {
+		return nil, MiningStats{}, err
+	}
 
 	// Mine the block
 	pow := NewProofOfWork(newBlock)
-	nonce, hash := pow.Run()
+	nonce, hash, stats, err := pow.Mine(ctx, 0)
+	if err != nil {
+		return nil, stats, err
+	}
 
-	newBlock.Hash = hash[:]
+	newBlock.Hash = hash
 	newBlock.Nonce = nonce
 
-	bc.Blocks = append(bc.Blocks, newBlock)
+	return newBlock, stats, nil
+}
+
+// Height returns the height of the tip of the blockchain
+func (bc *Blockchain) Height() int64 {
+	return bc.store.Height()
+}
+
+// TipBlock returns the last block of the blockchain
+func (bc *Blockchain) TipBlock() (*week1.Block, error) {
+	return bc.store.GetBlock(bc.store.Tip())
+}
+
+// GetBlock returns the block with the given hash
+func (bc *Blockchain) GetBlock(hash []byte) (*week1.Block, error) {
+	return bc.store.GetBlock(hash)
+}
+
+// GetBlockByHeight returns the block at the given height
+func (bc *Blockchain) GetBlockByHeight(height int64) (*week1.Block, error) {
+	return bc.store.GetBlockByHeight(height)
+}
+
+// Close closes the underlying storage
+func (bc *Blockchain) Close() error {
+	return bc.store.Close()
 }
 
 // IsValid checks if the blockchain is valid
 func (bc *Blockchain) IsValid() bool {
-	for i := 1; i < len(bc.Blocks); i++ {
-		currentBlock := bc.Blocks[i]
-		prevBlock := bc.Blocks[i-1]
+	prevBlock, err := bc.store.GetBlockByHeight(0)
+	if err != nil {
+		return false
+	}
+	if !validHeader(prevBlock) || checkFutureTime(prevBlock) != nil {
+		return false
+	}
+
+	// Timestamps of the last medianTimeBlocks blocks, oldest first
+	timestamps := []int64{prevBlock.Timestamp}
+	for i := int64(1); i <= bc.store.Height(); i++ {
+		currentBlock, err := bc.store.GetBlockByHeight(i)
+		if err != nil {
+			return false
+		}
+
+		// Check that the header commits to the block contents
+		if !validHeader(currentBlock) {
+			return false
+		}
+
+		// Check that the block was mined at the required difficulty
+		expectedBits, err := bc.NextBits(prevBlock)
+		if err != nil || currentBlock.Bits != expectedBits {
+			return false
+		}
 
 		// Check if the current block's hash is valid with proof of work
 		pow := NewProofOfWork(currentBlock)
@@ -66,61 +226,46 @@ func (bc *Blockchain) IsValid() bool {
 			return false
 		}
 
-		// Check if the previous block hash matches
+		// Check if the previous block hash and height match
 		if !bytes.Equal(currentBlock.PrevBlockHash, prevBlock.Hash) {
 			return false
 		}
-	}
-	return true
-}
-
-// Run performs the proof of work algorithm
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	var nonce int = 0
-
-	// Only print mining progress if not in test mode
-	if !isTesting() {
-		fmt.Printf("Mining a new block...")
-	}
-	for nonce < math.MaxInt64 {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-		// Only print progress if not in test mode
-		if !isTesting() {
-			fmt.Printf("\r%x", hash)
+		if currentBlock.Index != prevBlock.Index+1 {
+			return false
 		}
-		hashInt.SetBytes(hash[:])
 
-		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
+		// Check that the timestamp follows the median time of the previous
+		// blocks and is not too far in the future
+		if checkMedianTime(currentBlock, medianTime(slices.Clone(timestamps))) != nil || checkFutureTime(currentBlock) != nil {
+			return false
+		}
+		timestamps = append(timestamps, currentBlock.Timestamp)
+		if len(timestamps) > medianTimeBlocks {
+			timestamps = timestamps[1:]
 		}
+
+		prevBlock = currentBlock
 	}
-	// Only print newline if not in test mode
-	if !isTesting() {
-		fmt.Print("\n\n")
+	return true
+}
+
+// validHeader checks the Merkle root and the header hash of a block
+func validHeader(block *week1.Block) bool {
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return false
 	}
+	return bytes.Equal(block.Hash, block.ComputeHash())
+}
 
-	return nonce, hash[:]
+// Run performs the proof of work algorithm on every CPU until a solution is found
+func (pow *ProofOfWork) Run() (int, []byte) {
+	nonce, hash, _, _ := pow.Mine(context.Background(), 0)
+	return nonce, hash
 }
 
-// prepareData prepares the data for hashing in POW
+// prepareData prepares the block header for hashing in POW
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.Data,
-			week1.IntToHex(pow.block.Timestamp),
-			week1.IntToHex(int64(targetBits)),
-			week1.IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+	return pow.block.HeaderBytes(nonce)
 }
 
 // Validate validates the proof of work
@@ -136,35 +281,36 @@ func (pow *ProofOfWork) Validate() bool {
 	return isValid
 }
 
-// BlockchainIterator represents an iterator for the blockchain
+// BlockchainIterator walks the blockchain from the tip back to the genesis block
 type BlockchainIterator struct {
-	current int
-	bc      *Blockchain
+	currentHash []byte
+	bc          *Blockchain
 }
 
 // Iterator returns a blockchain iterator
 func (bc *Blockchain) Iterator() *BlockchainIterator {
 	return &BlockchainIterator{
-		current: len(bc.Blocks) - 1,
-		bc:      bc,
+		currentHash: bc.store.Tip(),
+		bc:          bc,
 	}
 }
 
-// Next returns the next block in the blockchain
+// Next returns the next block in the blockchain, or nil after the genesis block
 func (i *BlockchainIterator) Next() *week1.Block {
-	if i.current < 0 {
+	if i.currentHash == nil {
+		return nil
+	}
+
+	block, err := i.bc.store.GetBlock(i.currentHash)
+	if err != nil {
 		return nil
 	}
 
-	block := i.bc.Blocks[i.current]
-	i.current--
+	if len(block.PrevBlockHash) == 0 {
+		i.currentHash = nil
+	} else {
+		i.currentHash = block.PrevBlockHash
+	}
 
 	return block
 }
-
-// isTesting checks if we're running in test mode
-func isTesting() bool {
-	// Check if we're running tests by looking at the binary name
-	// This is a simple way to detect test mode
-	return strings.HasSuffix(os.Args[0], ".test") || strings.Contains(os.Args[0], "/_test/")
-}
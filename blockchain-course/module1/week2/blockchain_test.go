@@ -2,18 +2,25 @@ package week2
 
 import (
 	"testing"
+	"time"
 
+	"blockchain-course/module1/transaction"
 	week1 "blockchain-course/module1/week1"
 )
 
 func TestNewBlockchain(t *testing.T) {
 	bc := NewBlockchain()
 
-	if len(bc.Blocks) != 1 {
-		t.Errorf("Expected blockchain to have 1 block, got %d", len(bc.Blocks))
+	if bc.Height() != 0 {
+		t.Errorf("Expected blockchain to have 1 block, got %d", bc.Height()+1)
 	}
 
-	if string(bc.Blocks[0].Data) != "Genesis Block" {
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatalf("Failed to read genesis block: %v", err)
+	}
+
+	if string(genesis.Data) != "Genesis Block" {
 		t.Error("Genesis block data is incorrect")
 	}
 }
@@ -22,13 +29,20 @@ func TestAddBlock(t *testing.T) {
 	bc := NewBlockchain()
 
 	// Add a block
-	bc.AddBlock("Test data")
+	if err := bc.AddBlock("Test data"); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+
+	if bc.Height() != 1 {
+		t.Errorf("Expected blockchain to have 2 blocks, got %d", bc.Height()+1)
+	}
 
-	if len(bc.Blocks) != 2 {
-		t.Errorf("Expected blockchain to have 2 blocks, got %d", len(bc.Blocks))
+	block, err := bc.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatalf("Failed to read block: %v", err)
 	}
 
-	if string(bc.Blocks[1].Data) != "Test data" {
+	if string(block.Data) != "Test data" {
 		t.Error("Block data is incorrect")
 	}
 }
@@ -46,8 +60,12 @@ func TestIsValid(t *testing.T) {
 	}
 
 	// Tamper with a block
-	bc.Blocks[1].Data = []byte("Tampered data")
-	bc.Blocks[1].SetHash()
+	block, err := bc.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	block.Data = []byte("Tampered data")
+	block.SetHash()
 
 	// Check if blockchain is invalid
 	if bc.IsValid() {
@@ -55,6 +73,42 @@ func TestIsValid(t *testing.T) {
 	}
 }
 
+func TestIsValidChecksTimestamps(t *testing.T) {
+	bc := NewBlockchain()
+	bc.AddBlock("Block 1")
+	bc.AddBlock("Block 2")
+
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	tip, err := bc.GetBlockByHeight(2)
+	if err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	timestamp := tip.Timestamp
+
+	// A timestamp not after the median time of the previous blocks is rejected
+	tip.Timestamp = genesis.Timestamp
+	tip.Nonce, tip.Hash = NewProofOfWork(tip).Run()
+	if bc.IsValid() {
+		t.Error("Blockchain should be invalid with a timestamp before the median time")
+	}
+
+	// as is a timestamp too far ahead of the local clock
+	tip.Timestamp = time.Now().Unix() + maxFutureBlockTime + 60
+	tip.Nonce, tip.Hash = NewProofOfWork(tip).Run()
+	if bc.IsValid() {
+		t.Error("Blockchain should be invalid with a timestamp in the future")
+	}
+
+	tip.Timestamp = timestamp
+	tip.Nonce, tip.Hash = NewProofOfWork(tip).Run()
+	if !bc.IsValid() {
+		t.Error("Blockchain should be valid with the original timestamp")
+	}
+}
+
 func TestProofOfWork(t *testing.T) {
 	block := week1.NewBlock("Test data", []byte{})
 	pow := NewProofOfWork(block)
@@ -78,3 +132,36 @@ func TestProofOfWork(t *testing.T) {
 		t.Error("Proof of work should be valid")
 	}
 }
+
+func TestIsValidDetectsSwappedTransactions(t *testing.T) {
+	bc := NewBlockchain()
+	bc.AddBlock("Block 1")
+
+	block, err := bc.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+
+	// Smuggle a transaction into an already mined block
+	block.Transactions = append(block.Transactions, transaction.NewCoinbaseTX("thief", ""))
+
+	if bc.IsValid() {
+		t.Error("Blockchain should be invalid when transactions are not committed by the header")
+	}
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	bits := TargetBitsToCompact(targetBits)
+	if bits != 0x20010000 {
+		t.Errorf("Unexpected compact bits %08x", bits)
+	}
+
+	target := CompactToBig(bits)
+	if BigToCompact(target) != bits {
+		t.Error("Compact conversion should round trip")
+	}
+
+	if target.BitLen() != 256-targetBits+1 {
+		t.Errorf("Unexpected target bit length %d", target.BitLen())
+	}
+}
@@ -0,0 +1,409 @@
+package week2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"blockchain-course/module1/week1"
+)
+
+// maxOrphans bounds the number of blocks buffered while their parent is unknown
+const maxOrphans = 100
+
+const (
+	// medianTimeBlocks is the number of blocks whose median timestamp a child must exceed
+	medianTimeBlocks = 11
+	// maxFutureBlockTime is how many seconds a timestamp may be ahead of the local clock
+	maxFutureBlockTime = 2 * 60 * 60
+)
+
+var (
+	// ErrInvalidBlock is returned when a block breaks a consensus rule
+	ErrInvalidBlock = errors.New("invalid block")
+	// ErrDuplicateBlock is returned when a block has already been processed
+	ErrDuplicateBlock = errors.New("block already known")
+)
+
+// BlockStatus describes where a processed block ended up
+type BlockStatus int
+
+const (
+	// BlockMainChain means the block is part of the main chain
+	BlockMainChain BlockStatus = iota
+	// BlockSideChain means the block was stored on a branch with less work than the main chain
+	BlockSideChain
+	// BlockOrphan means the block is buffered until its parent arrives
+	BlockOrphan
+)
+
+// ChainObserver is notified when blocks join or leave the main chain. A
+// reorganization disconnects blocks from the old tip down to the fork point,
+// then connects the new branch from the fork point up. An error returned by
+// ConnectBlock rejects the block and rolls the reorganization back.
+type ChainObserver interface {
+	ConnectBlock(block *week1.Block) error
+	DisconnectBlock(block *week1.Block) error
+}
+
+// blockNode is an entry of the block tree
+type blockNode struct {
+	hash      []byte
+	parent    *blockNode
+	height    int64
+	timestamp int64
+	work      *big.Int // cumulative work from the genesis block
+	invalid   bool
+}
+
+// medianTimePast returns the median timestamp of the node and its
+// medianTimeBlocks-1 closest ancestors
+func (node *blockNode) medianTimePast() int64 {
+	timestamps := make([]int64, 0, medianTimeBlocks)
+	for ; node != nil && len(timestamps) < medianTimeBlocks; node = node.parent {
+		timestamps = append(timestamps, node.timestamp)
+	}
+	return medianTime(timestamps)
+}
+
+// medianTime returns the median of the timestamps, sorting them in place
+func medianTime(timestamps []int64) int64 {
+	slices.Sort(timestamps)
+	return timestamps[len(timestamps)/2]
+}
+
+// checkFutureTime checks that the timestamp of a block is not more than
+// maxFutureBlockTime seconds ahead of the local clock
+func checkFutureTime(block *week1.Block) error {
+	if limit := time.Now().Unix() + maxFutureBlockTime; block.Timestamp > limit {
+		return fmt.Errorf("%w: timestamp %d is more than %d seconds in the future", ErrInvalidBlock, block.Timestamp, maxFutureBlockTime)
+	}
+	return nil
+}
+
+// checkMedianTime checks that the timestamp of a block is after the median
+// time of the previous blocks
+func checkMedianTime(block *week1.Block, medianTime int64) error {
+	if block.Timestamp <= medianTime {
+		return fmt.Errorf("%w: timestamp %d is not after the median time %d of the previous blocks", ErrInvalidBlock, block.Timestamp, medianTime)
+	}
+	return nil
+}
+
+// MedianTimePast returns the median timestamp of the block with the given hash
+// and its closest ancestors, which the timestamp of a child must exceed
+func (bc *Blockchain) MedianTimePast(hash []byte) (int64, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	node, ok := bc.nodes[hex.EncodeToString(hash)]
+	if !ok {
+		return 0, ErrBlockNotFound
+	}
+	return node.medianTimePast(), nil
+}
+
+// AddObserver registers an observer for main chain changes
+func (bc *Blockchain) AddObserver(observer ChainObserver) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.observers = append(bc.observers, observer)
+}
+
+// CalcWork returns the expected number of hashes needed to mine a block at the given difficulty
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	// work = 2^256 / (target + 1)
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	numerator := new(big.Int).Lsh(big.NewInt(1), 256)
+	return numerator.Div(numerator, denominator)
+}
+
+// loadTree builds the block tree from every stored block
+func (bc *Blockchain) loadTree() error {
+	bc.nodes = make(map[string]*blockNode)
+	bc.orphans = make(map[string][]*week1.Block)
+
+	err := bc.store.ForEach(func(block *week1.Block) error {
+		_, err := bc.addNode(block)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	tip, ok := bc.nodes[hex.EncodeToString(bc.store.Tip())]
+	if !ok {
+		return ErrBlockNotFound
+	}
+	bc.tip = tip
+	return nil
+}
+
+// addNode inserts a block into the tree, its parent must already be in the tree
+func (bc *Blockchain) addNode(block *week1.Block) (*blockNode, error) {
+	node := &blockNode{
+		hash:      block.Hash,
+		height:    block.Index,
+		timestamp: block.Timestamp,
+		work:      CalcWork(block.Bits),
+	}
+
+	if len(block.PrevBlockHash) != 0 {
+		parent, ok := bc.nodes[hex.EncodeToString(block.PrevBlockHash)]
+		if !ok {
+			return nil, ErrUnknownParent
+		}
+		node.parent = parent
+		node.work.Add(node.work, parent.work)
+	}
+
+	bc.nodes[hex.EncodeToString(block.Hash)] = node
+	return node, nil
+}
+
+// ProcessBlock accepts a block from any source, typically a peer. The block is
+// buffered as an orphan if its parent is unknown, stored on a side branch if
+// its branch has less cumulative work than the main chain, or becomes the new
+// tip, reorganizing the main chain if it extends another branch.
+func (bc *Blockchain) ProcessBlock(block *week1.Block) (BlockStatus, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	status, err := bc.processBlock(block)
+	if err != nil {
+		return status, err
+	}
+
+	// Blocks waiting for this one can now be connected
+	queue := [][]byte{block.Hash}
+	for len(queue) > 0 {
+		parentKey := hex.EncodeToString(queue[0])
+		queue = queue[1:]
+
+		children := bc.orphans[parentKey]
+		delete(bc.orphans, parentKey)
+		for _, child := range children {
+			if _, err := bc.processBlock(child); err == nil {
+				queue = append(queue, child.Hash)
+			}
+		}
+	}
+
+	if bc.tip != nil && bytes.Equal(bc.tip.hash, block.Hash) {
+		return BlockMainChain, nil
+	}
+	return status, nil
+}
+
+func (bc *Blockchain) processBlock(block *week1.Block) (BlockStatus, error) {
+	key := hex.EncodeToString(block.Hash)
+	if _, ok := bc.nodes[key]; ok {
+		return BlockSideChain, ErrDuplicateBlock
+	}
+
+	// Context free checks: the header must commit to the contents and carry valid work
+	if !validHeader(block) {
+		return BlockSideChain, fmt.Errorf("%w: header does not match block contents", ErrInvalidBlock)
+	}
+	if block.Bits == 0 || !NewProofOfWork(block).Validate() {
+		return BlockSideChain, fmt.Errorf("%w: proof of work is not valid", ErrInvalidBlock)
+	}
+	if err := checkFutureTime(block); err != nil {
+		return BlockSideChain, err
+	}
+
+	if len(block.PrevBlockHash) == 0 {
+		return BlockSideChain, fmt.Errorf("%w: unknown genesis block", ErrInvalidBlock)
+	}
+	parent, ok := bc.nodes[hex.EncodeToString(block.PrevBlockHash)]
+	if !ok {
+		bc.addOrphan(block)
+		return BlockOrphan, nil
+	}
+	if parent.invalid {
+		return BlockSideChain, fmt.Errorf("%w: parent block is invalid", ErrInvalidBlock)
+	}
+
+	// Contextual checks against the parent
+	if block.Index != parent.height+1 {
+		return BlockSideChain, fmt.Errorf("%w: height %d does not follow parent height %d", ErrInvalidBlock, block.Index, parent.height)
+	}
+	if err := checkMedianTime(block, parent.medianTimePast()); err != nil {
+		return BlockSideChain, err
+	}
+	parentBlock, err := bc.store.GetBlock(parent.hash)
+	if err != nil {
+		return BlockSideChain, err
+	}
+	expectedBits, err := bc.NextBits(parentBlock)
+	if err != nil {
+		return BlockSideChain, err
+	}
+	if block.Bits != expectedBits {
+		return BlockSideChain, fmt.Errorf("%w: difficulty bits %08x, expected %08x", ErrInvalidBlock, block.Bits, expectedBits)
+	}
+
+	if err := bc.store.PutBlock(block); err != nil {
+		return BlockSideChain, err
+	}
+	node, err := bc.addNode(block)
+	if err != nil {
+		return BlockSideChain, err
+	}
+
+	if node.work.Cmp(bc.tip.work) <= 0 {
+		return BlockSideChain, nil
+	}
+
+	if err := bc.reorganize(node); err != nil {
+		return BlockSideChain, err
+	}
+	return BlockMainChain, nil
+}
+
+// addOrphan buffers a block until its parent is processed
+func (bc *Blockchain) addOrphan(block *week1.Block) {
+	if bc.orphanCount() >= maxOrphans {
+		// Evict an arbitrary orphan to make room
+		for parentKey := range bc.orphans {
+			delete(bc.orphans, parentKey)
+			break
+		}
+	}
+
+	parentKey := hex.EncodeToString(block.PrevBlockHash)
+	for _, orphan := range bc.orphans[parentKey] {
+		if bytes.Equal(orphan.Hash, block.Hash) {
+			return
+		}
+	}
+	bc.orphans[parentKey] = append(bc.orphans[parentKey], block)
+}
+
+func (bc *Blockchain) orphanCount() int {
+	count := 0
+	for _, blocks := range bc.orphans {
+		count += len(blocks)
+	}
+	return count
+}
+
+// OrphanCount returns the number of blocks waiting for their parent
+func (bc *Blockchain) OrphanCount() int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.orphanCount()
+}
+
+// reorganize makes newTip the tip of the main chain, disconnecting the blocks
+// of the old branch and connecting the blocks of the new one
+func (bc *Blockchain) reorganize(newTip *blockNode) error {
+	fork := findFork(bc.tip, newTip)
+
+	var detach []*blockNode
+	for node := bc.tip; node != fork; node = node.parent {
+		detach = append(detach, node)
+	}
+
+	var attach []*blockNode
+	for node := newTip; node != fork; node = node.parent {
+		attach = append([]*blockNode{node}, attach...)
+	}
+
+	for i, node := range detach {
+		if err := bc.notify(node, false); err != nil {
+			bc.reconnect(detach[:i])
+			return err
+		}
+	}
+
+	for i, node := range attach {
+		if err := bc.notify(node, true); err != nil {
+			// Mark the rejected block and everything built on it as invalid
+			for _, descendant := range attach[i:] {
+				descendant.invalid = true
+			}
+			bc.disconnect(attach[:i])
+			bc.reconnect(detach)
+			return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+		}
+	}
+
+	if err := bc.store.SetTip(newTip.hash); err != nil {
+		return err
+	}
+	bc.tip = newTip
+	return nil
+}
+
+// reconnect connects previously detached blocks again, oldest first
+func (bc *Blockchain) reconnect(detached []*blockNode) {
+	for i := len(detached) - 1; i >= 0; i-- {
+		bc.notify(detached[i], true)
+	}
+}
+
+// disconnect disconnects previously attached blocks, newest first
+func (bc *Blockchain) disconnect(attached []*blockNode) {
+	for i := len(attached) - 1; i >= 0; i-- {
+		bc.notify(attached[i], false)
+	}
+}
+
+// notify informs the observers that a block joined or left the main chain
+func (bc *Blockchain) notify(node *blockNode, connect bool) error {
+	if len(bc.observers) == 0 {
+		return nil
+	}
+
+	block, err := bc.store.GetBlock(node.hash)
+	if err != nil {
+		return err
+	}
+
+	for i, observer := range bc.observers {
+		if connect {
+			err = observer.ConnectBlock(block)
+		} else {
+			err = observer.DisconnectBlock(block)
+		}
+		if err != nil {
+			// Undo the observers that already saw the change
+			for j := i - 1; j >= 0; j-- {
+				if connect {
+					bc.observers[j].DisconnectBlock(block)
+				} else {
+					bc.observers[j].ConnectBlock(block)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// findFork returns the last common ancestor of two nodes
+func findFork(a, b *blockNode) *blockNode {
+	for a.height > b.height {
+		a = a.parent
+	}
+	for b.height > a.height {
+		b = b.parent
+	}
+	for a != b {
+		a = a.parent
+		b = b.parent
+	}
+	return a
+}
@@ -0,0 +1,263 @@
+package week2
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"blockchain-course/module1/week1"
+)
+
+// recordingObserver records the main chain changes it is notified of
+type recordingObserver struct {
+	events []string
+	reject string
+}
+
+func (o *recordingObserver) ConnectBlock(block *week1.Block) error {
+	if string(block.Data) == o.reject {
+		return errors.New("rejected by observer")
+	}
+	o.events = append(o.events, "+"+string(block.Data))
+	return nil
+}
+
+func (o *recordingObserver) DisconnectBlock(block *week1.Block) error {
+	o.events = append(o.events, "-"+string(block.Data))
+	return nil
+}
+
+func mineOn(t *testing.T, bc *Blockchain, parent *week1.Block, data string) *week1.Block {
+	block, err := bc.MineBlock(parent, data, nil)
+	if err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	return block
+}
+
+func processBlock(t *testing.T, bc *Blockchain, block *week1.Block, expected BlockStatus) {
+	status, err := bc.ProcessBlock(block)
+	if err != nil {
+		t.Fatalf("Failed to process block %s: %v", block.Data, err)
+	}
+	if status != expected {
+		t.Errorf("Block %s: expected status %d, got %d", block.Data, expected, status)
+	}
+}
+
+func equalEvents(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReorganizeToHeavierChain(t *testing.T) {
+	bc := NewBlockchain()
+	observer := &recordingObserver{}
+	bc.AddObserver(observer)
+
+	genesis, _ := bc.GetBlockByHeight(0)
+
+	a1 := mineOn(t, bc, genesis, "a1")
+	processBlock(t, bc, a1, BlockMainChain)
+
+	// A competing block with equal work does not replace the first seen tip
+	b1 := mineOn(t, bc, genesis, "b1")
+	processBlock(t, bc, b1, BlockSideChain)
+	if !bytes.Equal(bc.store.Tip(), a1.Hash) {
+		t.Error("Tip should stay on the first seen branch")
+	}
+
+	// Extending the competing branch makes it heavier
+	b2 := mineOn(t, bc, b1, "b2")
+	processBlock(t, bc, b2, BlockMainChain)
+
+	if bc.Height() != 2 || !bytes.Equal(bc.store.Tip(), b2.Hash) {
+		t.Error("Chain should reorganize to the heavier branch")
+	}
+
+	block, _ := bc.GetBlockByHeight(1)
+	if !bytes.Equal(block.Hash, b1.Hash) {
+		t.Error("Height index should follow the new main chain")
+	}
+
+	expected := []string{"+a1", "-a1", "+b1", "+b2"}
+	if !equalEvents(observer.events, expected) {
+		t.Errorf("Unexpected observer events %v, expected %v", observer.events, expected)
+	}
+
+	if !bc.IsValid() {
+		t.Error("Reorganized chain should be valid")
+	}
+}
+
+func TestOrphanBlocks(t *testing.T) {
+	bc := NewBlockchain()
+	genesis, _ := bc.GetBlockByHeight(0)
+
+	b1 := mineOn(t, bc, genesis, "b1")
+	b2 := mineOn(t, bc, b1, "b2")
+
+	// The child arrives before its parent
+	processBlock(t, bc, b2, BlockOrphan)
+	if bc.OrphanCount() != 1 {
+		t.Errorf("Expected 1 orphan, got %d", bc.OrphanCount())
+	}
+
+	processBlock(t, bc, b1, BlockMainChain)
+
+	if bc.OrphanCount() != 0 {
+		t.Error("Orphan should be connected once its parent arrives")
+	}
+	if !bytes.Equal(bc.store.Tip(), b2.Hash) {
+		t.Error("Connected orphan should become the tip")
+	}
+}
+
+func TestProcessBlockRejectsInvalidBlocks(t *testing.T) {
+	bc := NewBlockchain()
+	genesis, _ := bc.GetBlockByHeight(0)
+
+	b1 := mineOn(t, bc, genesis, "b1")
+	processBlock(t, bc, b1, BlockMainChain)
+
+	if _, err := bc.ProcessBlock(b1); err != ErrDuplicateBlock {
+		t.Errorf("Expected ErrDuplicateBlock, got %v", err)
+	}
+
+	tampered := mineOn(t, bc, b1, "b2")
+	tampered.Data = []byte("tampered")
+	if _, err := bc.ProcessBlock(tampered); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("Expected ErrInvalidBlock for tampered block, got %v", err)
+	}
+
+	wrongHeight := mineOn(t, bc, b1, "b2")
+	wrongHeight.Index = 5
+	wrongHeight.Nonce, wrongHeight.Hash = NewProofOfWork(wrongHeight).Run()
+	if _, err := bc.ProcessBlock(wrongHeight); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("Expected ErrInvalidBlock for wrong height, got %v", err)
+	}
+
+	// The timestamp must follow the median time of the previous blocks
+	medianTime, err := bc.MedianTimePast(b1.Hash)
+	if err != nil {
+		t.Fatalf("Failed to get the median time: %v", err)
+	}
+	tooOld := mineOn(t, bc, b1, "b2")
+	tooOld.Timestamp = medianTime
+	tooOld.Nonce, tooOld.Hash = NewProofOfWork(tooOld).Run()
+	if _, err := bc.ProcessBlock(tooOld); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("Expected ErrInvalidBlock for a timestamp at the median time, got %v", err)
+	}
+
+	// and not be too far ahead of the local clock
+	tooNew := mineOn(t, bc, b1, "b2")
+	tooNew.Timestamp = time.Now().Unix() + maxFutureBlockTime + 60
+	tooNew.Nonce, tooNew.Hash = NewProofOfWork(tooNew).Run()
+	if _, err := bc.ProcessBlock(tooNew); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("Expected ErrInvalidBlock for a timestamp in the future, got %v", err)
+	}
+
+	processBlock(t, bc, mineOn(t, bc, b1, "b2"), BlockMainChain)
+}
+
+func TestMedianTimePast(t *testing.T) {
+	// Out of order timestamps 100, 90, 120, 70, ... ending with the node
+	var node *blockNode
+	for i := int64(0); i < 15; i++ {
+		timestamp := 100 + i*10
+		if i%2 == 1 {
+			timestamp = 100 - i*10
+		}
+		node = &blockNode{parent: node, height: i, timestamp: timestamp}
+	}
+
+	// The last 11 timestamps, from height 4, sort to -30 ... 50, 140, 160 ... 240
+	if median := node.medianTimePast(); median != 140 {
+		t.Errorf("Expected a median time of 140, got %d", median)
+	}
+	if median := (&blockNode{timestamp: 42}).medianTimePast(); median != 42 {
+		t.Errorf("A single block should be its own median time, got %d", median)
+	}
+}
+
+func TestReorganizeRollsBackOnRejectedBlock(t *testing.T) {
+	bc := NewBlockchain()
+	observer := &recordingObserver{reject: "bad"}
+	bc.AddObserver(observer)
+
+	genesis, _ := bc.GetBlockByHeight(0)
+
+	a1 := mineOn(t, bc, genesis, "a1")
+	processBlock(t, bc, a1, BlockMainChain)
+
+	b1 := mineOn(t, bc, genesis, "bad")
+	processBlock(t, bc, b1, BlockSideChain)
+
+	b2 := mineOn(t, bc, b1, "b2")
+	if _, err := bc.ProcessBlock(b2); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("Expected ErrInvalidBlock, got %v", err)
+	}
+
+	if !bytes.Equal(bc.store.Tip(), a1.Hash) {
+		t.Error("Tip should be restored after a failed reorganization")
+	}
+
+	expected := []string{"+a1", "-a1", "+a1"}
+	if !equalEvents(observer.events, expected) {
+		t.Errorf("Unexpected observer events %v, expected %v", observer.events, expected)
+	}
+}
+
+func TestFileStoragePersistsReorganization(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	bc, err := NewBlockchainWithStorage(store)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+
+	genesis, _ := bc.GetBlockByHeight(0)
+	a1 := mineOn(t, bc, genesis, "a1")
+	processBlock(t, bc, a1, BlockMainChain)
+	b1 := mineOn(t, bc, genesis, "b1")
+	processBlock(t, bc, b1, BlockSideChain)
+	b2 := mineOn(t, bc, b1, "b2")
+	processBlock(t, bc, b2, BlockMainChain)
+	bc.Close()
+
+	store, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	bc, err = NewBlockchainWithStorage(store)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	defer bc.Close()
+
+	if !bytes.Equal(store.Tip(), b2.Hash) {
+		t.Error("Reopened chain should resume at the reorganized tip")
+	}
+
+	block, err := bc.GetBlockByHeight(1)
+	if err != nil || !bytes.Equal(block.Hash, b1.Hash) {
+		t.Error("Reopened height index should follow the main chain")
+	}
+
+	// The side branch is still known after reopening
+	if _, err := bc.GetBlock(a1.Hash); err != nil {
+		t.Errorf("Side branch block should be stored: %v", err)
+	}
+}
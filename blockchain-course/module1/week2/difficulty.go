@@ -0,0 +1,160 @@
+package week2
+
+import (
+	"math/big"
+
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week1"
+)
+
+// CompactToBig converts a compact difficulty representation to a target.
+// The compact form stores a 3-byte mantissa and a 1-byte exponent counting
+// the number of bytes of the target, like the nBits field of Bitcoin.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		target = big.NewInt(int64(mantissa))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+
+	if isNegative {
+		target.Neg(target)
+	}
+
+	return target
+}
+
+// BigToCompact converts a target to its compact representation
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(target.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tmp := new(big.Int).Rsh(target, 8*(exponent-3))
+		mantissa = uint32(tmp.Bits()[0])
+	}
+
+	// The sign bit is part of the mantissa, move to the next exponent if it is set
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
+
+// TargetBitsToCompact returns the compact form of a target with the given number of leading zero bits
+func TargetBitsToCompact(bits uint) uint32 {
+	target := big.NewInt(1)
+	target.Lsh(target, 256-bits)
+	return BigToCompact(target)
+}
+
+// Params holds the consensus parameters of a Blockchain
+type Params struct {
+	// InitialBits is the difficulty of the first blocks and the easiest difficulty allowed
+	InitialBits uint32
+	// RetargetInterval is the number of blocks between difficulty adjustments
+	RetargetInterval int64
+	// TargetBlockTime is the desired time between blocks in seconds
+	TargetBlockTime int64
+	// MaxAdjustment limits how much the difficulty can change in one retarget
+	MaxAdjustment int64
+	// Subsidy is the amount of new coins a coinbase may claim before the first halving
+	Subsidy int
+	// HalvingInterval is the number of blocks between subsidy halvings, 0 never halves
+	HalvingInterval int64
+	// CoinbaseMaturity is the number of blocks that must be mined on top of a
+	// coinbase before its outputs can be spent, 0 allows spending them right away
+	CoinbaseMaturity int64
+}
+
+// DefaultParams returns the parameters used by NewBlockchain
+func DefaultParams() Params {
+	return Params{
+		InitialBits:      defaultBits,
+		RetargetInterval: 10,
+		TargetBlockTime:  10,
+		MaxAdjustment:    4,
+		Subsidy:          transaction.Subsidy,
+		HalvingInterval:  210000,
+	}
+}
+
+// NextBits returns the difficulty required for the block following prev.
+// The difficulty only changes every RetargetInterval blocks, when the target is
+// scaled by the ratio between the actual and the expected time of the interval.
+// Timestamps are only bounded by the median time past and the local clock, so
+// the ratio is clamped by calculateNextBits.
+func (bc *Blockchain) NextBits(prev *week1.Block) (uint32, error) {
+	params := bc.params
+	height := prev.Index + 1
+
+	if prev.Bits == 0 {
+		// The genesis block is not mined and carries no difficulty
+		return params.InitialBits, nil
+	}
+	if params.RetargetInterval <= 0 || height%params.RetargetInterval != 0 {
+		return prev.Bits, nil
+	}
+
+	// Walk back along prev's own branch to the block starting the interval
+	first := prev
+	for first.Index > height-params.RetargetInterval {
+		parent, err := bc.store.GetBlock(first.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+		first = parent
+	}
+
+	actual := prev.Timestamp - first.Timestamp
+	expected := params.TargetBlockTime * params.RetargetInterval
+
+	return calculateNextBits(prev.Bits, actual, expected, params), nil
+}
+
+// calculateNextBits scales the target of bits by actual/expected, clamped to
+// MaxAdjustment in either direction and capped at the initial difficulty
+func calculateNextBits(bits uint32, actual, expected int64, params Params) uint32 {
+	if params.MaxAdjustment > 0 {
+		if actual < expected/params.MaxAdjustment {
+			actual = expected / params.MaxAdjustment
+		}
+		if actual > expected*params.MaxAdjustment {
+			actual = expected * params.MaxAdjustment
+		}
+	}
+	if actual <= 0 {
+		actual = 1
+	}
+
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+
+	powLimit := CompactToBig(params.InitialBits)
+	if target.Cmp(powLimit) > 0 {
+		target = powLimit
+	}
+
+	return BigToCompact(target)
+}
@@ -0,0 +1,72 @@
+package week2
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestNextBitsRetargets(t *testing.T) {
+	params := DefaultParams()
+	params.RetargetInterval = 3
+	params.TargetBlockTime = 60
+
+	bc, err := NewBlockchainWithParams(NewMemoryStorage(), params)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := bc.AddBlock("block"); err != nil {
+			t.Fatalf("Failed to add block: %v", err)
+		}
+	}
+
+	for h := int64(1); h < 3; h++ {
+		block, _ := bc.GetBlockByHeight(h)
+		if block.Bits != params.InitialBits {
+			t.Errorf("Block %d should use the initial difficulty", h)
+		}
+	}
+
+	// The blocks were mined far faster than 60 seconds each, so the retarget
+	// at height 3 must make the target harder by the maximum adjustment
+	block, _ := bc.GetBlockByHeight(3)
+	expected := new(big.Int).Div(CompactToBig(params.InitialBits), big.NewInt(params.MaxAdjustment))
+	if CompactToBig(block.Bits).Cmp(expected) != 0 {
+		t.Errorf("Unexpected retargeted bits %08x", block.Bits)
+	}
+
+	if !bc.IsValid() {
+		t.Error("Blockchain with retargeted difficulty should be valid")
+	}
+
+	// A block claiming the old difficulty after the retarget is rejected
+	block.Bits = params.InitialBits
+	pow := NewProofOfWork(block)
+	block.Nonce, block.Hash = pow.Run()
+
+	if bc.IsValid() {
+		t.Error("Blockchain should be invalid when a block ignores the retarget")
+	}
+}
+
+func TestCalculateNextBits(t *testing.T) {
+	params := DefaultParams()
+	harder := TargetBitsToCompact(12)
+
+	// On schedule keeps the difficulty
+	if bits := calculateNextBits(harder, 100, 100, params); bits != harder {
+		t.Errorf("On-time interval should keep the difficulty, got %08x", bits)
+	}
+
+	// Twice as slow doubles the target
+	expected := new(big.Int).Mul(CompactToBig(harder), big.NewInt(2))
+	if bits := calculateNextBits(harder, 200, 100, params); CompactToBig(bits).Cmp(expected) != 0 {
+		t.Errorf("Slow interval should double the target, got %08x", bits)
+	}
+
+	// Very slow intervals are clamped and never go below the initial difficulty
+	if bits := calculateNextBits(params.InitialBits, 100000, 100, params); bits != params.InitialBits {
+		t.Errorf("Target should be capped at the initial difficulty, got %08x", bits)
+	}
+}
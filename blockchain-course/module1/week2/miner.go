@@ -0,0 +1,140 @@
+package week2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"blockchain-course/module1/week1"
+)
+
+// cancelCheckInterval is the number of hashes a worker computes between checks for cancellation
+const cancelCheckInterval = 1024
+
+// ErrNonceSpaceExhausted is returned when no nonce satisfies the target
+var ErrNonceSpaceExhausted = errors.New("nonce space exhausted")
+
+// MiningStats reports the work done by a mining run
+type MiningStats struct {
+	Hashes   uint64
+	Duration time.Duration
+	Workers  int
+}
+
+// Hashrate returns the number of hashes computed per second
+func (s MiningStats) Hashrate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Hashes) / s.Duration.Seconds()
+}
+
+// miningResult is the solution found by a worker
+type miningResult struct {
+	nonce int64
+	hash  []byte
+}
+
+// Mine searches for a nonce satisfying the target. The nonce space is split
+// into one contiguous range per worker, a workers value below 1 uses every
+// CPU. Mining stops at the first solution or when ctx is done, in which case
+// the context error is returned along with the statistics of the aborted run.
+func (pow *ProofOfWork) Mine(ctx context.Context, workers int) (int, []byte, MiningStats, error) {
+	nonce, hash, stats, err := MineHeader(ctx, pow.prepareData(0), week1.HeaderLen-8, pow.target, workers)
+	return int(nonce), hash, stats, err
+}
+
+// MineHeader searches for a nonce such that the SHA-256 hash of header, with
+// the nonce written big-endian in the 8 bytes at nonceOffset, is below target.
+// Workers, cancellation and statistics behave as in Mine.
+func MineHeader(ctx context.Context, header []byte, nonceOffset int, target *big.Int, workers int) (int64, []byte, MiningStats, error) {
+	if nonceOffset < 0 || nonceOffset+8 > len(header) {
+		return 0, nil, MiningStats{}, fmt.Errorf("nonce offset %d is outside the %d byte header", nonceOffset, len(header))
+	}
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	span := int64(math.MaxInt64) / int64(workers)
+
+	var hashes atomic.Uint64
+	var found *miningResult
+	var once sync.Once
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		first := int64(i) * span
+		last := first + span
+		if i == workers-1 {
+			last = math.MaxInt64
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, count := search(ctx, header, nonceOffset, target, first, last)
+			hashes.Add(count)
+			if result != nil {
+				once.Do(func() {
+					found = result
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := MiningStats{
+		Hashes:   hashes.Load(),
+		Duration: time.Since(start),
+		Workers:  workers,
+	}
+
+	if found != nil {
+		return found.nonce, found.hash, stats, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, nil, stats, err
+	}
+	return 0, nil, stats, ErrNonceSpaceExhausted
+}
+
+// search tries the nonces in [first, last) on its own copy of the header and
+// returns the solution, if any, with the number of hashes computed
+func search(ctx context.Context, header []byte, nonceOffset int, target *big.Int, first, last int64) (*miningResult, uint64) {
+	data := make([]byte, len(header))
+	copy(data, header)
+	nonceField := data[nonceOffset : nonceOffset+8]
+
+	var hashInt big.Int
+	var count uint64
+
+	for nonce := first; nonce < last; nonce++ {
+		if count%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, count
+		}
+
+		binary.BigEndian.PutUint64(nonceField, uint64(nonce))
+		hash := sha256.Sum256(data)
+		count++
+
+		hashInt.SetBytes(hash[:])
+		if hashInt.Cmp(target) == -1 {
+			return &miningResult{nonce: nonce, hash: hash[:]}, count
+		}
+	}
+	return nil, count
+}
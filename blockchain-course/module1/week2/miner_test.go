@@ -0,0 +1,95 @@
+package week2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"blockchain-course/module1/week1"
+)
+
+func TestMineFindsValidNonce(t *testing.T) {
+	block := week1.NewBlock("Parallel", []byte{})
+	pow := NewProofOfWork(block)
+
+	nonce, hash, stats, err := pow.Mine(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("Mining failed: %v", err)
+	}
+	block.Nonce, block.Hash = nonce, hash
+
+	if !pow.Validate() {
+		t.Error("Mined nonce should satisfy the target")
+	}
+	if string(block.ComputeHash()) != string(hash) {
+		t.Error("Returned hash should be the hash of the header with the nonce")
+	}
+	if stats.Workers != 4 || stats.Hashes == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestMineCancelled(t *testing.T) {
+	block := week1.NewBlock("Too hard", []byte{})
+	block.Bits = TargetBitsToCompact(100)
+	pow := NewProofOfWork(block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, stats, err := pow.Mine(ctx, 2)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline error, got %v", err)
+	}
+	if stats.Hashes == 0 || stats.Hashrate() <= 0 {
+		t.Errorf("Aborted run should still report its work, got %+v", stats)
+	}
+}
+
+func TestMineBlockContextCancelled(t *testing.T) {
+	params := DefaultParams()
+	params.InitialBits = TargetBitsToCompact(100)
+	bc, err := NewBlockchainWithParams(NewMemoryStorage(), params)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tip, _ := bc.TipBlock()
+	if _, _, err := bc.MineBlockContext(ctx, tip, "Cancelled", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancellation error, got %v", err)
+	}
+	if bc.Height() != 0 {
+		t.Error("Cancelled mining should not add a block")
+	}
+}
+
+func TestMineHeader(t *testing.T) {
+	header := []byte("prefix--nonce---suffix")
+	target := new(big.Int).Lsh(big.NewInt(1), 248)
+
+	nonce, hash, _, err := MineHeader(context.Background(), header, 8, target, 2)
+	if err != nil {
+		t.Fatalf("Mining failed: %v", err)
+	}
+	data := append([]byte{}, header...)
+	binary.BigEndian.PutUint64(data[8:16], uint64(nonce))
+	expected := sha256.Sum256(data)
+	if !bytes.Equal(hash, expected[:]) {
+		t.Error("Returned hash should be the hash of the header with the nonce at the offset")
+	}
+	if new(big.Int).SetBytes(hash).Cmp(target) != -1 {
+		t.Errorf("Hash %x does not meet the target", hash)
+	}
+
+	if _, _, _, err := MineHeader(context.Background(), header, len(header)-7, target, 2); err == nil {
+		t.Error("Expected an error for a nonce offset past the end of the header")
+	}
+}
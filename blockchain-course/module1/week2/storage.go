@@ -0,0 +1,492 @@
+package week2
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"blockchain-course/module1/week1"
+)
+
+const blocksFile = "blocks.dat"
+
+// recordHeaderLen is the size of the length and checksum prefix of each record
+const recordHeaderLen = 8
+
+// Record types of the block file
+const (
+	recordBlock = byte(1)
+	recordTip   = byte(2)
+)
+
+var (
+	// ErrBlockNotFound is returned when a block is not present in the storage
+	ErrBlockNotFound = errors.New("block not found")
+	// ErrUnknownParent is returned when a block is stored before its parent
+	ErrUnknownParent = errors.New("parent block is not stored")
+	// errTornRecord is returned for a record cut short or garbled by an
+	// interrupted write at the end of the block file
+	errTornRecord = errors.New("torn record")
+)
+
+// Storage is a persistence backend for blocks. It keeps every block it is
+// given, including blocks on side branches, and tracks which block is the tip
+// of the main chain. Heights refer to the main chain.
+type Storage interface {
+	// PutBlock stores a block whose parent is already stored
+	PutBlock(block *week1.Block) error
+	// SetTip makes the stored block with the given hash the tip of the main chain
+	SetTip(hash []byte) error
+	// GetBlock returns the block with the given hash
+	GetBlock(hash []byte) (*week1.Block, error)
+	// GetBlockByHeight returns the main chain block at the given height
+	GetBlockByHeight(height int64) (*week1.Block, error)
+	// ForEach calls fn for every stored block, parents before children
+	ForEach(fn func(block *week1.Block) error) error
+	// Tip returns the hash of the main chain tip, or nil if the storage is empty
+	Tip() []byte
+	// Height returns the height of the main chain tip, or -1 if the storage is empty
+	Height() int64
+	// Close releases the resources held by the storage
+	Close() error
+}
+
+// storedBlock is the index entry of a stored block
+type storedBlock struct {
+	prevHash string
+	height   int64
+	offset   int64
+	block    *week1.Block
+}
+
+// blockIndex is the hash index and main chain height index shared by the storages
+type blockIndex struct {
+	entries map[string]*storedBlock
+	order   []string
+	main    []string
+	tipHash []byte
+}
+
+func newBlockIndex() blockIndex {
+	return blockIndex{entries: make(map[string]*storedBlock)}
+}
+
+func (idx *blockIndex) has(hash []byte) bool {
+	_, ok := idx.entries[hex.EncodeToString(hash)]
+	return ok
+}
+
+// add indexes a block, checking that its parent is known
+func (idx *blockIndex) add(block *week1.Block, entry *storedBlock) error {
+	if len(block.PrevBlockHash) != 0 {
+		parent, ok := idx.entries[hex.EncodeToString(block.PrevBlockHash)]
+		if !ok {
+			return ErrUnknownParent
+		}
+		entry.height = parent.height + 1
+	}
+
+	hash := hex.EncodeToString(block.Hash)
+	entry.prevHash = hex.EncodeToString(block.PrevBlockHash)
+	idx.entries[hash] = entry
+	idx.order = append(idx.order, hash)
+	return nil
+}
+
+// setTip rebuilds the main chain height index up to the block with the given hash
+func (idx *blockIndex) setTip(hash []byte) error {
+	key := hex.EncodeToString(hash)
+	entry, ok := idx.entries[key]
+	if !ok {
+		return ErrBlockNotFound
+	}
+
+	// Walk back until the branch joins the current main chain
+	var branch []string
+	keep := int64(0)
+	for {
+		if entry.height < int64(len(idx.main)) && idx.main[entry.height] == key {
+			keep = entry.height + 1
+			break
+		}
+		branch = append(branch, key)
+		if entry.height == 0 {
+			break
+		}
+		key = entry.prevHash
+		entry = idx.entries[key]
+	}
+
+	idx.main = idx.main[:keep]
+	for i := len(branch) - 1; i >= 0; i-- {
+		idx.main = append(idx.main, branch[i])
+	}
+	idx.tipHash = hash
+	return nil
+}
+
+// MemoryStorage keeps blocks in memory only
+type MemoryStorage struct {
+	mu  sync.RWMutex
+	idx blockIndex
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{idx: newBlockIndex()}
+}
+
+// PutBlock stores a block whose parent is already stored
+func (ms *MemoryStorage) PutBlock(block *week1.Block) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.idx.has(block.Hash) {
+		return nil
+	}
+	return ms.idx.add(block, &storedBlock{block: block})
+}
+
+// SetTip makes the stored block with the given hash the tip of the main chain
+func (ms *MemoryStorage) SetTip(hash []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.idx.setTip(hash)
+}
+
+// GetBlock returns the block with the given hash
+func (ms *MemoryStorage) GetBlock(hash []byte) (*week1.Block, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	entry, ok := ms.idx.entries[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return entry.block, nil
+}
+
+// GetBlockByHeight returns the main chain block at the given height
+func (ms *MemoryStorage) GetBlockByHeight(height int64) (*week1.Block, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if height < 0 || height >= int64(len(ms.idx.main)) {
+		return nil, ErrBlockNotFound
+	}
+	return ms.idx.entries[ms.idx.main[height]].block, nil
+}
+
+// ForEach calls fn for every stored block in insertion order
+func (ms *MemoryStorage) ForEach(fn func(block *week1.Block) error) error {
+	ms.mu.RLock()
+	var blocks []*week1.Block
+	for _, hash := range ms.idx.order {
+		blocks = append(blocks, ms.idx.entries[hash].block)
+	}
+	ms.mu.RUnlock()
+
+	for _, block := range blocks {
+		if err := fn(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tip returns the hash of the main chain tip
+func (ms *MemoryStorage) Tip() []byte {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.idx.tipHash
+}
+
+// Height returns the height of the main chain tip
+func (ms *MemoryStorage) Height() int64 {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return int64(len(ms.idx.main)) - 1
+}
+
+// Close is a no-op for the in-memory storage
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
+
+// FileStorage is an append-only log of blocks and tip changes with an
+// in-memory hash and height index. Every record is prefixed with its length
+// and a CRC32 checksum so that a torn write at the end of the file is detected
+// and discarded on open, while damage before the last record fails the open.
+type FileStorage struct {
+	mu   sync.RWMutex
+	file *os.File
+	size int64
+	idx  blockIndex
+}
+
+// OpenFileStorage opens (or creates) the block file inside dir and rebuilds its index
+func OpenFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, blocksFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStorage{
+		file: file,
+		idx:  newBlockIndex(),
+	}
+	if err := fs.loadIndex(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// loadIndex replays the block file and truncates a torn last record. Any
+// other damaged record is reported as an error.
+func (fs *FileStorage) loadIndex() error {
+	info, err := fs.file.Stat()
+	if err != nil {
+		return err
+	}
+	fs.size = info.Size()
+
+	var offset int64
+	for {
+		payload, err := fs.readRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errTornRecord) {
+			// Drop the torn tail, everything before it is intact
+			if err := fs.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt block file at offset %d: %w", offset, err)
+		}
+
+		if err := fs.replay(payload, offset); err != nil {
+			return fmt.Errorf("corrupt block file at offset %d: %s", offset, err)
+		}
+		offset += recordHeaderLen + int64(len(payload))
+	}
+
+	fs.size = offset
+	return nil
+}
+
+// replay applies a record read from the block file to the index
+func (fs *FileStorage) replay(payload []byte, offset int64) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("empty record")
+	}
+
+	switch payload[0] {
+	case recordBlock:
+		block, err := week1.DeserializeBlock(payload[1:])
+		if err != nil {
+			return err
+		}
+		return fs.idx.add(block, &storedBlock{offset: offset})
+	case recordTip:
+		return fs.idx.setTip(payload[1:])
+	default:
+		return fmt.Errorf("unknown record type %d", payload[0])
+	}
+}
+
+// readRecord reads and checks the payload of the record at offset. Records
+// running past the end of the file, or up to it with a checksum mismatch,
+// are reported as errTornRecord.
+func (fs *FileStorage) readRecord(offset int64) ([]byte, error) {
+	if offset >= fs.size {
+		return nil, io.EOF
+	}
+	if fs.size-offset < recordHeaderLen {
+		return nil, fmt.Errorf("%w: short record header", errTornRecord)
+	}
+
+	header := make([]byte, recordHeaderLen)
+	if _, err := fs.file.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	sum := binary.BigEndian.Uint32(header[4:])
+
+	end := offset + recordHeaderLen + int64(length)
+	if end > fs.size {
+		return nil, fmt.Errorf("%w: record of %d bytes runs past the end of the file", errTornRecord, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := fs.file.ReadAt(payload, offset+recordHeaderLen); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		if end == fs.size {
+			return nil, fmt.Errorf("%w: record checksum mismatch", errTornRecord)
+		}
+		return nil, fmt.Errorf("record checksum mismatch")
+	}
+
+	return payload, nil
+}
+
+// appendRecord writes a record at the end of the file and syncs it to disk
+func (fs *FileStorage) appendRecord(kind byte, data []byte) (int64, error) {
+	payload := append([]byte{kind}, data...)
+
+	record := make([]byte, recordHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderLen:], payload)
+
+	offset := fs.size
+	if _, err := fs.file.WriteAt(record, offset); err != nil {
+		return 0, err
+	}
+	if err := fs.file.Sync(); err != nil {
+		return 0, err
+	}
+
+	fs.size += int64(len(record))
+	return offset, nil
+}
+
+// readBlock reads the block stored at offset
+func (fs *FileStorage) readBlock(offset int64) (*week1.Block, error) {
+	payload, err := fs.readRecord(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 || payload[0] != recordBlock {
+		return nil, fmt.Errorf("no block record at offset %d", offset)
+	}
+	return week1.DeserializeBlock(payload[1:])
+}
+
+// PutBlock stores a block whose parent is already stored
+func (fs *FileStorage) PutBlock(block *week1.Block) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.idx.has(block.Hash) {
+		return nil
+	}
+	if len(block.PrevBlockHash) != 0 && !fs.idx.has(block.PrevBlockHash) {
+		return ErrUnknownParent
+	}
+
+	offset, err := fs.appendRecord(recordBlock, block.Serialize())
+	if err != nil {
+		return err
+	}
+
+	return fs.idx.add(block, &storedBlock{offset: offset})
+}
+
+// SetTip makes the stored block with the given hash the tip of the main chain
+func (fs *FileStorage) SetTip(hash []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if !fs.idx.has(hash) {
+		return ErrBlockNotFound
+	}
+	if _, err := fs.appendRecord(recordTip, hash); err != nil {
+		return err
+	}
+
+	return fs.idx.setTip(hash)
+}
+
+// GetBlock returns the block with the given hash
+func (fs *FileStorage) GetBlock(hash []byte) (*week1.Block, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	entry, ok := fs.idx.entries[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+
+	return fs.readBlock(entry.offset)
+}
+
+// GetBlockByHeight returns the main chain block at the given height
+func (fs *FileStorage) GetBlockByHeight(height int64) (*week1.Block, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if height < 0 || height >= int64(len(fs.idx.main)) {
+		return nil, ErrBlockNotFound
+	}
+
+	return fs.readBlock(fs.idx.entries[fs.idx.main[height]].offset)
+}
+
+// ForEach calls fn for every stored block in the order they were written
+func (fs *FileStorage) ForEach(fn func(block *week1.Block) error) error {
+	fs.mu.RLock()
+	var offsets []int64
+	for _, hash := range fs.idx.order {
+		offsets = append(offsets, fs.idx.entries[hash].offset)
+	}
+	fs.mu.RUnlock()
+
+	for _, offset := range offsets {
+		fs.mu.RLock()
+		block, err := fs.readBlock(offset)
+		fs.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		if err := fn(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tip returns the hash of the main chain tip
+func (fs *FileStorage) Tip() []byte {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	return fs.idx.tipHash
+}
+
+// Height returns the height of the main chain tip
+func (fs *FileStorage) Height() int64 {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	return int64(len(fs.idx.main)) - 1
+}
+
+// Close closes the underlying block file
+func (fs *FileStorage) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.file.Close()
+}
@@ -0,0 +1,181 @@
+package week2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"blockchain-course/module1/week1"
+)
+
+func TestFileStorageReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+
+	bc, err := NewBlockchainWithStorage(store)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	bc.AddBlock("Block 1")
+	bc.AddBlock("Block 2")
+	tip := store.Tip()
+
+	if err := bc.Close(); err != nil {
+		t.Fatalf("Failed to close blockchain: %v", err)
+	}
+
+	// Reopen the same data directory and resume at the tip
+	store, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	bc, err = NewBlockchainWithStorage(store)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	defer bc.Close()
+
+	if bc.Height() != 2 {
+		t.Errorf("Expected height 2 after reopening, got %d", bc.Height())
+	}
+
+	if string(store.Tip()) != string(tip) {
+		t.Error("Tip should be preserved across reopening")
+	}
+
+	if !bc.IsValid() {
+		t.Error("Reopened blockchain should be valid")
+	}
+
+	if err := bc.AddBlock("Block 3"); err != nil {
+		t.Fatalf("Failed to add block after reopening: %v", err)
+	}
+
+	block, err := bc.GetBlockByHeight(3)
+	if err != nil {
+		t.Fatalf("Failed to read block: %v", err)
+	}
+	if string(block.Data) != "Block 3" {
+		t.Error("Block data is incorrect")
+	}
+}
+
+func TestFileStorageTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	bc, err := NewBlockchainWithStorage(store)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	bc.AddBlock("Block 1")
+	bc.Close()
+
+	// Simulate a torn write at the end of the file
+	path := filepath.Join(dir, blocksFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open block file: %v", err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2})
+	f.Close()
+
+	store, err = OpenFileStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+
+	if store.Height() != 1 {
+		t.Errorf("Expected height 1 after recovery, got %d", store.Height())
+	}
+}
+
+func TestFileStorageDamagedRecords(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenFileStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	bc, err := NewBlockchainWithStorage(store)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	bc.AddBlock("Block 1")
+	bc.Close()
+
+	path := filepath.Join(dir, blocksFile)
+	intact, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read block file: %v", err)
+	}
+
+	// A torn tail claiming a huge length or ending in a bad checksum is dropped
+	for _, tail := range [][]byte{
+		{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 1},
+		{0, 0, 0, 2, 0, 0, 0, 0, recordTip, 1},
+	} {
+		if err := os.WriteFile(path, append(append([]byte{}, intact...), tail...), 0644); err != nil {
+			t.Fatalf("Failed to write block file: %v", err)
+		}
+		store, err := OpenFileStorage(dir)
+		if err != nil {
+			t.Fatalf("Failed to recover from torn tail %x: %v", tail, err)
+		}
+		if store.Height() != 1 {
+			t.Errorf("Expected height 1 after recovery, got %d", store.Height())
+		}
+		store.Close()
+
+		if info, err := os.Stat(path); err != nil || info.Size() != int64(len(intact)) {
+			t.Errorf("Torn tail %x should be truncated", tail)
+		}
+	}
+
+	// A damaged record before the last one fails the open instead of dropping blocks
+	damaged := append([]byte{}, intact...)
+	damaged[recordHeaderLen+1] ^= 0xff
+	if err := os.WriteFile(path, damaged, 0644); err != nil {
+		t.Fatalf("Failed to write block file: %v", err)
+	}
+	if store, err := OpenFileStorage(dir); err == nil {
+		store.Close()
+		t.Error("Expected an error for a checksum mismatch before the last record")
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(len(damaged)) {
+		t.Error("Damaged block file should not be truncated")
+	}
+}
+
+func TestStorageRejectsUnlinkedBlock(t *testing.T) {
+	store := NewMemoryStorage()
+
+	block := week1.NewBlock("Orphan", []byte("unknown parent"))
+	if err := store.PutBlock(block); err != ErrUnknownParent {
+		t.Errorf("Expected ErrUnknownParent, got %v", err)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	bc := NewBlockchain()
+	bc.AddBlock("Block 1")
+	bc.AddBlock("Block 2")
+
+	count := 0
+	bci := bc.Iterator()
+	for block := bci.Next(); block != nil; block = bci.Next() {
+		count++
+	}
+
+	if count != 3 {
+		t.Errorf("Expected iterator to visit 3 blocks, got %d", count)
+	}
+}
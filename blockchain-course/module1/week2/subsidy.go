@@ -0,0 +1,29 @@
+package week2
+
+// maxHalvings is the number of halvings after which the subsidy is always zero
+const maxHalvings = 63
+
+// BlockSubsidy returns the amount of new coins the coinbase of the block at
+// height may claim. The subsidy halves every HalvingInterval blocks.
+func (p Params) BlockSubsidy(height int64) int {
+	if p.HalvingInterval <= 0 {
+		return p.Subsidy
+	}
+
+	halvings := height / p.HalvingInterval
+	if halvings >= maxHalvings {
+		return 0
+	}
+	return p.Subsidy >> uint(halvings)
+}
+
+// Params returns the consensus parameters of the blockchain
+func (bc *Blockchain) Params() Params {
+	return bc.params
+}
+
+// IsMature reports whether an output created at height can be spent in a
+// block at spendHeight. Only coinbase outputs need to mature.
+func (p Params) IsMature(coinbase bool, height, spendHeight int64) bool {
+	return !coinbase || spendHeight-height >= p.CoinbaseMaturity
+}
@@ -0,0 +1,36 @@
+package week2
+
+import "testing"
+
+func TestBlockSubsidyHalves(t *testing.T) {
+	params := DefaultParams()
+	params.Subsidy = 50
+	params.HalvingInterval = 100
+
+	cases := map[int64]int{0: 50, 99: 50, 100: 25, 250: 12, 600: 0, 100 * maxHalvings: 0}
+	for height, expected := range cases {
+		if subsidy := params.BlockSubsidy(height); subsidy != expected {
+			t.Errorf("Subsidy at height %d should be %d, got %d", height, expected, subsidy)
+		}
+	}
+
+	params.HalvingInterval = 0
+	if params.BlockSubsidy(1000000) != 50 {
+		t.Error("Subsidy should never halve without a halving interval")
+	}
+}
+
+func TestIsMature(t *testing.T) {
+	params := DefaultParams()
+	params.CoinbaseMaturity = 100
+
+	if params.IsMature(true, 10, 109) {
+		t.Error("Coinbase output should not be spendable before maturity")
+	}
+	if !params.IsMature(true, 10, 110) {
+		t.Error("Coinbase output should be spendable after CoinbaseMaturity blocks")
+	}
+	if !params.IsMature(false, 10, 10) {
+		t.Error("Regular outputs do not need to mature")
+	}
+}
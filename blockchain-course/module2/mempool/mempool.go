@@ -0,0 +1,411 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week1"
+	week2 "blockchain-course/module1/week2"
+	"blockchain-course/module2/week3"
+)
+
+var (
+	// ErrAlreadyKnown is returned when adding a transaction that is already in the pool
+	ErrAlreadyKnown = errors.New("transaction already in the mempool")
+	// ErrConflict is returned when a transaction spends an output already spent by a pooled transaction
+	ErrConflict = errors.New("transaction conflicts with the mempool")
+	// ErrPoolFull is returned when the pool is full of transactions paying a higher fee rate
+	ErrPoolFull = errors.New("mempool full")
+	// ErrTooLarge is returned when a transaction alone exceeds the size of the pool
+	ErrTooLarge = errors.New("transaction larger than the mempool")
+)
+
+// UTXOView gives access to the unspent outputs of the main chain, such as a week3.UTXOSet
+type UTXOView interface {
+	Get(txID []byte, index int) (week3.UnspentOutput, bool)
+	// Height returns the height of the tip the outputs belong to
+	Height() int64
+	Params() week2.Params
+	// SigCache returns the cache of verified signatures shared with block
+	// validation, so that pooled transactions are not verified again when mined
+	SigCache() *week3.SigCache
+}
+
+// Config holds the limits of a Mempool
+type Config struct {
+	// MaxSize is the total serialized size of the pooled transactions in bytes
+	MaxSize int
+	// Expiry is how long a transaction may stay in the pool, 0 keeps them forever
+	Expiry time.Duration
+}
+
+// DefaultConfig returns the limits used when none are given
+func DefaultConfig() Config {
+	return Config{
+		MaxSize: 1 << 20,
+		Expiry:  24 * time.Hour,
+	}
+}
+
+// Entry is a pooled transaction
+type Entry struct {
+	Tx    *transaction.Transaction
+	Fee   int
+	Size  int
+	Added time.Time
+}
+
+// FeeRate returns the fee paid per byte
+func (e *Entry) FeeRate() float64 {
+	return float64(e.Fee) / float64(e.Size)
+}
+
+// higherFeeRate reports whether a pays a higher fee rate than b, ties broken by age
+func higherFeeRate(a, b *Entry) bool {
+	// Compare a.Fee/a.Size with b.Fee/b.Size without rounding
+	left, right := a.Fee*b.Size, b.Fee*a.Size
+	if left != right {
+		return left > right
+	}
+	return a.Added.Before(b.Added)
+}
+
+// Mempool holds validated transactions waiting to be mined. Transactions may
+// spend the outputs of other pooled transactions. A Mempool is a
+// week2.ChainObserver: registered after the UTXO set, it drops mined and
+// conflicting transactions and takes back the transactions of disconnected blocks.
+type Mempool struct {
+	mu     sync.Mutex
+	utxo   UTXOView
+	config Config
+
+	entries map[string]*Entry
+	// spends maps every outpoint spent by a pooled transaction to its spender
+	spends map[string]string
+	size   int
+}
+
+// New creates an empty Mempool validating transactions against utxo
+func New(utxo UTXOView, config Config) *Mempool {
+	return &Mempool{
+		utxo:    utxo,
+		config:  config,
+		entries: make(map[string]*Entry),
+		spends:  make(map[string]string),
+	}
+}
+
+// outpointKey identifies a transaction output
+func outpointKey(txID []byte, index int) string {
+	return fmt.Sprintf("%x:%d", txID, index)
+}
+
+// Add validates a transaction and adds it to the pool. When the pool is full,
+// transactions paying a lower fee rate are evicted to make room.
+func (mp *Mempool) Add(tx *transaction.Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.add(tx, time.Now())
+}
+
+func (mp *Mempool) add(tx *transaction.Transaction, now time.Time) error {
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := mp.entries[txID]; ok {
+		return ErrAlreadyKnown
+	}
+
+	for _, in := range tx.Vin {
+		if spender, ok := mp.spends[outpointKey(in.Txid, in.Vout)]; ok {
+			return fmt.Errorf("%w: output %x:%d is spent by %s", ErrConflict, in.Txid, in.Vout, spender)
+		}
+	}
+
+	// Pooled transactions must be valid in the next block
+	ctx := week3.SpendContext{
+		Height:    mp.utxo.Height() + 1,
+		Timestamp: now.Unix(),
+		Params:    mp.utxo.Params(),
+		SigCache:  mp.utxo.SigCache(),
+	}
+	fee, err := week3.ValidateTransaction(tx, mp.lookup, ctx)
+	if err != nil {
+		return err
+	}
+
+	entry := &Entry{Tx: tx, Fee: fee, Size: len(tx.Serialize()), Added: now}
+	if entry.Size > mp.config.MaxSize {
+		return ErrTooLarge
+	}
+	if err := mp.makeRoom(entry); err != nil {
+		return err
+	}
+
+	mp.entries[txID] = entry
+	for _, in := range tx.Vin {
+		mp.spends[outpointKey(in.Txid, in.Vout)] = txID
+	}
+	mp.size += entry.Size
+	return nil
+}
+
+// lookup finds an output in the pooled transactions, then in the UTXO set.
+// Pooled outputs are seen as created in the next block.
+func (mp *Mempool) lookup(txID []byte, index int) (week3.UnspentOutput, bool) {
+	if entry, ok := mp.entries[hex.EncodeToString(txID)]; ok {
+		if index < 0 || index >= len(entry.Tx.Vout) {
+			return week3.UnspentOutput{}, false
+		}
+		return week3.UnspentOutput{
+			TxID:   txID,
+			Index:  index,
+			Output: entry.Tx.Vout[index],
+			Height: mp.utxo.Height() + 1,
+		}, true
+	}
+	return mp.utxo.Get(txID, index)
+}
+
+// makeRoom evicts the transactions with the lowest fee rate, along with the
+// transactions spending their outputs, until entry fits in the pool
+func (mp *Mempool) makeRoom(entry *Entry) error {
+	if mp.size+entry.Size <= mp.config.MaxSize {
+		return nil
+	}
+
+	// Evict from the lowest fee rate up, as long as the new transaction pays more
+	sorted := mp.sorted()
+	freed := 0
+	var evict []string
+	for i := len(sorted) - 1; i >= 0 && mp.size-freed+entry.Size > mp.config.MaxSize; i-- {
+		victim := sorted[i]
+		if !higherFeeRate(entry, victim) {
+			return ErrPoolFull
+		}
+		for _, id := range mp.withDescendants(hex.EncodeToString(victim.Tx.ID)) {
+			if !contains(evict, id) {
+				evict = append(evict, id)
+				freed += mp.entries[id].Size
+			}
+		}
+	}
+
+	// The new transaction cannot replace the transactions it spends
+	for _, in := range entry.Tx.Vin {
+		if contains(evict, hex.EncodeToString(in.Txid)) {
+			return ErrPoolFull
+		}
+	}
+
+	for _, id := range evict {
+		mp.remove(id)
+	}
+	return nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// withDescendants returns txID and the pooled transactions spending its outputs, recursively
+func (mp *Mempool) withDescendants(txID string) []string {
+	ids := []string{txID}
+	for i := 0; i < len(ids); i++ {
+		entry, ok := mp.entries[ids[i]]
+		if !ok {
+			continue
+		}
+		for index := range entry.Tx.Vout {
+			if spender, ok := mp.spends[outpointKey(entry.Tx.ID, index)]; ok && !contains(ids, spender) {
+				ids = append(ids, spender)
+			}
+		}
+	}
+	return ids
+}
+
+// remove drops a single transaction from the pool
+func (mp *Mempool) remove(txID string) {
+	entry, ok := mp.entries[txID]
+	if !ok {
+		return
+	}
+
+	for _, in := range entry.Tx.Vin {
+		delete(mp.spends, outpointKey(in.Txid, in.Vout))
+	}
+	delete(mp.entries, txID)
+	mp.size -= entry.Size
+}
+
+// Remove drops a transaction and every pooled transaction depending on it
+func (mp *Mempool) Remove(txID []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, id := range mp.withDescendants(hex.EncodeToString(txID)) {
+		mp.remove(id)
+	}
+}
+
+// Get returns the pooled entry of a transaction, or nil
+func (mp *Mempool) Get(txID []byte) *Entry {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.entries[hex.EncodeToString(txID)]
+}
+
+// Count returns the number of pooled transactions
+func (mp *Mempool) Count() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return len(mp.entries)
+}
+
+// Size returns the total serialized size of the pooled transactions
+func (mp *Mempool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.size
+}
+
+// Expire drops the transactions added more than Expiry before now, with their
+// descendants, and returns the number of transactions dropped
+func (mp *Mempool) Expire(now time.Time) int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.config.Expiry <= 0 {
+		return 0
+	}
+
+	dropped := 0
+	for txID, entry := range mp.entries {
+		if now.Sub(entry.Added) <= mp.config.Expiry {
+			continue
+		}
+		for _, id := range mp.withDescendants(txID) {
+			if _, ok := mp.entries[id]; ok {
+				mp.remove(id)
+				dropped++
+			}
+		}
+	}
+	return dropped
+}
+
+// sorted returns the entries by decreasing fee rate
+func (mp *Mempool) sorted() []*Entry {
+	entries := make([]*Entry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return higherFeeRate(entries[i], entries[j])
+	})
+	return entries
+}
+
+// BlockTemplate selects the transactions to mine next, by decreasing fee rate,
+// within maxSize bytes, and returns them with the total fees they pay. A
+// transaction is only selected after the pooled transactions it spends, so
+// the result can be mined in order.
+func (mp *Mempool) BlockTemplate(maxSize int) ([]*transaction.Transaction, int) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var selected []*transaction.Transaction
+	included := make(map[string]bool)
+	size := 0
+	fees := 0
+
+	pending := mp.sorted()
+	for progress := true; progress; {
+		progress = false
+		var skipped []*Entry
+
+		for _, entry := range pending {
+			if size+entry.Size > maxSize {
+				continue
+			}
+			if !mp.parentsIncluded(entry, included) {
+				skipped = append(skipped, entry)
+				continue
+			}
+
+			selected = append(selected, entry.Tx)
+			included[hex.EncodeToString(entry.Tx.ID)] = true
+			size += entry.Size
+			fees += entry.Fee
+			progress = true
+		}
+		pending = skipped
+	}
+
+	return selected, fees
+}
+
+// parentsIncluded reports whether every pooled transaction spent by entry is included
+func (mp *Mempool) parentsIncluded(entry *Entry, included map[string]bool) bool {
+	for _, in := range entry.Tx.Vin {
+		parent := hex.EncodeToString(in.Txid)
+		if _, pooled := mp.entries[parent]; pooled && !included[parent] {
+			return false
+		}
+	}
+	return true
+}
+
+// ConnectBlock drops the transactions of the block and the pooled
+// transactions conflicting with them
+func (mp *Mempool) ConnectBlock(block *week1.Block) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		mp.remove(hex.EncodeToString(tx.ID))
+	}
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Vin {
+			if spender, ok := mp.spends[outpointKey(in.Txid, in.Vout)]; ok {
+				for _, id := range mp.withDescendants(spender) {
+					mp.remove(id)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// DisconnectBlock returns the transactions of the block to the pool. Those
+// that are no longer valid are dropped.
+func (mp *Mempool) DisconnectBlock(block *week1.Block) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	now := time.Now()
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		mp.add(tx, now)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week1"
+	"blockchain-course/module1/week2"
+	"blockchain-course/module2/week3"
+)
+
+// fixture is a UTXO set holding a coinbase with outputs of 10 owned by a wallet
+type fixture struct {
+	wallet     *week3.Wallet
+	pubKeyHash []byte
+	utxo       *week3.UTXOSet
+	funding    *transaction.Transaction
+}
+
+func newFixture(t *testing.T, outputs int) *fixture {
+	t.Helper()
+
+	// The funding coinbase claims more than the default subsidy
+	params := week2.DefaultParams()
+	params.Subsidy = 10 * outputs
+	wallet, err := week3.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	f := &fixture{wallet: wallet, utxo: week3.NewUTXOSetWithParams(params)}
+	f.pubKeyHash = week3.HashPubKey(f.wallet.PublicKey)
+
+	f.funding = &transaction.Transaction{Vin: []transaction.TXInput{{Vout: -1, PubKey: []byte("funding")}}}
+	for i := 0; i < outputs; i++ {
+		f.funding.Vout = append(f.funding.Vout, transaction.TXOutput{Value: 10, PubKeyHash: f.pubKeyHash})
+	}
+	f.funding.ID = f.funding.Hash()
+
+	block := week1.NewBlockWithTransactions("funding", nil, []*transaction.Transaction{f.funding})
+	block.SetHash()
+	if err := f.utxo.ConnectBlock(block); err != nil {
+		t.Fatalf("Failed to connect funding block: %v", err)
+	}
+	return f
+}
+
+// spend signs a transaction moving output index of prev back to the wallet, keeping value
+func (f *fixture) spend(prev *transaction.Transaction, index, value int) *transaction.Transaction {
+	tx := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Txid: prev.ID, Vout: index, PubKey: f.wallet.PublicKey}},
+		Vout: []transaction.TXOutput{{Value: value, PubKeyHash: f.pubKeyHash}},
+	}
+	prevTXs := map[string]transaction.Transaction{hex.EncodeToString(prev.ID): *prev}
+	week3.SignTransaction(tx, f.wallet.PrivateKey, prevTXs)
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func TestAddValidatesAndDetectsConflicts(t *testing.T) {
+	f := newFixture(t, 1)
+	mp := New(f.utxo, DefaultConfig())
+
+	pay := f.spend(f.funding, 0, 9)
+	if err := mp.Add(pay); err != nil {
+		t.Fatalf("Valid transaction rejected: %v", err)
+	}
+	if entry := mp.Get(pay.ID); entry == nil || entry.Fee != 1 {
+		t.Errorf("Expected a pooled entry paying a fee of 1, got %+v", entry)
+	}
+	// Its signature is not verified again when it is mined
+	if f.utxo.SigCache().Len() != 1 {
+		t.Errorf("Expected the signature to be cached, got %d cached", f.utxo.SigCache().Len())
+	}
+
+	if err := mp.Add(pay); !errors.Is(err, ErrAlreadyKnown) {
+		t.Errorf("Expected ErrAlreadyKnown, got %v", err)
+	}
+	if err := mp.Add(f.spend(f.funding, 0, 8)); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+
+	var validationErr *week3.ValidationError
+	unknown := &transaction.Transaction{ID: []byte("never mined"), Vout: f.funding.Vout}
+	if err := mp.Add(f.spend(unknown, 0, 10)); !errors.As(err, &validationErr) || validationErr.Rule != week3.RuleMissingOutput {
+		t.Errorf("Expected a missing output, got %v", err)
+	}
+
+	// Transactions can spend the outputs of pooled transactions
+	child := f.spend(pay, 0, 5)
+	if err := mp.Add(child); err != nil {
+		t.Errorf("Child of a pooled transaction rejected: %v", err)
+	}
+
+	mp.Remove(pay.ID)
+	if mp.Count() != 0 || mp.Size() != 0 {
+		t.Errorf("Removing a transaction should remove its descendants, %d left", mp.Count())
+	}
+}
+
+func TestBlockTemplateOrdersByFeeRate(t *testing.T) {
+	f := newFixture(t, 3)
+	mp := New(f.utxo, DefaultConfig())
+
+	low := f.spend(f.funding, 0, 9)
+	high := f.spend(f.funding, 1, 5)
+	mid := f.spend(f.funding, 2, 7)
+	// The child pays the highest fee but must follow its parent
+	child := f.spend(low, 0, 1)
+	for _, tx := range []*transaction.Transaction{low, high, mid, child} {
+		if err := mp.Add(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+
+	template, fees := mp.BlockTemplate(1 << 20)
+	if fees != 1+5+3+8 {
+		t.Errorf("Expected fees of 17, got %d", fees)
+	}
+	expected := []*transaction.Transaction{high, mid, low, child}
+	if len(template) != len(expected) {
+		t.Fatalf("Expected %d transactions, got %d", len(expected), len(template))
+	}
+	for i := range expected {
+		if string(template[i].ID) != string(expected[i].ID) {
+			t.Errorf("Unexpected transaction at position %d", i)
+		}
+	}
+
+	// A smaller template keeps the best paying transactions
+	size := mp.Get(high.ID).Size
+	if template, _ := mp.BlockTemplate(2 * size); len(template) != 2 || string(template[0].ID) != string(high.ID) {
+		t.Errorf("Expected the two best transactions, got %d", len(template))
+	}
+}
+
+func TestEvictsLowestFeeRate(t *testing.T) {
+	f := newFixture(t, 4)
+	low := f.spend(f.funding, 0, 9)
+	mid := f.spend(f.funding, 1, 8)
+	high := f.spend(f.funding, 2, 5)
+	lowest := f.spend(f.funding, 3, 10)
+
+	size := len(low.Serialize())
+	mp := New(f.utxo, Config{MaxSize: 2 * size})
+
+	for _, tx := range []*transaction.Transaction{low, mid, high} {
+		if err := mp.Add(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+	if mp.Count() != 2 || mp.Get(low.ID) != nil {
+		t.Error("The lowest fee rate transaction should have been evicted")
+	}
+
+	if err := mp.Add(lowest); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("Expected ErrPoolFull, got %v", err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	f := newFixture(t, 1)
+	mp := New(f.utxo, Config{MaxSize: 1 << 20, Expiry: time.Hour})
+
+	parent := f.spend(f.funding, 0, 9)
+	mp.Add(parent)
+	mp.Add(f.spend(parent, 0, 8))
+
+	if dropped := mp.Expire(time.Now()); dropped != 0 {
+		t.Errorf("Fresh transactions should not expire, %d dropped", dropped)
+	}
+	if dropped := mp.Expire(time.Now().Add(2 * time.Hour)); dropped != 2 || mp.Count() != 0 {
+		t.Errorf("Expected both transactions to expire, %d dropped", dropped)
+	}
+}
+
+func TestFollowsChain(t *testing.T) {
+	f := newFixture(t, 2)
+	mp := New(f.utxo, DefaultConfig())
+
+	pooled := f.spend(f.funding, 0, 9)
+	conflicting := f.spend(f.funding, 1, 9)
+	if err := mp.Add(pooled); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if err := mp.Add(conflicting); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	// A block mines the first transaction and a competing spend of the second output
+	competing := f.spend(f.funding, 1, 10)
+	block := week1.NewBlockWithTransactions("block", nil, []*transaction.Transaction{pooled, competing})
+	block.SetHash()
+	for _, observer := range []interface{ ConnectBlock(*week1.Block) error }{f.utxo, mp} {
+		if err := observer.ConnectBlock(block); err != nil {
+			t.Fatalf("Failed to connect block: %v", err)
+		}
+	}
+	if mp.Count() != 0 {
+		t.Errorf("Mined and conflicting transactions should leave the pool, %d left", mp.Count())
+	}
+
+	// Disconnecting the block returns its transactions to the pool
+	f.utxo.DisconnectBlock(block)
+	mp.DisconnectBlock(block)
+	if mp.Get(pooled.ID) == nil || mp.Get(competing.ID) == nil {
+		t.Error("Transactions of a disconnected block should return to the pool")
+	}
+}
+
+func TestRejectsNonFinal(t *testing.T) {
+	f := newFixture(t, 1)
+	mp := New(f.utxo, DefaultConfig())
+
+	// The pool validates for the next block, at height 1
+	tx := &transaction.Transaction{
+		Vin:      []transaction.TXInput{{Txid: f.funding.ID, Vout: 0, PubKey: f.wallet.PublicKey}},
+		Vout:     []transaction.TXOutput{{Value: 10, PubKeyHash: f.pubKeyHash}},
+		LockTime: 1,
+	}
+	prevTXs := map[string]transaction.Transaction{hex.EncodeToString(f.funding.ID): *f.funding}
+	week3.SignTransaction(tx, f.wallet.PrivateKey, prevTXs)
+	tx.ID = tx.Hash()
+
+	var validationErr *week3.ValidationError
+	if err := mp.Add(tx); !errors.As(err, &validationErr) || validationErr.Rule != week3.RuleNonFinal {
+		t.Errorf("Expected a non-final transaction, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package week3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// b58Alphabet is the Bitcoin Base58 alphabet
+var b58Alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
+
+// b58Indexes maps an alphabet character to its value, -1 for invalid characters
+var b58Indexes = func() [256]int {
+	var indexes [256]int
+	for i := range indexes {
+		indexes[i] = -1
+	}
+	for i, c := range b58Alphabet {
+		indexes[c] = i
+	}
+	return indexes
+}()
+
+var (
+	// ErrChecksumMismatch is returned when a Base58Check checksum does not match its payload
+	ErrChecksumMismatch = errors.New("base58check: checksum mismatch")
+	// ErrTruncated is returned when Base58Check input is too short to hold a version and checksum
+	ErrTruncated = errors.New("base58check: input too short")
+)
+
+// InvalidCharacterError is returned when Base58 input contains a character outside the alphabet
+type InvalidCharacterError struct {
+	Char     byte
+	Position int
+}
+
+func (e *InvalidCharacterError) Error() string {
+	return fmt.Sprintf("base58: invalid character %q at position %d", e.Char, e.Position)
+}
+
+// Base58Encode encodes data to Base58
+func Base58Encode(input []byte) []byte {
+	var result []byte
+
+	x := new(big.Int).SetBytes(input)
+	base := big.NewInt(int64(len(b58Alphabet)))
+	zero := big.NewInt(0)
+	mod := new(big.Int)
+
+	for x.Cmp(zero) != 0 {
+		x.DivMod(x, base, mod)
+		result = append(result, b58Alphabet[mod.Int64()])
+	}
+
+	// Every leading zero byte is encoded as a leading '1'
+	for _, b := range input {
+		if b != 0x00 {
+			break
+		}
+		result = append(result, b58Alphabet[0])
+	}
+
+	reverseBytes(result)
+
+	return result
+}
+
+// Base58Decode decodes Base58-encoded data
+func Base58Decode(input []byte) ([]byte, error) {
+	result := big.NewInt(0)
+	base := big.NewInt(int64(len(b58Alphabet)))
+
+	for i, c := range input {
+		index := b58Indexes[c]
+		if index < 0 {
+			return nil, &InvalidCharacterError{Char: c, Position: i}
+		}
+		result.Mul(result, base)
+		result.Add(result, big.NewInt(int64(index)))
+	}
+
+	// Restore the leading zero bytes encoded as '1'
+	zeroBytes := 0
+	for _, c := range input {
+		if c != b58Alphabet[0] {
+			break
+		}
+		zeroBytes++
+	}
+
+	decoded := result.Bytes()
+	decoded = append(bytes.Repeat([]byte{0x00}, zeroBytes), decoded...)
+
+	return decoded, nil
+}
+
+// Base58CheckEncode encodes a versioned payload followed by its checksum
+func Base58CheckEncode(version byte, payload []byte) []byte {
+	versionedPayload := append([]byte{version}, payload...)
+	fullPayload := append(versionedPayload, checksum(versionedPayload)...)
+
+	return Base58Encode(fullPayload)
+}
+
+// Base58CheckDecode decodes Base58Check input and returns its version and payload
+func Base58CheckDecode(input []byte) (byte, []byte, error) {
+	decoded, err := Base58Decode(input)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(decoded) < 1+addressChecksumLen {
+		return 0, nil, ErrTruncated
+	}
+
+	versionedPayload := decoded[:len(decoded)-addressChecksumLen]
+	actualChecksum := decoded[len(decoded)-addressChecksumLen:]
+	if !bytes.Equal(actualChecksum, checksum(versionedPayload)) {
+		return 0, nil, ErrChecksumMismatch
+	}
+
+	return versionedPayload[0], versionedPayload[1:], nil
+}
+
+// reverseBytes reverses a byte slice in place
+func reverseBytes(data []byte) {
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+}
@@ -0,0 +1,90 @@
+package week3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestBase58Encode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"00", "1"},
+		{"0000287fb4cd", "11233QC4"},
+		{hex.EncodeToString([]byte("hello world")), "StV1DL6CwTryKyV"},
+	}
+
+	for _, test := range tests {
+		input, _ := hex.DecodeString(test.input)
+
+		encoded := Base58Encode(input)
+		if string(encoded) != test.expected {
+			t.Errorf("Base58Encode(%s) = %s, expected %s", test.input, encoded, test.expected)
+		}
+
+		decoded, err := Base58Decode(encoded)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %v", encoded, err)
+		}
+		if !bytes.Equal(decoded, input) {
+			t.Errorf("Base58Decode(%s) = %x, expected %s", encoded, decoded, test.input)
+		}
+	}
+}
+
+func TestBase58DecodeInvalidCharacter(t *testing.T) {
+	_, err := Base58Decode([]byte("abc0def"))
+
+	var charErr *InvalidCharacterError
+	if !errors.As(err, &charErr) {
+		t.Fatalf("Expected InvalidCharacterError, got %v", err)
+	}
+
+	if charErr.Char != '0' || charErr.Position != 3 {
+		t.Errorf("Unexpected error details: %v", charErr)
+	}
+}
+
+func TestBase58Check(t *testing.T) {
+	pubKeyHash, _ := hex.DecodeString("010966776006953d5567439e5e39f86a0d273bee")
+
+	address := Base58CheckEncode(0x00, pubKeyHash)
+	if string(address) != "16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM" {
+		t.Errorf("Unexpected address %s", address)
+	}
+
+	version, payload, err := Base58CheckDecode(address)
+	if err != nil {
+		t.Fatalf("Failed to decode address: %v", err)
+	}
+	if version != 0x00 || !bytes.Equal(payload, pubKeyHash) {
+		t.Error("Decoded version or payload is incorrect")
+	}
+
+	// Change one character to break the checksum
+	tampered := []byte(string(address))
+	tampered[5] = 'M'
+	if _, _, err := Base58CheckDecode(tampered); err != ErrChecksumMismatch {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+
+	if _, _, err := Base58CheckDecode([]byte("1z")); err != ErrTruncated {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	wallet := newWallet(t)
+
+	if !ValidateAddress(string(wallet.GetAddress())) {
+		t.Error("Wallet address should be valid")
+	}
+
+	if ValidateAddress("address") {
+		t.Error("Arbitrary string should not be a valid address")
+	}
+}
@@ -2,10 +2,9 @@ package week3
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"encoding/hex"
 	"fmt"
 
+	"blockchain-course/module1/keys"
 	"blockchain-course/module1/transaction"
 	week2 "blockchain-course/module1/week2"
 )
@@ -13,112 +12,177 @@ import (
 // Blockchain represents the blockchain structure for week3
 type Blockchain struct {
 	*week2.Blockchain
+
+	// UTXO indexes the unspent outputs of the main chain
+	UTXO *UTXOSet
+	// utxoPath is where the UTXO set is saved on Close, empty keeps it in memory
+	utxoPath string
 }
 
-// FindSpendableOutputs finds and returns unspent outputs to reference in inputs
-func (bc *Blockchain) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-	unspentTXs := bc.FindUnspentTransactions(pubkeyHash)
-	accumulated := 0
-
-Work:
-	for _, tx := range unspentTXs {
-		txID := hex.EncodeToString(tx.ID)
-
-		for outIdx, out := range tx.Vout {
-			if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
-				accumulated += out.Value
-				unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
-
-				if accumulated >= amount {
-					break Work
-				}
-			}
-		}
+// UnspentOutput is an unspent transaction output together with its location
+type UnspentOutput struct {
+	TxID   []byte
+	Index  int
+	Output transaction.TXOutput
+	// Height is the height of the block that created the output
+	Height int64
+	// Coinbase is set for the outputs of a coinbase, which must mature before being spent
+	Coinbase bool
+}
+
+// NewBlockchain wraps chain with a UTXO set indexed from its main chain. The
+// set is registered as an observer of the chain to follow new blocks and
+// reorganizations.
+func NewBlockchain(chain *week2.Blockchain) (*Blockchain, error) {
+	utxo := NewUTXOSetWithParams(chain.Params())
+	if err := utxo.Reindex(chain); err != nil {
+		return nil, err
 	}
+	chain.AddObserver(utxo)
 
-	return accumulated, unspentOutputs
+	return &Blockchain{Blockchain: chain, UTXO: utxo}, nil
 }
 
-// FindUnspentTransactions returns a list of transactions containing unspent outputs
-func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []*transaction.Transaction {
-	var unspentTXs []*transaction.Transaction
-	spentTXOs := make(map[string][]int)
-	bci := bc.Iterator()
+// OpenBlockchain wraps chain with the UTXO set saved at path, which is saved
+// back on Close. The set is reindexed if the file is missing or does not
+// match the tip of the chain.
+func OpenBlockchain(chain *week2.Blockchain, path string) (*Blockchain, error) {
+	tip, err := chain.TipBlock()
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		block := bci.Next()
+	utxo, err := LoadUTXOSet(path, chain.Params())
+	if err != nil || !bytes.Equal(utxo.Tip(), tip.Hash) {
+		utxo = NewUTXOSetWithParams(chain.Params())
+		if err := utxo.Reindex(chain); err != nil {
+			return nil, err
+		}
+	}
+	chain.AddObserver(utxo)
 
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Vout {
-				// Was the output spent?
-				if spentTXOs[txID] != nil {
-					for _, spentOutIdx := range spentTXOs[txID] {
-						if spentOutIdx == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				if out.IsLockedWithKey(pubKeyHash) {
-					unspentTXs = append(unspentTXs, tx)
-				}
-			}
+	return &Blockchain{Blockchain: chain, UTXO: utxo, utxoPath: path}, nil
+}
 
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Vin {
-					if in.UsesKey(pubKeyHash) {
-						inTxID := hex.EncodeToString(in.Txid)
-						spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
-					}
-				}
-			}
-		}
+// Reindex rebuilds the UTXO set from the main chain
+func (bc *Blockchain) Reindex() error {
+	return bc.UTXO.Reindex(bc.Blockchain)
+}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
+// Close saves the UTXO set if it has a file and closes the underlying storage
+func (bc *Blockchain) Close() error {
+	if bc.utxoPath != "" {
+		if err := bc.UTXO.SaveToFile(bc.utxoPath); err != nil {
+			bc.Blockchain.Close()
+			return err
 		}
 	}
+	return bc.Blockchain.Close()
+}
 
-	return unspentTXs
+// Validate checks the headers and proof of work of the chain, then replays
+// its transactions on an empty UTXO set. The error describes the first broken
+// rule, a *ValidationError for transaction rules.
+func (bc *Blockchain) Validate() error {
+	if !bc.Blockchain.IsValid() {
+		return fmt.Errorf("%w: block headers do not form a valid chain", week2.ErrInvalidBlock)
+	}
+	return NewUTXOSetWithParams(bc.Params()).Reindex(bc.Blockchain)
 }
 
-// SignTransaction signs inputs of a Transaction
-func (bc *Blockchain) SignTransaction(tx *transaction.Transaction, privKey ecdsa.PrivateKey) {
+// IsValid checks if the blockchain and all of its transactions are valid
+func (bc *Blockchain) IsValid() bool {
+	return bc.Validate() == nil
+}
+
+// FindSpendableOutputs finds and returns unspent outputs to reference in inputs
+func (bc *Blockchain) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int) {
+	return bc.UTXO.FindSpendableOutputs(pubkeyHash, amount)
+}
+
+// FindUTXO returns the unspent outputs locked with the given public key hash
+func (bc *Blockchain) FindUTXO(pubKeyHash []byte) []UnspentOutput {
+	return bc.UTXO.FindUTXO(pubKeyHash)
+}
+
+// FindSpendableScriptOutputs finds unspent outputs locked to scriptHash to reference in inputs
+func (bc *Blockchain) FindSpendableScriptOutputs(scriptHash []byte, amount int) (int, map[string][]int) {
+	return bc.UTXO.FindSpendableScriptOutputs(scriptHash, amount)
+}
+
+// FindScriptUTXO returns the unspent outputs locked to the hash of a redeem script
+func (bc *Blockchain) FindScriptUTXO(scriptHash []byte) []UnspentOutput {
+	return bc.UTXO.FindScriptUTXO(scriptHash)
+}
+
+// GetBalance returns the sum of the unspent outputs locked with the given public key hash
+func (bc *Blockchain) GetBalance(pubKeyHash []byte) int {
+	return bc.UTXO.Balance(pubKeyHash)
+}
+
+// outpointKey identifies a transaction output
+func outpointKey(txID []byte, index int) string {
+	return fmt.Sprintf("%x:%d", txID, index)
+}
+
+// SignTransaction signs inputs of a Transaction spending outputs of the UTXO set
+func (bc *Blockchain) SignTransaction(tx *transaction.Transaction, privKey keys.Signer) error {
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	return SignTransaction(tx, privKey, prevTXs)
+}
+
+// SignMultiSig signs the inputs of tx spending a multisig address with the
+// key of one of its holders
+func (bc *Blockchain) SignMultiSig(tx *transaction.Transaction, ms *MultiSig, wallet *Wallet) (*PartialSignature, error) {
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return SignMultiSig(*tx, ms, wallet, prevTXs)
+}
+
+// CombineMultiSig writes the signatures of the holders of a multisig address
+// into the inputs of tx spending it
+func (bc *Blockchain) CombineMultiSig(tx *transaction.Transaction, ms *MultiSig, partials []*PartialSignature) error {
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	return CombineMultiSig(tx, ms, partials, prevTXs)
+}
+
+// prevTransactions looks up the outputs spent by tx in the UTXO set
+func (bc *Blockchain) prevTransactions(tx *transaction.Transaction) (map[string]transaction.Transaction, error) {
 	prevTXs := make(map[string]transaction.Transaction)
 
 	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			fmt.Printf("Error: %s\n", err)
-			return
+		utxo, ok := bc.UTXO.Get(vin.Txid, vin.Vout)
+		if !ok {
+			return nil, fmt.Errorf("%w: %w: %s", ErrMissingPrevTx, ErrOutputNotFound, outpointKey(vin.Txid, vin.Vout))
 		}
-		// Create a copy of the transaction to store in the map
-		prevTXs[hex.EncodeToString(prevTX.ID)] = *prevTX
+
+		addPrevOutput(prevTXs, vin, utxo.Output)
 	}
 
-	SignTransaction(tx, privKey, prevTXs)
+	return prevTXs, nil
 }
 
 // FindTransaction finds a transaction by its ID
 func (bc *Blockchain) FindTransaction(ID []byte) (*transaction.Transaction, error) {
 	bci := bc.Iterator()
-	for {
-		block := bci.Next()
-
+	for block := bci.Next(); block != nil; block = bci.Next() {
 		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
+			if bytes.Equal(tx.ID, ID) {
 				return tx, nil
 			}
 		}
-
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
 	}
 
-	return nil, fmt.Errorf("Transaction is not found")
+	return nil, fmt.Errorf("%w: %x", ErrTransactionNotFound, ID)
 }
@@ -0,0 +1,188 @@
+package week3
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"blockchain-course/module1/keys"
+)
+
+// HardenedKeyStart is the first index of hardened children, which can only be
+// derived from the private key of their parent
+const HardenedKeyStart = 0x80000000
+
+// Derivation paths follow BIP44: m/44'/coin'/account'/chain/index
+const (
+	purposeBIP44 = 44
+	// coinType is the registered coin type of test networks
+	coinType = 1
+
+	// ExternalChain is the chain of receiving addresses of an account
+	ExternalChain = 0
+	// InternalChain is the chain of change addresses of an account
+	InternalChain = 1
+)
+
+// masterKeyHMACKey is the HMAC key deriving the master key of P-256 seeds, as in SLIP-10
+var masterKeyHMACKey = []byte("Nist256p1 seed")
+
+// ErrInvalidPath is returned when parsing a malformed derivation path
+var ErrInvalidPath = errors.New("invalid derivation path")
+
+// ExtendedKey is a private key of an HD wallet along with the chain code
+// needed to derive its children
+type ExtendedKey struct {
+	Key       []byte
+	ChainCode []byte
+	Depth     uint8
+	// ParentFingerprint is the start of the hash of the parent public key, 0 for the master key
+	ParentFingerprint uint32
+	ChildNumber       uint32
+}
+
+// NewMasterKey derives the root key of an HD wallet from a seed
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("seed of %d bytes, expected 16 to 64", len(seed))
+	}
+
+	// An invalid key is vanishingly unlikely, SLIP-10 retries with the output as seed
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, masterKeyHMACKey)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(curveOrder()) < 0 {
+			return &ExtendedKey{Key: sum[:32], ChainCode: sum[32:]}, nil
+		}
+		data = sum
+	}
+}
+
+func curveOrder() *big.Int {
+	return elliptic.P256().Params().N
+}
+
+// Child derives the child key at index, hardened from HardenedKeyStart on
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if k.Depth == 255 {
+		return nil, fmt.Errorf("%w: maximum depth reached", ErrInvalidPath)
+	}
+
+	publicKey, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, 37)
+	if index >= HardenedKeyStart {
+		data = append(data, 0x00)
+		data = append(data, k.Key...)
+	} else {
+		data = append(data, publicKey...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	parent := new(big.Int).SetBytes(k.Key)
+	n := curveOrder()
+	for {
+		mac := hmac.New(sha512.New, k.ChainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		tweak := new(big.Int).SetBytes(sum[:32])
+		child := new(big.Int).Add(tweak, parent)
+		child.Mod(child, n)
+		if tweak.Cmp(n) < 0 && child.Sign() != 0 {
+			key := make([]byte, 32)
+			child.FillBytes(key)
+			return &ExtendedKey{
+				Key:               key,
+				ChainCode:         sum[32:],
+				Depth:             k.Depth + 1,
+				ParentFingerprint: binary.BigEndian.Uint32(HashPubKey(publicKey)[:4]),
+				ChildNumber:       index,
+			}, nil
+		}
+
+		// Invalid child key, SLIP-10 retries with the right half of the output
+		data = append([]byte{0x01}, sum[32:]...)
+		data = binary.BigEndian.AppendUint32(data, index)
+	}
+}
+
+// Derive follows a derivation path such as m/44'/1'/0'/0/3 from k, which
+// must be the master key. Hardened indexes are marked with ' or h.
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := k
+	for _, index := range indexes {
+		if key, err = key.Child(index); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// ParsePath returns the child indexes of a derivation path
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q does not start at m", ErrInvalidPath, path)
+	}
+
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		if hardened {
+			index += HardenedKeyStart
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+// DerivationPath returns the BIP44 path of the address at index on a chain of an account
+func DerivationPath(account, chain, index uint32) string {
+	return fmt.Sprintf("m/%d'/%d'/%d'/%d/%d", purposeBIP44, coinType, account, chain, index)
+}
+
+// PublicKey returns the compressed SEC1 encoding of the public key
+func (k *ExtendedKey) PublicKey() ([]byte, error) {
+	wallet, err := k.Wallet()
+	if err != nil {
+		return nil, err
+	}
+	// The wallet key starts with its scheme
+	return wallet.PublicKey[1:], nil
+}
+
+// Wallet returns the Wallet of the key. SLIP-10 keys are derived on P-256.
+func (k *ExtendedKey) Wallet() (*Wallet, error) {
+	private, err := keys.NewSigner(keys.SchemeP256, k.Key)
+	if err != nil {
+		return nil, err
+	}
+	return walletFromSigner(private), nil
+}
@@ -0,0 +1,70 @@
+package week3
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestDeriveVectors(t *testing.T) {
+	// Test vector 1 of SLIP-10 for NIST P-256
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatalf("Failed to create master key: %v", err)
+	}
+
+	vectors := []struct {
+		path      string
+		chainCode string
+		key       string
+	}{
+		{"m", "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+		{"m/0'", "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+	}
+
+	for _, v := range vectors {
+		key, err := master.Derive(v.path)
+		if err != nil {
+			t.Fatalf("Failed to derive %s: %v", v.path, err)
+		}
+		if hex.EncodeToString(key.ChainCode) != v.chainCode {
+			t.Errorf("Unexpected chain code for %s: %x", v.path, key.ChainCode)
+		}
+		if hex.EncodeToString(key.Key) != v.key {
+			t.Errorf("Unexpected key for %s: %x", v.path, key.Key)
+		}
+	}
+
+	// Non-hardened children are derived from the public key of their parent
+	child, err := master.Derive("m/0'/1")
+	if err != nil {
+		t.Fatalf("Failed to derive m/0'/1: %v", err)
+	}
+	if hex.EncodeToString(child.Key) != "284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129" {
+		t.Errorf("Unexpected key for m/0'/1: %x", child.Key)
+	}
+	if child.Depth != 2 || child.ChildNumber != 1 {
+		t.Errorf("Unexpected depth %d and child number %d", child.Depth, child.ChildNumber)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	indexes, err := ParsePath("m/44'/1h/0'/1/7")
+	if err != nil {
+		t.Fatalf("Failed to parse path: %v", err)
+	}
+
+	expected := []uint32{HardenedKeyStart + 44, HardenedKeyStart + 1, HardenedKeyStart, 1, 7}
+	for i := range expected {
+		if indexes[i] != expected[i] {
+			t.Errorf("Index %d should be %d, got %d", i, expected[i], indexes[i])
+		}
+	}
+
+	for _, path := range []string{"", "44'/0", "m/x", "m/2147483648", "m//1"} {
+		if _, err := ParsePath(path); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Expected ErrInvalidPath for %q, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package week3
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// DefaultEntropyBits is the entropy of the mnemonics generated for new wallets, 12 words
+const DefaultEntropyBits = 128
+
+const (
+	// wordBits is the number of bits encoded by each word of a mnemonic
+	wordBits = 11
+	// seedIterations is the number of PBKDF2 rounds deriving a seed from a mnemonic
+	seedIterations = 2048
+	seedLen        = 64
+)
+
+var (
+	// ErrInvalidEntropy is returned for entropy that is not 128 to 256 bits in steps of 32
+	ErrInvalidEntropy = errors.New("invalid mnemonic entropy length")
+	// ErrInvalidMnemonic is returned for mnemonics with unknown words or a wrong word count
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+	// ErrMnemonicChecksum is returned when the checksum of a mnemonic does not match its words
+	ErrMnemonicChecksum = errors.New("mnemonic checksum mismatch")
+)
+
+// englishWordlist holds the 2048 words of the BIP39 English wordlist, one per line
+//
+//go:embed wordlist_english.txt
+var englishWordlist string
+
+var (
+	wordlist  = strings.Fields(englishWordlist)
+	wordIndex = indexWords(wordlist)
+)
+
+func indexWords(words []string) map[string]int {
+	index := make(map[string]int, len(words))
+	for i, word := range words {
+		index[word] = i
+	}
+	return index
+}
+
+// NewEntropy returns bits of random entropy to build a mnemonic from
+func NewEntropy(bits int) ([]byte, error) {
+	if err := checkEntropyBits(bits); err != nil {
+		return nil, err
+	}
+
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+func checkEntropyBits(bits int) error {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return fmt.Errorf("%w: %d bits", ErrInvalidEntropy, bits)
+	}
+	return nil
+}
+
+// NewMnemonic encodes entropy as a BIP39 mnemonic. The entropy is followed by
+// the first bits of its SHA-256 as a checksum, one bit per 32 bits of entropy,
+// and every 11 bits select a word.
+func NewMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if err := checkEntropyBits(bits); err != nil {
+		return "", err
+	}
+
+	checksumBits := bits / 32
+	hash := sha256.Sum256(entropy)
+
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, uint(checksumBits))
+	data.Or(data, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	words := make([]string, (bits+checksumBits)/wordBits)
+	mask := big.NewInt(1<<wordBits - 1)
+	index := new(big.Int)
+	for i := len(words) - 1; i >= 0; i-- {
+		index.And(data, mask)
+		words[i] = wordlist[index.Int64()]
+		data.Rsh(data, wordBits)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes a mnemonic and checks its checksum
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words)%3 != 0 || len(words) < 12 || len(words) > 24 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+
+	data := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		data.Lsh(data, wordBits)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) * wordBits / 33
+	checksum := new(big.Int).And(data, big.NewInt(1<<checksumBits-1))
+	data.Rsh(data, uint(checksumBits))
+
+	entropy := make([]byte, checksumBits*4)
+	data.FillBytes(entropy)
+
+	hash := sha256.Sum256(entropy)
+	if checksum.Int64() != int64(hash[0]>>(8-checksumBits)) {
+		return nil, ErrMnemonicChecksum
+	}
+	return entropy, nil
+}
+
+// IsMnemonicValid reports whether the mnemonic only uses known words and has a valid checksum
+func IsMnemonicValid(mnemonic string) bool {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err == nil
+}
+
+// NewSeed derives the 64-byte seed of an HD wallet from a mnemonic and an
+// optional passphrase. Any passphrase gives a valid seed, so a mistyped
+// passphrase silently yields another wallet.
+func NewSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := MnemonicToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key(sha512.New, normalized, []byte("mnemonic"+passphrase), seedIterations, seedLen)
+}
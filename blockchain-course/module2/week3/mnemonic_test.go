@@ -0,0 +1,78 @@
+package week3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWordlist(t *testing.T) {
+	if len(wordlist) != 2048 {
+		t.Fatalf("Expected 2048 words, got %d", len(wordlist))
+	}
+	if !sort.StringsAreSorted(wordlist) {
+		t.Error("Wordlist should be sorted")
+	}
+	if len(wordIndex) != len(wordlist) {
+		t.Error("Wordlist should not contain duplicates")
+	}
+}
+
+func TestMnemonicVectors(t *testing.T) {
+	// Test vectors of BIP39
+	vectors := []struct {
+		entropy  string
+		mnemonic string
+	}{
+		{"00000000000000000000000000000000", strings.Repeat("abandon ", 11) + "about"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f", "legal winner thank year wave sausage worth useful legal winner thank yellow"},
+		{"80808080808080808080808080808080", "letter advice cage absurd amount doctor acoustic avoid letter advice cage above"},
+		{"ffffffffffffffffffffffffffffffff", strings.Repeat("zoo ", 11) + "wrong"},
+	}
+
+	for _, v := range vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		mnemonic, err := NewMnemonic(entropy)
+		if err != nil {
+			t.Fatalf("Failed to encode %s: %v", v.entropy, err)
+		}
+		if mnemonic != v.mnemonic {
+			t.Errorf("Entropy %s should encode to %q, got %q", v.entropy, v.mnemonic, mnemonic)
+		}
+
+		decoded, err := MnemonicToEntropy(mnemonic)
+		if err != nil || !bytes.Equal(decoded, entropy) {
+			t.Errorf("Mnemonic %q should decode to %s, got %x (%v)", mnemonic, v.entropy, decoded, err)
+		}
+	}
+}
+
+func TestMnemonicErrors(t *testing.T) {
+	if _, err := NewMnemonic(make([]byte, 15)); !errors.Is(err, ErrInvalidEntropy) {
+		t.Errorf("Expected ErrInvalidEntropy, got %v", err)
+	}
+	if _, err := MnemonicToEntropy(strings.Repeat("abandon ", 12)); !errors.Is(err, ErrMnemonicChecksum) {
+		t.Errorf("Expected ErrMnemonicChecksum, got %v", err)
+	}
+	if _, err := MnemonicToEntropy(strings.Repeat("abandon ", 11) + "bitcoin"); !errors.Is(err, ErrInvalidMnemonic) {
+		t.Errorf("Expected ErrInvalidMnemonic for an unknown word, got %v", err)
+	}
+	if IsMnemonicValid("abandon about") {
+		t.Error("Two words should not be a valid mnemonic")
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	seed, err := NewSeed(strings.Repeat("abandon ", 11)+"about", "TREZOR")
+	if err != nil {
+		t.Fatalf("Failed to derive seed: %v", err)
+	}
+
+	expected := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+	if hex.EncodeToString(seed) != expected {
+		t.Errorf("Unexpected seed %x", seed)
+	}
+}
@@ -0,0 +1,250 @@
+package week3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"blockchain-course/module1/keys"
+	"blockchain-course/module1/transaction"
+)
+
+var (
+	// ErrInvalidPublicKey is returned for public keys that are not a point of the curve
+	ErrInvalidPublicKey = keys.ErrInvalidPublicKey
+	// ErrNotCosigner is returned when a key is not one of the keys of a multisig address
+	ErrNotCosigner = errors.New("not a cosigner of the multisig address")
+	// ErrNotEnoughSignatures is returned when combining fewer signatures than the threshold
+	ErrNotEnoughSignatures = errors.New("not enough signatures")
+)
+
+// MultiSig is an M-of-N multisignature lock. Its public keys are sorted so
+// that every holder derives the same address from the same keys.
+type MultiSig struct {
+	M       int
+	PubKeys [][]byte
+}
+
+// PartialSignature holds the signatures of one holder of a multisig address
+// for the inputs of a transaction spending it, by input index
+type PartialSignature struct {
+	PubKey     []byte
+	Signatures map[int][]byte
+}
+
+// NewMultiSig creates the lock requiring m signatures of the public keys
+func NewMultiSig(m int, pubKeys [][]byte) (*MultiSig, error) {
+	sorted := make([][]byte, len(pubKeys))
+	for i, pubKey := range pubKeys {
+		if !isValidPublicKey(pubKey) {
+			return nil, fmt.Errorf("%w: %x", ErrInvalidPublicKey, pubKey)
+		}
+		sorted[i] = bytes.Clone(pubKey)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	for i := 1; i < len(sorted); i++ {
+		if bytes.Equal(sorted[i-1], sorted[i]) {
+			return nil, fmt.Errorf("%w: duplicate key %x", ErrInvalidPublicKey, sorted[i])
+		}
+	}
+
+	if _, err := MultiSigScript(m, sorted); err != nil {
+		return nil, err
+	}
+	return &MultiSig{M: m, PubKeys: sorted}, nil
+}
+
+// ParseMultiSig returns the lock of a redeem script built by RedeemScript
+func ParseMultiSig(redeemScript []byte) (*MultiSig, error) {
+	ops, err := parseScript(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) < 4 || ops[len(ops)-1].code != OpCheckMultiSig {
+		return nil, fmt.Errorf("%w: not a multisig script", ErrInvalidScript)
+	}
+
+	m := int(ops[0].code) - int(Op1) + 1
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		pubKeys = append(pubKeys, op.data)
+	}
+
+	ms, err := NewMultiSig(m, pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(ms.RedeemScript(), redeemScript) {
+		return nil, fmt.Errorf("%w: not a canonical multisig script", ErrInvalidScript)
+	}
+	return ms, nil
+}
+
+// isValidPublicKey reports whether pubKey is the encoding of a public key of
+// a supported signature scheme
+func isValidPublicKey(pubKey []byte) bool {
+	_, err := keys.ParsePublicKey(pubKey)
+	return err == nil
+}
+
+// RedeemScript returns the script unlocked by M signatures of the keys
+func (ms *MultiSig) RedeemScript() []byte {
+	// The keys were checked by NewMultiSig
+	script, _ := MultiSigScript(ms.M, ms.PubKeys)
+	return script
+}
+
+// ScriptHash returns the hash of the redeem script, which outputs are locked to
+func (ms *MultiSig) ScriptHash() []byte {
+	return HashPubKey(ms.RedeemScript())
+}
+
+// Address returns the address paying to the multisig lock
+func (ms *MultiSig) Address() []byte {
+	return Base58CheckEncode(scriptHashVersion, ms.ScriptHash())
+}
+
+func (ms *MultiSig) keyIndex(pubKey []byte) int {
+	for i, key := range ms.PubKeys {
+		if bytes.Equal(key, pubKey) {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsLockedWithScript checks if the output pays the hash of a redeem script
+func IsLockedWithScript(out transaction.TXOutput, scriptHash []byte) bool {
+	return isPayToScriptHash(out.Script) && bytes.Equal(out.Script[2:2+pubKeyHashLen], scriptHash)
+}
+
+// NewMultiSigTransaction creates an unsigned transaction spending the outputs
+// of a multisig address, which receives the change. Each holder signs it with
+// SignMultiSig before the signatures are combined with CombineMultiSig.
+func NewMultiSigTransaction(ms *MultiSig, to string, amount, fee int, bc *Blockchain) (*transaction.Transaction, error) {
+	if err := checkAmount(amount, fee); err != nil {
+		return nil, err
+	}
+	if !ValidateAddress(to) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, to)
+	}
+	from := string(ms.Address())
+
+	acc, validOutputs := bc.FindSpendableScriptOutputs(ms.ScriptHash(), amount+fee)
+	if acc < amount+fee {
+		return nil, fmt.Errorf("%w: %s has %d spendable, needs %d", ErrInsufficientFunds, from, acc, amount+fee)
+	}
+
+	inputs, err := spendingInputs(validOutputs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+	outputs := []transaction.TXOutput{*payment}
+	if acc > amount+fee {
+		change, err := NewTXOutput(acc-amount-fee, from)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *change)
+	}
+
+	tx := transaction.Transaction{Vin: inputs, Vout: outputs}
+	tx.ID = tx.Hash()
+	return &tx, nil
+}
+
+// SignMultiSig signs the inputs of tx spending the multisig address with the
+// key of wallet, one of its holders. prevTXs must hold the outputs spent by
+// the inputs.
+func SignMultiSig(tx transaction.Transaction, ms *MultiSig, wallet *Wallet, prevTXs map[string]transaction.Transaction) (*PartialSignature, error) {
+	if ms.keyIndex(wallet.PublicKey) < 0 {
+		return nil, fmt.Errorf("%w: %x", ErrNotCosigner, wallet.PublicKey)
+	}
+	if err := checkPrevOutputs(tx, prevTXs); err != nil {
+		return nil, err
+	}
+
+	partial := &PartialSignature{PubKey: wallet.PublicKey, Signatures: make(map[int][]byte)}
+	redeemScript := ms.RedeemScript()
+	for _, inID := range ms.spendingInputs(tx, prevTXs) {
+		signature, err := signWithSubscript(tx, inID, wallet.PrivateKey, redeemScript, transaction.SigHashAll)
+		if err != nil {
+			return nil, err
+		}
+		partial.Signatures[inID] = signature
+	}
+
+	if len(partial.Signatures) == 0 {
+		return nil, fmt.Errorf("no input spends %s", ms.Address())
+	}
+	return partial, nil
+}
+
+// CombineMultiSig checks the partial signatures of the holders and writes the
+// unlocking scripts of the inputs of tx spending the multisig address, which
+// need M valid signatures each. The ID of tx is updated since it commits to
+// the unlocking scripts.
+func CombineMultiSig(tx *transaction.Transaction, ms *MultiSig, partials []*PartialSignature, prevTXs map[string]transaction.Transaction) error {
+	if err := checkPrevOutputs(*tx, prevTXs); err != nil {
+		return err
+	}
+	for _, partial := range partials {
+		if ms.keyIndex(partial.PubKey) < 0 {
+			return fmt.Errorf("%w: %x", ErrNotCosigner, partial.PubKey)
+		}
+	}
+
+	redeemScript := ms.RedeemScript()
+	for _, inID := range ms.spendingInputs(*tx, prevTXs) {
+		// CHECKMULTISIG expects the signatures in the order of the keys
+		byKey := make([][]byte, len(ms.PubKeys))
+		for _, partial := range partials {
+			signature, ok := partial.Signatures[inID]
+			if !ok {
+				continue
+			}
+			if !verifySignature(*tx, inID, redeemScript, partial.PubKey, signature) {
+				return fmt.Errorf("%w: input %d signed by %x", ErrInvalidSignature, inID, partial.PubKey)
+			}
+			byKey[ms.keyIndex(partial.PubKey)] = signature
+		}
+
+		b := NewScriptBuilder()
+		count := 0
+		for _, signature := range byKey {
+			if signature != nil && count < ms.M {
+				b.AddData(signature)
+				count++
+			}
+		}
+		if count < ms.M {
+			return fmt.Errorf("%w: input %d has %d of %d", ErrNotEnoughSignatures, inID, count, ms.M)
+		}
+		tx.Vin[inID].Script = b.AddData(redeemScript).Script()
+	}
+
+	tx.ID = tx.Hash()
+	return nil
+}
+
+// spendingInputs returns the indexes of the inputs of tx spending the multisig address
+func (ms *MultiSig) spendingInputs(tx transaction.Transaction, prevTXs map[string]transaction.Transaction) []int {
+	scriptHash := ms.ScriptHash()
+	var inputs []int
+	for inID, vin := range tx.Vin {
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
+		if IsLockedWithScript(prevOut, scriptHash) {
+			inputs = append(inputs, inID)
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,146 @@
+package week3
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"blockchain-course/module1/keys"
+	"blockchain-course/module1/transaction"
+	week2 "blockchain-course/module1/week2"
+)
+
+func TestMultiSigAddress(t *testing.T) {
+	wallets := []*Wallet{newWallet(t), newWallet(t), newWallet(t)}
+	pubKeys := [][]byte{wallets[0].PublicKey, wallets[1].PublicKey, wallets[2].PublicKey}
+
+	ms, err := NewMultiSig(2, pubKeys)
+	if err != nil {
+		t.Fatalf("Failed to create multisig: %v", err)
+	}
+	address := string(ms.Address())
+	if !ValidateAddress(address) || !IsScriptHashAddress(address) {
+		t.Errorf("Multisig address %s should be a valid script hash address", address)
+	}
+	if IsScriptHashAddress(string(wallets[0].GetAddress())) {
+		t.Error("Wallet address should not be a script hash address")
+	}
+
+	// Holders listing the keys in another order get the same address
+	reordered, err := NewMultiSig(2, [][]byte{pubKeys[2], pubKeys[0], pubKeys[1]})
+	if err != nil || !bytes.Equal(reordered.Address(), ms.Address()) {
+		t.Errorf("Key order should not change the address, %v", err)
+	}
+
+	parsed, err := ParseMultiSig(ms.RedeemScript())
+	if err != nil || !bytes.Equal(parsed.Address(), ms.Address()) {
+		t.Errorf("Parsed redeem script should give the same address, %v", err)
+	}
+
+	output, err := NewTXOutput(5, address)
+	if err != nil || output.PubKeyHash != nil || !IsLockedWithScript(*output, ms.ScriptHash()) {
+		t.Error("Output paying a multisig address should be locked to its script hash")
+	}
+
+	if _, err := NewMultiSig(2, [][]byte{pubKeys[0], pubKeys[0]}); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("Expected ErrInvalidPublicKey for duplicate keys, got %v", err)
+	}
+	if _, err := NewMultiSig(4, pubKeys); !errors.Is(err, ErrInvalidScript) {
+		t.Errorf("Expected ErrInvalidScript for 4 of 3, got %v", err)
+	}
+}
+
+func TestMultiSigSpend(t *testing.T) {
+	// Holders may use different signature schemes
+	var wallets []*Wallet
+	for _, scheme := range keys.Schemes {
+		wallet, err := NewWalletWithScheme(scheme)
+		if err != nil {
+			t.Fatalf("Failed to create %s wallet: %v", scheme, err)
+		}
+		wallets = append(wallets, wallet)
+	}
+	var pubKeys [][]byte
+	for _, wallet := range wallets {
+		pubKeys = append(pubKeys, wallet.PublicKey)
+	}
+	ms, err := NewMultiSig(2, pubKeys)
+	if err != nil {
+		t.Fatalf("Failed to create multisig: %v", err)
+	}
+	treasury := string(ms.Address())
+	bob := newWallet(t)
+
+	bc, err := NewBlockchain(week2.NewBlockchain())
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	funding, err := NewCoinbaseTX(treasury, "")
+	if err != nil {
+		t.Fatalf("Failed to create coinbase: %v", err)
+	}
+	if err := bc.AddBlockWithTransactions("Funding", []*transaction.Transaction{funding}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+	if len(bc.FindScriptUTXO(ms.ScriptHash())) != 1 {
+		t.Fatal("Treasury should own the funding output")
+	}
+
+	if _, err := NewMultiSigTransaction(ms, string(bob.GetAddress()), 0, 0, bc); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Expected ErrInvalidAmount for a zero amount, got %v", err)
+	}
+	if _, err := NewMultiSigTransaction(ms, string(bob.GetAddress()), 6, -1, bc); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Expected ErrInvalidAmount for a negative fee, got %v", err)
+	}
+
+	tx, err := NewMultiSigTransaction(ms, string(bob.GetAddress()), 6, 0, bc)
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+
+	// Each holder signs on their own
+	var partials []*PartialSignature
+	for _, wallet := range wallets[1:] {
+		partial, err := bc.SignMultiSig(tx, ms, wallet)
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		partials = append(partials, partial)
+	}
+	if _, err := bc.SignMultiSig(tx, ms, bob); !errors.Is(err, ErrNotCosigner) {
+		t.Errorf("Expected ErrNotCosigner, got %v", err)
+	}
+
+	if err := bc.CombineMultiSig(tx, ms, partials[:1]); !errors.Is(err, ErrNotEnoughSignatures) {
+		t.Errorf("Expected ErrNotEnoughSignatures, got %v", err)
+	}
+	if err := bc.CombineMultiSig(tx, ms, partials); err != nil {
+		t.Fatalf("Failed to combine signatures: %v", err)
+	}
+
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		t.Fatalf("Failed to find previous transactions: %v", err)
+	}
+	if !VerifyTransaction(*tx, prevTXs) {
+		t.Error("Combined transaction should verify")
+	}
+
+	tampered := *tx
+	tampered.Vout = []transaction.TXOutput{tx.Vout[0], tx.Vout[1]}
+	tampered.Vout[0].Value++
+	if VerifyTransaction(tampered, prevTXs) {
+		t.Error("Tampered transaction should not verify")
+	}
+
+	if err := bc.AddBlockWithTransactions("Spend", []*transaction.Transaction{tx}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+	if balance := bc.GetBalance(HashPubKey(bob.PublicKey)); balance != 6 {
+		t.Errorf("Expected balance 6, got %d", balance)
+	}
+	change := bc.FindScriptUTXO(ms.ScriptHash())
+	if len(change) != 1 || change[0].Output.Value != funding.Vout[0].Value-6 {
+		t.Errorf("Treasury should keep the change, got %v", change)
+	}
+}
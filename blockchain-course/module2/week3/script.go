@@ -0,0 +1,717 @@
+package week3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"blockchain-course/module1/keys"
+	"blockchain-course/module1/transaction"
+)
+
+// Opcodes of the script language. Values follow Bitcoin so that scripts read
+// the same in both.
+const (
+	// Op0 pushes an empty item, which is false
+	Op0 byte = 0x00
+	// OpPushData1 pushes the number of bytes given by the next byte
+	OpPushData1 byte = 0x4c
+	// OpPushData2 pushes the number of bytes given by the next two bytes, little-endian
+	OpPushData2 byte = 0x4d
+	// Op1Negate pushes the number -1
+	Op1Negate byte = 0x4f
+	// Op1 to Op16 push the numbers 1 to 16
+	Op1  byte = 0x51
+	Op16 byte = 0x60
+
+	OpVerify              byte = 0x69
+	OpReturn              byte = 0x6a
+	OpDrop                byte = 0x75
+	OpDup                 byte = 0x76
+	OpEqual               byte = 0x87
+	OpEqualVerify         byte = 0x88
+	OpSHA256              byte = 0xa8
+	OpHash160             byte = 0xa9
+	OpCheckSig            byte = 0xac
+	OpCheckSigVerify      byte = 0xad
+	OpCheckMultiSig       byte = 0xae
+	OpCheckMultiSigVerify byte = 0xaf
+	OpCheckLockTimeVerify byte = 0xb1
+)
+
+// Opcodes from 0x01 to maxDirectPush push that many following bytes
+const maxDirectPush = 0x4b
+
+// Resource limits of scripts
+const (
+	maxScriptSize   = 10000
+	maxStackSize    = 1000
+	maxElementSize  = 520
+	maxMultiSigKeys = 20
+	maxScriptNumLen = 4
+	lockTimeNumLen  = 5
+)
+
+var (
+	// ErrInvalidScript is returned for scripts that cannot be parsed or break a resource limit
+	ErrInvalidScript = errors.New("invalid script")
+	// ErrScriptFailed is returned when an unlocking script does not satisfy a locking script
+	ErrScriptFailed = errors.New("script failed")
+)
+
+var opNames = map[byte]string{
+	Op1Negate:             "-1",
+	OpVerify:              "VERIFY",
+	OpReturn:              "RETURN",
+	OpDrop:                "DROP",
+	OpDup:                 "DUP",
+	OpEqual:               "EQUAL",
+	OpEqualVerify:         "EQUALVERIFY",
+	OpSHA256:              "SHA256",
+	OpHash160:             "HASH160",
+	OpCheckSig:            "CHECKSIG",
+	OpCheckSigVerify:      "CHECKSIGVERIFY",
+	OpCheckMultiSig:       "CHECKMULTISIG",
+	OpCheckMultiSigVerify: "CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "CHECKLOCKTIMEVERIFY",
+}
+
+// scriptOp is a parsed opcode along with the data it pushes
+type scriptOp struct {
+	code byte
+	data []byte
+}
+
+func (op scriptOp) isPush() bool {
+	return op.code <= OpPushData2 || op.code == Op1Negate || op.code >= Op1 && op.code <= Op16
+}
+
+func (op scriptOp) String() string {
+	switch {
+	case op.code == Op0:
+		return "0"
+	case op.code <= OpPushData2:
+		return fmt.Sprintf("%x", op.data)
+	case op.code >= Op1 && op.code <= Op16:
+		return fmt.Sprintf("%d", op.code-Op1+1)
+	}
+	if name, ok := opNames[op.code]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN_%#x", op.code)
+}
+
+// parseScript splits a script into its opcodes
+func parseScript(script []byte) ([]scriptOp, error) {
+	if len(script) > maxScriptSize {
+		return nil, fmt.Errorf("%w: %d bytes exceed %d", ErrInvalidScript, len(script), maxScriptSize)
+	}
+
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+		op := scriptOp{code: script[i]}
+		i++
+
+		n := 0
+		switch {
+		case op.code >= 0x01 && op.code <= maxDirectPush:
+			n = int(op.code)
+		case op.code == OpPushData1:
+			if i+1 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push at %d", ErrInvalidScript, i-1)
+			}
+			n = int(script[i])
+			i++
+		case op.code == OpPushData2:
+			if i+2 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push at %d", ErrInvalidScript, i-1)
+			}
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		}
+		if i+n > len(script) {
+			return nil, fmt.Errorf("%w: truncated push at %d", ErrInvalidScript, i-1)
+		}
+		if op.code != Op0 && op.code <= OpPushData2 {
+			op.data = script[i : i+n]
+		}
+		i += n
+
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// DisassembleScript returns the opcodes of a script in a readable form
+func DisassembleScript(script []byte) (string, error) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, len(ops))
+	for i, op := range ops {
+		parts[i] = op.String()
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// ScriptBuilder assembles a script from opcodes and data
+type ScriptBuilder struct {
+	script []byte
+}
+
+// NewScriptBuilder creates an empty ScriptBuilder
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+// AddOp appends an opcode
+func (b *ScriptBuilder) AddOp(op byte) *ScriptBuilder {
+	b.script = append(b.script, op)
+	return b
+}
+
+// AddData appends the shortest push of data
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	switch n := len(data); {
+	case n == 0:
+		b.script = append(b.script, Op0)
+	case n <= maxDirectPush:
+		b.script = append(b.script, byte(n))
+	case n <= 0xff:
+		b.script = append(b.script, OpPushData1, byte(n))
+	default:
+		b.script = append(b.script, OpPushData2)
+		b.script = binary.LittleEndian.AppendUint16(b.script, uint16(n))
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+// AddInt appends a push of the number n, using Op1 to Op16 when possible
+func (b *ScriptBuilder) AddInt(n int64) *ScriptBuilder {
+	switch {
+	case n == 0:
+		return b.AddOp(Op0)
+	case n == -1:
+		return b.AddOp(Op1Negate)
+	case n >= 1 && n <= 16:
+		return b.AddOp(Op1 + byte(n-1))
+	}
+	return b.AddData(encodeScriptNum(n))
+}
+
+// Script returns the assembled script
+func (b *ScriptBuilder) Script() []byte {
+	return b.script
+}
+
+// PayToPubKeyHashScript returns the script locking an output to the owner of
+// a public key hash, the script equivalent of TXOutput.PubKeyHash. It is
+// unlocked by <signature> <public key>.
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	return appendPayToPubKeyHash(NewScriptBuilder(), pubKeyHash).Script()
+}
+
+func appendPayToPubKeyHash(b *ScriptBuilder, pubKeyHash []byte) *ScriptBuilder {
+	return b.AddOp(OpDup).AddOp(OpHash160).AddData(pubKeyHash).AddOp(OpEqualVerify).AddOp(OpCheckSig)
+}
+
+// MultiSigScript returns the script locking an output to m signatures of the
+// public keys. It is unlocked by the m signatures in the order of their keys.
+func MultiSigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > maxMultiSigKeys {
+		return nil, fmt.Errorf("%w: %d public keys, expected 1 to %d", ErrInvalidScript, len(pubKeys), maxMultiSigKeys)
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("%w: %d of %d signatures", ErrInvalidScript, m, len(pubKeys))
+	}
+
+	b := NewScriptBuilder().AddInt(int64(m))
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+	return b.AddInt(int64(len(pubKeys))).AddOp(OpCheckMultiSig).Script(), nil
+}
+
+// LockTimeScript returns the script locking an output to the owner of a
+// public key hash until a lock time, a block height or a timestamp as for
+// Transaction.LockTime. It is unlocked by <signature> <public key> in a
+// transaction whose lock time has reached it.
+func LockTimeScript(lockTime uint32, pubKeyHash []byte) []byte {
+	b := NewScriptBuilder().AddInt(int64(lockTime)).AddOp(OpCheckLockTimeVerify).AddOp(OpDrop)
+	return appendPayToPubKeyHash(b, pubKeyHash).Script()
+}
+
+// HashLockScript returns the script locking an output to the owner of a
+// public key hash who also knows the preimage of a SHA-256 hash. It is
+// unlocked by <signature> <public key> <preimage>.
+func HashLockScript(hash, pubKeyHash []byte) []byte {
+	b := NewScriptBuilder().AddOp(OpSHA256).AddData(hash).AddOp(OpEqualVerify)
+	return appendPayToPubKeyHash(b, pubKeyHash).Script()
+}
+
+// PayToScriptHashScript returns the script locking an output to the hash of a
+// redeem script. It is unlocked by the unlocking script of the redeem script
+// followed by a push of the redeem script itself.
+func PayToScriptHashScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OpHash160).AddData(scriptHash).AddOp(OpEqual).Script()
+}
+
+// isPayToScriptHash reports whether script is exactly PayToScriptHashScript
+// of a 20-byte hash
+func isPayToScriptHash(script []byte) bool {
+	return len(script) == pubKeyHashLen+3 && script[0] == OpHash160 &&
+		script[1] == pubKeyHashLen && script[len(script)-1] == OpEqual
+}
+
+// encodeScriptNum encodes n as a little-endian magnitude whose top bit is the sign
+func encodeScriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	magnitude := uint64(n)
+	if negative {
+		magnitude = uint64(-n)
+	}
+
+	var result []byte
+	for magnitude > 0 {
+		result = append(result, byte(magnitude))
+		magnitude >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+// decodeScriptNum decodes a number of at most maxLen bytes in its shortest encoding
+func decodeScriptNum(data []byte, maxLen int) (int64, error) {
+	if len(data) > maxLen {
+		return 0, fmt.Errorf("%w: number of %d bytes exceeds %d", ErrScriptFailed, len(data), maxLen)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	// The top byte may only be a sign byte if the byte below it needs its top bit
+	last := data[len(data)-1]
+	if last&0x7f == 0 && (len(data) == 1 || data[len(data)-2]&0x80 == 0) {
+		return 0, fmt.Errorf("%w: non-minimal number %x", ErrScriptFailed, data)
+	}
+
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << (8 * i)
+	}
+	if last&0x80 != 0 {
+		n &^= int64(0x80) << (8 * (len(data) - 1))
+		n = -n
+	}
+	return n, nil
+}
+
+// castToBool reports whether a stack item is true, any non-zero value but negative zero
+func castToBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return i != len(data)-1 || b != 0x80
+		}
+	}
+	return false
+}
+
+// sigChecker verifies the signatures and lock times required by the scripts
+// of an input
+type sigChecker struct {
+	tx   transaction.Transaction
+	inID int
+	// subscript is the locking script committed to by the signatures
+	subscript []byte
+	// batch collects the signatures verified later with the rest of the
+	// block, or is nil to verify them immediately
+	batch *signatureBatch
+	// cache holds the signatures already verified, it may be nil
+	cache *SigCache
+}
+
+func (c *sigChecker) checkSig(signature, pubKey []byte) bool {
+	item, ok := signatureItem(c.tx, c.inID, c.subscript, pubKey, signature)
+	return ok && c.verify(item)
+}
+
+// deferSig is checkSig, except that a well-formed Schnorr signature is added
+// to the batch of the checker, if any, and reported valid. This is only sound
+// because an invalid non-empty signature fails the script, whatever it does
+// with the result.
+func (c *sigChecker) deferSig(signature, pubKey []byte) bool {
+	item, ok := signatureItem(c.tx, c.inID, c.subscript, pubKey, signature)
+	if !ok {
+		return false
+	}
+	if c.batch != nil && item.Verifier.Scheme() == keys.SchemeSchnorr && !c.cache.contains(item) {
+		c.batch.add(item, c.tx.ID, c.inID)
+		return true
+	}
+	return c.verify(item)
+}
+
+// verify checks a signature unless the cache of the checker holds it, and
+// caches it if it is valid
+func (c *sigChecker) verify(item keys.BatchItem) bool {
+	if c.cache.contains(item) {
+		return true
+	}
+	if !item.Verifier.Verify(item.Digest, item.Signature) {
+		return false
+	}
+	c.cache.add(item)
+	return true
+}
+
+// checkLockTime checks that the transaction cannot be mined before lockTime
+func (c *sigChecker) checkLockTime(lockTime int64) error {
+	if lockTime < 0 {
+		return fmt.Errorf("%w: negative lock time %d", ErrScriptFailed, lockTime)
+	}
+
+	// Heights and timestamps cannot be compared
+	txLockTime := int64(c.tx.LockTime)
+	if (lockTime < transaction.LockTimeThreshold) != (txLockTime < transaction.LockTimeThreshold) {
+		return fmt.Errorf("%w: lock time %d and transaction lock time %d differ in kind", ErrScriptFailed, lockTime, txLockTime)
+	}
+	if lockTime > txLockTime {
+		return fmt.Errorf("%w: lock time %d not reached by transaction lock time %d", ErrScriptFailed, lockTime, txLockTime)
+	}
+
+	// A final input disables the lock time of the transaction
+	if c.tx.Vin[c.inID].Sequence == transaction.MaxSequence {
+		return fmt.Errorf("%w: final input ignores the lock time", ErrScriptFailed)
+	}
+	return nil
+}
+
+// scriptEngine is the stack machine running scripts
+type scriptEngine struct {
+	stack   [][]byte
+	checker *sigChecker
+}
+
+func (e *scriptEngine) push(item []byte) error {
+	if len(item) > maxElementSize {
+		return fmt.Errorf("%w: item of %d bytes exceeds %d", ErrScriptFailed, len(item), maxElementSize)
+	}
+	if len(e.stack) >= maxStackSize {
+		return fmt.Errorf("%w: stack exceeds %d items", ErrScriptFailed, maxStackSize)
+	}
+	e.stack = append(e.stack, item)
+	return nil
+}
+
+func (e *scriptEngine) pushBool(v bool) error {
+	if v {
+		return e.push([]byte{1})
+	}
+	return e.push(nil)
+}
+
+func (e *scriptEngine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, fmt.Errorf("%w: stack underflow", ErrScriptFailed)
+	}
+	item := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return item, nil
+}
+
+func (e *scriptEngine) popInt() (int64, error) {
+	item, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	return decodeScriptNum(item, maxScriptNumLen)
+}
+
+// popItems pops n items and returns them in the order they were pushed
+func (e *scriptEngine) popItems(n int64) ([][]byte, error) {
+	if n < 0 || n > int64(len(e.stack)) {
+		return nil, fmt.Errorf("%w: stack underflow", ErrScriptFailed)
+	}
+	items := make([][]byte, n)
+	copy(items, e.stack[int64(len(e.stack))-n:])
+	e.stack = e.stack[:int64(len(e.stack))-n]
+	return items, nil
+}
+
+// verify pops the top item and fails unless it is true
+func (e *scriptEngine) verify(op scriptOp) error {
+	item, err := e.pop()
+	if err != nil {
+		return err
+	}
+	if !castToBool(item) {
+		return fmt.Errorf("%w: %s", ErrScriptFailed, op)
+	}
+	return nil
+}
+
+// execute runs the opcodes of a script on the stack
+func (e *scriptEngine) execute(ops []scriptOp) error {
+	for _, op := range ops {
+		if err := e.step(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *scriptEngine) step(op scriptOp) error {
+	switch {
+	case op.code == Op0 || op.code <= OpPushData2:
+		return e.push(op.data)
+	case op.code == Op1Negate:
+		return e.push(encodeScriptNum(-1))
+	case op.code >= Op1 && op.code <= Op16:
+		return e.push(encodeScriptNum(int64(op.code - Op1 + 1)))
+	}
+
+	switch op.code {
+	case OpVerify:
+		return e.verify(op)
+
+	case OpReturn:
+		return fmt.Errorf("%w: RETURN", ErrScriptFailed)
+
+	case OpDrop:
+		_, err := e.pop()
+		return err
+
+	case OpDup:
+		if len(e.stack) == 0 {
+			return fmt.Errorf("%w: stack underflow", ErrScriptFailed)
+		}
+		return e.push(e.stack[len(e.stack)-1])
+
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if err := e.pushBool(bytes.Equal(a, b)); err != nil {
+			return err
+		}
+		if op.code == OpEqualVerify {
+			return e.verify(op)
+		}
+		return nil
+
+	case OpSHA256:
+		item, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(item)
+		return e.push(hash[:])
+
+	case OpHash160:
+		item, err := e.pop()
+		if err != nil {
+			return err
+		}
+		return e.push(HashPubKey(item))
+
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := e.pop()
+		if err != nil {
+			return err
+		}
+		// Only an empty signature may fail, so that signatures can be
+		// verified later in a batch
+		valid := e.checker.deferSig(signature, pubKey)
+		if !valid && len(signature) != 0 {
+			return fmt.Errorf("%w: signature does not match the public key", ErrScriptFailed)
+		}
+		if err := e.pushBool(valid); err != nil {
+			return err
+		}
+		if op.code == OpCheckSigVerify {
+			return e.verify(op)
+		}
+		return nil
+
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		if err := e.checkMultiSig(); err != nil {
+			return err
+		}
+		if op.code == OpCheckMultiSigVerify {
+			return e.verify(op)
+		}
+		return nil
+
+	case OpCheckLockTimeVerify:
+		// The lock time is left on the stack, scripts drop it
+		if len(e.stack) == 0 {
+			return fmt.Errorf("%w: stack underflow", ErrScriptFailed)
+		}
+		lockTime, err := decodeScriptNum(e.stack[len(e.stack)-1], lockTimeNumLen)
+		if err != nil {
+			return err
+		}
+		return e.checker.checkLockTime(lockTime)
+	}
+
+	return fmt.Errorf("%w: %s", ErrInvalidScript, op)
+}
+
+// checkMultiSig pops <signatures...> m <public keys...> n and pushes whether
+// the m signatures match m of the n keys, in the same order
+func (e *scriptEngine) checkMultiSig() error {
+	n, err := e.popInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > maxMultiSigKeys {
+		return fmt.Errorf("%w: %d public keys", ErrScriptFailed, n)
+	}
+	pubKeys, err := e.popItems(n)
+	if err != nil {
+		return err
+	}
+
+	m, err := e.popInt()
+	if err != nil {
+		return err
+	}
+	if m < 0 || m > n {
+		return fmt.Errorf("%w: %d of %d signatures", ErrScriptFailed, m, n)
+	}
+	signatures, err := e.popItems(m)
+	if err != nil {
+		return err
+	}
+
+	// Each signature is matched against the keys following the previous match
+	key := 0
+	for _, signature := range signatures {
+		for key < len(pubKeys) && !e.checker.checkSig(signature, pubKeys[key]) {
+			key++
+		}
+		if key == len(pubKeys) {
+			return e.pushBool(false)
+		}
+		key++
+	}
+	return e.pushBool(true)
+}
+
+// VerifyScript checks that the unlocking script of input inID of tx satisfies
+// the locking script of the output it spends. The unlocking script may only
+// push data, the stack it leaves is the input of the locking script, which
+// must leave true on top. When the locking script is a PayToScriptHashScript,
+// the last item pushed by the unlocking script is also run as the redeem
+// script on the rest of the items, and signatures commit to it.
+func VerifyScript(unlocking, locking []byte, tx transaction.Transaction, inID int) error {
+	return verifyScript(unlocking, locking, &sigChecker{tx: tx, inID: inID, subscript: locking})
+}
+
+func verifyScript(unlocking, locking []byte, checker *sigChecker) error {
+	unlockingOps, err := parseScript(unlocking)
+	if err != nil {
+		return err
+	}
+	for _, op := range unlockingOps {
+		if !op.isPush() {
+			return fmt.Errorf("%w: unlocking script runs %s", ErrInvalidScript, op)
+		}
+	}
+	lockingOps, err := parseScript(locking)
+	if err != nil {
+		return err
+	}
+
+	engine := &scriptEngine{checker: checker}
+	if err := engine.execute(unlockingOps); err != nil {
+		return err
+	}
+	unlocked := append([][]byte(nil), engine.stack...)
+	if err := engine.execute(lockingOps); err != nil {
+		return err
+	}
+	if err := engine.checkResult(); err != nil {
+		return err
+	}
+	if !isPayToScriptHash(locking) {
+		return nil
+	}
+
+	// The hash matched the redeem script, which must now be satisfied
+	if len(unlocked) == 0 {
+		return fmt.Errorf("%w: missing redeem script", ErrScriptFailed)
+	}
+	redeemScript := unlocked[len(unlocked)-1]
+	redeemOps, err := parseScript(redeemScript)
+	if err != nil {
+		return err
+	}
+
+	checker.subscript = redeemScript
+	engine = &scriptEngine{stack: unlocked[:len(unlocked)-1], checker: checker}
+	if err := engine.execute(redeemOps); err != nil {
+		return err
+	}
+	return engine.checkResult()
+}
+
+// checkResult fails unless the script left true on top of the stack
+func (e *scriptEngine) checkResult() error {
+	if len(e.stack) == 0 || !castToBool(e.stack[len(e.stack)-1]) {
+		return fmt.Errorf("%w: false on top of the stack", ErrScriptFailed)
+	}
+	return nil
+}
+
+// verifyInput checks that input inID of tx unlocks the output it spends.
+// Outputs locked to a PubKeyHash run the equivalent pay-to-pubkey-hash script
+// with the signature and public key of the input, their signatures commit to
+// the PubKeyHash as before scripts existed. The input is checked with the
+// batch and cache of checker.
+func verifyInput(tx transaction.Transaction, inID int, prevOut transaction.TXOutput, checker *sigChecker) error {
+	checker.tx, checker.inID = tx, inID
+	in := tx.Vin[inID]
+	if len(prevOut.Script) != 0 {
+		if len(in.Signature) != 0 || len(in.PubKey) != 0 {
+			return fmt.Errorf("%w: signature outside of the unlocking script", ErrInvalidScript)
+		}
+		checker.subscript = prevOut.Script
+		return verifyScript(in.Script, prevOut.Script, checker)
+	}
+	if len(in.Script) != 0 {
+		return fmt.Errorf("%w: unlocking script for an output without script", ErrInvalidScript)
+	}
+
+	unlocking := NewScriptBuilder().AddData(in.Signature).AddData(in.PubKey).Script()
+	checker.subscript = prevOut.PubKeyHash
+	return verifyScript(unlocking, PayToPubKeyHashScript(prevOut.PubKeyHash), checker)
+}
@@ -0,0 +1,312 @@
+package week3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"blockchain-course/module1/transaction"
+)
+
+// scriptSpendTX builds an unsigned transaction spending the first output of a
+// coinbase locked by script
+func scriptSpendTX(script []byte) (*transaction.Transaction, transaction.TXOutput) {
+	prevOut := transaction.TXOutput{Value: 10, Script: script}
+	prev := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Vout: -1, PubKey: []byte("script")}},
+		Vout: []transaction.TXOutput{prevOut},
+	}
+	prev.ID = prev.Hash()
+
+	tx := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Txid: prev.ID, Vout: 0}},
+		Vout: []transaction.TXOutput{{Value: 10, PubKeyHash: []byte("someone")}},
+	}
+	return tx, prevOut
+}
+
+func signInput(t *testing.T, tx *transaction.Transaction, wallet *Wallet, prevOut transaction.TXOutput) []byte {
+	t.Helper()
+	signature, err := SignInput(*tx, 0, wallet.PrivateKey, prevOut, transaction.SigHashAll)
+	if err != nil {
+		t.Fatalf("Failed to sign input: %v", err)
+	}
+	return signature
+}
+
+func TestScriptNum(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 127, 128, -128, 255, 256, 1 << 31, transaction.MaxSequence} {
+		decoded, err := decodeScriptNum(encodeScriptNum(n), lockTimeNumLen)
+		if err != nil || decoded != n {
+			t.Errorf("Round trip of %d gave %d, %v", n, decoded, err)
+		}
+	}
+
+	if _, err := decodeScriptNum([]byte{0x01, 0x00}, maxScriptNumLen); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected non-minimal number to fail, got %v", err)
+	}
+	if castToBool([]byte{0x00, 0x80}) {
+		t.Error("Negative zero should be false")
+	}
+}
+
+func TestPayToPubKeyHashScript(t *testing.T) {
+	alice := newWallet(t)
+	bob := newWallet(t)
+	tx, prevOut := scriptSpendTX(PayToPubKeyHashScript(HashPubKey(alice.PublicKey)))
+
+	signature := signInput(t, tx, alice, prevOut)
+	unlocking := NewScriptBuilder().AddData(signature).AddData(alice.PublicKey).Script()
+	if err := VerifyScript(unlocking, prevOut.Script, *tx, 0); err != nil {
+		t.Errorf("Valid unlocking script failed: %v", err)
+	}
+
+	// Bob signs with a key that does not hash to the locked hash
+	bobSignature := signInput(t, tx, bob, prevOut)
+	unlocking = NewScriptBuilder().AddData(bobSignature).AddData(bob.PublicKey).Script()
+	if err := VerifyScript(unlocking, prevOut.Script, *tx, 0); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for the wrong key, got %v", err)
+	}
+
+	// The signature commits to the outputs
+	unlocking = NewScriptBuilder().AddData(signature).AddData(alice.PublicKey).Script()
+	tx.Vout[0].Value = 9
+	if err := VerifyScript(unlocking, prevOut.Script, *tx, 0); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed after tampering, got %v", err)
+	}
+}
+
+func TestMultiSigScript(t *testing.T) {
+	wallets := []*Wallet{newWallet(t), newWallet(t), newWallet(t)}
+	pubKeys := [][]byte{wallets[0].PublicKey, wallets[1].PublicKey, wallets[2].PublicKey}
+	script, err := MultiSigScript(2, pubKeys)
+	if err != nil {
+		t.Fatalf("Failed to build multisig script: %v", err)
+	}
+	tx, prevOut := scriptSpendTX(script)
+
+	sigs := make([][]byte, len(wallets))
+	for i, wallet := range wallets {
+		sigs[i] = signInput(t, tx, wallet, prevOut)
+	}
+
+	unlock := func(signatures ...[]byte) error {
+		b := NewScriptBuilder()
+		for _, signature := range signatures {
+			b.AddData(signature)
+		}
+		return VerifyScript(b.Script(), script, *tx, 0)
+	}
+
+	if err := unlock(sigs[0], sigs[2]); err != nil {
+		t.Errorf("Two signatures in key order failed: %v", err)
+	}
+	if err := unlock(sigs[2], sigs[0]); err == nil {
+		t.Error("Signatures out of key order should fail")
+	}
+	if err := unlock(sigs[1]); err == nil {
+		t.Error("A single signature should not unlock a 2 of 3 script")
+	}
+	if err := unlock(sigs[1], sigs[1]); err == nil {
+		t.Error("A signature should only count once")
+	}
+
+	if _, err := MultiSigScript(4, pubKeys); !errors.Is(err, ErrInvalidScript) {
+		t.Errorf("Expected ErrInvalidScript for 4 of 3, got %v", err)
+	}
+}
+
+func TestLockTimeScript(t *testing.T) {
+	alice := newWallet(t)
+	tx, prevOut := scriptSpendTX(LockTimeScript(100, HashPubKey(alice.PublicKey)))
+
+	verify := func(lockTime, sequence uint32) error {
+		tx.LockTime = lockTime
+		tx.Vin[0].Sequence = sequence
+		signature := signInput(t, tx, alice, prevOut)
+		unlocking := NewScriptBuilder().AddData(signature).AddData(alice.PublicKey).Script()
+		return VerifyScript(unlocking, prevOut.Script, *tx, 0)
+	}
+
+	if err := verify(99, 0); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed before the lock time, got %v", err)
+	}
+	if err := verify(100, 0); err != nil {
+		t.Errorf("Spending at the lock time failed: %v", err)
+	}
+	if err := verify(transaction.LockTimeThreshold, 0); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for a timestamp lock time, got %v", err)
+	}
+	if err := verify(100, transaction.MaxSequence); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for a final input, got %v", err)
+	}
+}
+
+func TestHashLockScript(t *testing.T) {
+	alice := newWallet(t)
+	preimage := []byte("secret")
+	hash := sha256.Sum256(preimage)
+	tx, prevOut := scriptSpendTX(HashLockScript(hash[:], HashPubKey(alice.PublicKey)))
+	signature := signInput(t, tx, alice, prevOut)
+
+	unlocking := NewScriptBuilder().AddData(signature).AddData(alice.PublicKey).AddData(preimage).Script()
+	if err := VerifyScript(unlocking, prevOut.Script, *tx, 0); err != nil {
+		t.Errorf("Valid preimage failed: %v", err)
+	}
+
+	unlocking = NewScriptBuilder().AddData(signature).AddData(alice.PublicKey).AddData([]byte("guess")).Script()
+	if err := VerifyScript(unlocking, prevOut.Script, *tx, 0); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for a wrong preimage, got %v", err)
+	}
+}
+
+func TestVerifyScriptErrors(t *testing.T) {
+	tx, _ := scriptSpendTX(nil)
+
+	// Unlocking scripts may only push data
+	if err := VerifyScript([]byte{OpDup}, []byte{Op1}, *tx, 0); !errors.Is(err, ErrInvalidScript) {
+		t.Errorf("Expected ErrInvalidScript for a non-push unlocking script, got %v", err)
+	}
+	if err := VerifyScript(nil, []byte{0x05, 0x01}, *tx, 0); !errors.Is(err, ErrInvalidScript) {
+		t.Errorf("Expected ErrInvalidScript for a truncated push, got %v", err)
+	}
+	if err := VerifyScript(nil, []byte{OpReturn}, *tx, 0); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for RETURN, got %v", err)
+	}
+	if err := VerifyScript([]byte{Op1}, []byte{Op0, OpEqual}, *tx, 0); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for false on top, got %v", err)
+	}
+	if err := VerifyScript(nil, []byte{OpDrop}, *tx, 0); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for stack underflow, got %v", err)
+	}
+
+	disassembly, err := DisassembleScript(PayToPubKeyHashScript([]byte{0xab, 0xcd}))
+	if err != nil || disassembly != "DUP HASH160 abcd EQUALVERIFY CHECKSIG" {
+		t.Errorf("Unexpected disassembly %q, %v", disassembly, err)
+	}
+}
+
+func TestCheckSigNullFail(t *testing.T) {
+	alice := newWallet(t)
+	bob := newWallet(t)
+
+	// The script succeeds whatever CHECKSIG returns, unless it fails
+	locking := NewScriptBuilder().AddData(alice.PublicKey).AddOp(OpCheckSig).AddOp(OpDrop).AddOp(Op1).Script()
+	tx, prevOut := scriptSpendTX(locking)
+
+	unlocking := NewScriptBuilder().AddData(nil).Script()
+	if err := VerifyScript(unlocking, locking, *tx, 0); err != nil {
+		t.Errorf("Empty signature should only push false, got %v", err)
+	}
+
+	unlocking = NewScriptBuilder().AddData(signInput(t, tx, bob, prevOut)).Script()
+	if err := VerifyScript(unlocking, locking, *tx, 0); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for an invalid signature, got %v", err)
+	}
+}
+
+func TestValidateScriptSpend(t *testing.T) {
+	alice := newWallet(t)
+	bob := newWallet(t)
+	script, err := MultiSigScript(1, [][]byte{alice.PublicKey, bob.PublicKey})
+	if err != nil {
+		t.Fatalf("Failed to build multisig script: %v", err)
+	}
+
+	utxo := NewUTXOSet()
+	funding := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Vout: -1, PubKey: []byte("funding")}},
+		Vout: []transaction.TXOutput{{Value: 10, Script: script}},
+	}
+	funding.ID = funding.Hash()
+	if err := connectAt(utxo, 0, 0, funding); err != nil {
+		t.Fatalf("Failed to connect funding block: %v", err)
+	}
+
+	tx := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Txid: funding.ID, Vout: 0}},
+		Vout: []transaction.TXOutput{{Value: 10, PubKeyHash: HashPubKey(bob.PublicKey)}},
+	}
+	prevTXs := map[string]transaction.Transaction{}
+	addPrevOutput(prevTXs, tx.Vin[0], funding.Vout[0])
+
+	// SignTransaction leaves script inputs to the caller
+	if err := SignTransaction(tx, bob.PrivateKey, prevTXs); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	if err := CheckSignatures(*tx, prevTXs); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature without unlocking script, got %v", err)
+	}
+
+	signature, err := SignInput(*tx, 0, bob.PrivateKey, funding.Vout[0], transaction.SigHashAll)
+	if err != nil {
+		t.Fatalf("Failed to sign input: %v", err)
+	}
+	tx.Vin[0].Script = NewScriptBuilder().AddData(signature).Script()
+	tx.ID = tx.Hash()
+	if err := connectAt(utxo, 1, 0, coinbaseTX("reward", 10, HashPubKey(alice.PublicKey)), tx); err != nil {
+		t.Errorf("Valid script spend rejected: %v", err)
+	}
+
+	// Outputs cannot be locked twice
+	both := coinbaseTX("both", 10, HashPubKey(alice.PublicKey))
+	both.Vout[0].Script = script
+	both.ID = both.Hash()
+	expectConnectRule(t, connectAt(utxo, 2, 0, both), RuleMalformed)
+}
+
+func TestSigHashTypes(t *testing.T) {
+	alice := newWallet(t)
+	tx, prevOut := scriptSpendTX(PayToPubKeyHashScript(HashPubKey(alice.PublicKey)))
+	tx.Vout = append(tx.Vout, transaction.TXOutput{Value: 1, PubKeyHash: []byte("other")})
+
+	verify := func(signature []byte) error {
+		unlocking := NewScriptBuilder().AddData(signature).AddData(alice.PublicKey).Script()
+		return VerifyScript(unlocking, prevOut.Script, *tx, 0)
+	}
+	sign := func(hashType transaction.SigHashType) []byte {
+		signature, err := SignInput(*tx, 0, alice.PrivateKey, prevOut, hashType)
+		if err != nil {
+			t.Fatalf("Failed to sign with %s: %v", hashType, err)
+		}
+		return signature
+	}
+
+	all := sign(transaction.SigHashAll)
+	single := sign(transaction.SigHashSingle | transaction.SigHashAnyoneCanPay)
+	none := sign(transaction.SigHashNone)
+
+	// Others add an input and change the second output
+	tx.Vin = append(tx.Vin, transaction.TXInput{Txid: []byte("another"), Vout: 0})
+	tx.Vout[1].Value = 2
+	if err := verify(all); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for SigHashAll, got %v", err)
+	}
+	if err := verify(single); err != nil {
+		t.Errorf("SigHashSingle|SigHashAnyoneCanPay should allow the changes: %v", err)
+	}
+	if err := verify(none); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for SigHashNone with a new input, got %v", err)
+	}
+
+	// The hash type is read from the signature, changing it breaks the signature
+	tampered := bytes.Clone(single)
+	tampered[len(tampered)-1] = byte(transaction.SigHashNone | transaction.SigHashAnyoneCanPay)
+	if err := verify(tampered); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for a changed hash type, got %v", err)
+	}
+	tampered[len(tampered)-1] = 0x42
+	if err := verify(tampered); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("Expected ErrScriptFailed for an invalid hash type, got %v", err)
+	}
+
+	// SigHashSingle needs an output of the same index
+	if _, err := SignInput(*tx, 1, alice.PrivateKey, prevOut, transaction.SigHashSingle); err != nil {
+		t.Errorf("Input 1 has an output: %v", err)
+	}
+	tx.Vout = tx.Vout[:1]
+	if _, err := SignInput(*tx, 1, alice.PrivateKey, prevOut, transaction.SigHashSingle); !errors.Is(err, transaction.ErrSigHashSingle) {
+		t.Errorf("Expected ErrSigHashSingle, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package week3
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	"blockchain-course/module1/keys"
+)
+
+// DefaultSigCacheSize is the number of signatures remembered by the cache of a UTXOSet
+const DefaultSigCacheSize = 50000
+
+// SigCache remembers signatures found valid, so that the signatures of a
+// transaction checked in the mempool are not verified again when it is mined.
+// A signature is cached with the digest it signs and its public key, which
+// makes it valid in any context. A nil SigCache caches nothing.
+type SigCache struct {
+	mu         sync.RWMutex
+	entries    map[[sha256.Size]byte]struct{}
+	maxEntries int
+}
+
+// NewSigCache creates a SigCache holding at most maxEntries signatures
+func NewSigCache(maxEntries int) *SigCache {
+	return &SigCache{
+		entries:    make(map[[sha256.Size]byte]struct{}),
+		maxEntries: maxEntries,
+	}
+}
+
+// sigCacheKey hashes a signature with its digest and public key
+func sigCacheKey(item keys.BatchItem) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(item.Verifier.Bytes())
+	h.Write(item.Digest)
+	h.Write(item.Signature)
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// contains reports whether the signature of item was found valid before
+func (c *SigCache) contains(item keys.BatchItem) bool {
+	if c == nil {
+		return false
+	}
+	key := sigCacheKey(item)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+// add records that the signature of item is valid. When the cache is full a
+// random signature is evicted, which an attacker cannot aim at the
+// signatures of the transactions it wants verified again.
+func (c *SigCache) add(item keys.BatchItem) {
+	if c == nil || c.maxEntries <= 0 {
+		return
+	}
+	key := sigCacheKey(item)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		// Map iteration starts at a random entry
+		for evicted := range c.entries {
+			delete(c.entries, evicted)
+			break
+		}
+	}
+	c.entries[key] = struct{}{}
+}
+
+// Len returns the number of cached signatures
+func (c *SigCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
@@ -0,0 +1,43 @@
+package week3
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"blockchain-course/module1/keys"
+)
+
+func TestSigCache(t *testing.T) {
+	signer, _ := keys.GenerateKey(keys.SchemeP256)
+	var items []keys.BatchItem
+	for i := 0; i < 3; i++ {
+		digest := sha256.Sum256([]byte{byte(i)})
+		signature, _ := signer.Sign(digest[:])
+		items = append(items, keys.BatchItem{Verifier: signer.Public(), Digest: digest[:], Signature: signature})
+	}
+
+	cache := NewSigCache(2)
+	cache.add(items[0])
+	if !cache.contains(items[0]) || cache.contains(items[1]) {
+		t.Error("Cache should only hold the added signature")
+	}
+
+	// The same signature of another digest is another entry
+	other := items[0]
+	other.Digest = items[1].Digest
+	if cache.contains(other) {
+		t.Error("Cache should not hold the signature for another digest")
+	}
+
+	cache.add(items[1])
+	cache.add(items[2])
+	if cache.Len() != 2 || !cache.contains(items[2]) {
+		t.Errorf("Full cache should evict a signature to hold the new one, %d cached", cache.Len())
+	}
+
+	var disabled *SigCache
+	disabled.add(items[0])
+	if disabled.contains(items[0]) || disabled.Len() != 0 {
+		t.Error("Nil cache should cache nothing")
+	}
+}
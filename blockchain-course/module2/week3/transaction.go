@@ -2,36 +2,55 @@ package week3
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"math/big"
 
+	"blockchain-course/module1/keys"
 	"blockchain-course/module1/transaction"
 )
 
+var (
+	// ErrInsufficientFunds is returned when the spendable outputs of a wallet do not cover a payment
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrUnknownAddress is returned when no wallet holds the key of an address
+	ErrUnknownAddress = errors.New("unknown address")
+	// ErrInvalidAddress is returned for addresses that do not decode to a public key hash
+	ErrInvalidAddress = errors.New("invalid address")
+	// ErrMissingPrevTx is returned when the output spent by an input is not provided
+	ErrMissingPrevTx = transaction.ErrMissingPrevTx
+	// ErrInvalidSignature is returned when an input is not signed by the owner of the spent output
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrInvalidAmount is returned for payment amounts or fees out of range
+	ErrInvalidAmount = errors.New("invalid amount")
+	// ErrTransactionNotFound is returned when no block of the chain holds a transaction
+	ErrTransactionNotFound = errors.New("transaction not found")
+)
+
 // Transaction represents a transaction in the blockchain
 type Transaction struct {
-	ID   []byte
-	Vin  []TXInput
-	Vout []TXOutput
+	ID       []byte
+	Vin      []TXInput
+	Vout     []TXOutput
+	LockTime uint32
 }
 
 // TXOutput represents a transaction output
 type TXOutput struct {
 	Value      int
 	PubKeyHash []byte
+	Script     []byte
 }
 
-// Lock signs the output
-func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+// Lock locks the output to address, or returns ErrInvalidAddress leaving the output unchanged
+func (out *TXOutput) Lock(address []byte) error {
+	pubKeyHash, script, err := addressLock(address)
+	if err != nil {
+		return err
+	}
+	out.PubKeyHash, out.Script = pubKeyHash, script
+	return nil
 }
 
 // IsLockedWithKey checks if the output can be used by the owner of the pubkey
@@ -50,17 +69,21 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
-// Serialize returns a serialized Transaction
+// Serialize returns the canonical encoding of the Transaction
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	return SerializeTransaction(tx.toTransaction())
+}
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+// toTransaction converts the Transaction to the shared transaction type
+func (tx Transaction) toTransaction() transaction.Transaction {
+	converted := transaction.Transaction{ID: tx.ID, LockTime: tx.LockTime}
+	for _, in := range tx.Vin {
+		converted.Vin = append(converted.Vin, transaction.TXInput(in))
 	}
-
-	return encoded.Bytes()
+	for _, out := range tx.Vout {
+		converted.Vout = append(converted.Vout, transaction.TXOutput(out))
+	}
+	return converted
 }
 
 // IsCoinbase checks whether the transaction is coinbase
@@ -74,6 +97,8 @@ type TXInput struct {
 	Vout      int
 	Signature []byte
 	PubKey    []byte
+	Sequence  uint32
+	Script    []byte
 }
 
 // UsesKey checks whether the address initiated the transaction
@@ -83,87 +108,151 @@ func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
 }
 
 // NewTransaction creates a new transaction
-func NewTransaction(from, to string, amount int, bc *Blockchain) *transaction.Transaction {
-	var inputs []transaction.TXInput
-	var outputs []transaction.TXOutput
+func NewTransaction(wallets *Wallets, from, to string, amount int, bc *Blockchain) (*transaction.Transaction, error) {
+	return NewTransactionWithFee(wallets, from, to, amount, 0, bc)
+}
 
-	// Get wallet for the sender
-	wallets, err := NewWallets()
+// NewTransactionWithFee creates a new transaction leaving fee to the miner,
+// signed with the key of from found in wallets, which must be unlocked
+func NewTransactionWithFee(wallets *Wallets, from, to string, amount, fee int, bc *Blockchain) (*transaction.Transaction, error) {
+	wallet, err := wallets.GetWallet(from)
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
-		return nil
+		return nil, err
 	}
-	wallet := wallets.GetWallet(from)
+
+	return NewTransactionFromWallet(wallet, to, amount, fee, bc)
+}
+
+// NewTransactionFromWallet creates a new transaction spending the outputs of
+// wallet, which receives the change
+func NewTransactionFromWallet(wallet *Wallet, to string, amount, fee int, bc *Blockchain) (*transaction.Transaction, error) {
+	var outputs []transaction.TXOutput
+
+	if err := checkAmount(amount, fee); err != nil {
+		return nil, err
+	}
+	if !ValidateAddress(to) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, to)
+	}
+	from := string(wallet.GetAddress())
 	pubKeyHash := HashPubKey(wallet.PublicKey)
 
 	// Find spendable outputs
-	acc, validOutputs := bc.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs := bc.FindSpendableOutputs(pubKeyHash, amount+fee)
 
-	if acc < amount {
-		fmt.Printf("Error: Not enough funds\n")
-		return nil
+	if acc < amount+fee {
+		return nil, fmt.Errorf("%w: %s has %d spendable, needs %d", ErrInsufficientFunds, from, acc, amount+fee)
+	}
+
+	inputs, err := spendingInputs(validOutputs, wallet.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build a list of outputs
+	payment, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+	outputs = append(outputs, *payment)
+	if acc > amount+fee {
+		change, err := NewTXOutput(acc-amount-fee, from)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *change)
+	}
+
+	tx := transaction.Transaction{Vin: inputs, Vout: outputs}
+	if err := bc.SignTransaction(&tx, wallet.PrivateKey); err != nil {
+		return nil, err
+	}
+	// The ID commits to the signatures
+	tx.ID = tx.Hash()
+
+	return &tx, nil
+}
+
+// checkAmount checks that amount is positive, fee is not negative and that
+// together they do not exceed transaction.MaxMoney
+func checkAmount(amount, fee int) error {
+	if amount <= 0 || amount > transaction.MaxMoney {
+		return fmt.Errorf("%w: amount %d is not in [1, %d]", ErrInvalidAmount, amount, transaction.MaxMoney)
+	}
+	if fee < 0 || fee > transaction.MaxMoney-amount {
+		return fmt.Errorf("%w: fee %d is negative or with amount %d exceeds %d", ErrInvalidAmount, fee, amount, transaction.MaxMoney)
 	}
+	return nil
+}
 
-	// Build a list of inputs
+// spendingInputs returns the inputs spending the outputs found by
+// FindSpendableOutputs, carrying pubKey
+func spendingInputs(validOutputs map[string][]int, pubKey []byte) ([]transaction.TXInput, error) {
+	var inputs []transaction.TXInput
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
 		if err != nil {
-			fmt.Printf("Error: %s\n", err)
-			return nil
+			return nil, err
 		}
 
 		for _, out := range outs {
-			input := transaction.TXInput{txID, out, nil, wallet.PublicKey}
-			inputs = append(inputs, input)
+			inputs = append(inputs, transaction.TXInput{Txid: txID, Vout: out, PubKey: pubKey})
 		}
 	}
+	return inputs, nil
+}
 
-	// Build a list of outputs
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
-	}
-
-	tx := transaction.Transaction{nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	bc.SignTransaction(&tx, wallet.PrivateKey)
-
-	return &tx
+// NewCoinbaseTX creates a new coinbase transaction paying the initial subsidy
+func NewCoinbaseTX(to, data string) (*transaction.Transaction, error) {
+	return NewCoinbaseTXWithReward(to, data, transaction.Subsidy)
 }
 
-// NewCoinbaseTX creates a new coinbase transaction
-func NewCoinbaseTX(to, data string) *transaction.Transaction {
+// NewCoinbaseTXWithReward creates a coinbase transaction claiming reward, the
+// block subsidy plus the fees of the block
+func NewCoinbaseTXWithReward(to, data string, reward int) (*transaction.Transaction, error) {
 	if data == "" {
 		data = fmt.Sprintf("Reward to \"%s\"", to)
 	}
 
-	txin := transaction.TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(10, to)
-	tx := transaction.Transaction{nil, []transaction.TXInput{txin}, []transaction.TXOutput{*txout}}
+	txin := transaction.TXInput{Txid: []byte{}, Vout: -1, PubKey: []byte(data)}
+	txout, err := NewTXOutput(reward, to)
+	if err != nil {
+		return nil, err
+	}
+	tx := transaction.Transaction{Vin: []transaction.TXInput{txin}, Vout: []transaction.TXOutput{*txout}}
 	tx.ID = tx.Hash()
 
-	return &tx
+	return &tx, nil
 }
 
-// NewTXOutput creates a new TXOutput
-func NewTXOutput(value int, address string) *transaction.TXOutput {
-	txo := &transaction.TXOutput{value, nil}
-	txo.Lock([]byte(address))
+// NewTXOutput creates a new TXOutput paying address, or returns
+// ErrInvalidAddress if address does not decode
+func NewTXOutput(value int, address string) (*transaction.TXOutput, error) {
+	pubKeyHash, script, err := addressLock([]byte(address))
+	if err != nil {
+		return nil, err
+	}
 
-	return txo
+	return &transaction.TXOutput{Value: value, PubKeyHash: pubKeyHash, Script: script}, nil
 }
 
-// SerializeTransaction serializes a Transaction
-func SerializeTransaction(tx transaction.Transaction) []byte {
-	var encoded bytes.Buffer
-
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+// addressLock returns the lock of the outputs paying address: its public key
+// hash, or the script paying its script hash for multisig addresses. Only the
+// addresses accepted by ValidateAddress have a lock.
+func addressLock(address []byte) (pubKeyHash, script []byte, err error) {
+	if !ValidateAddress(string(address)) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+	addressVersion, hash, _ := Base58CheckDecode(address)
+	if addressVersion == scriptHashVersion {
+		return nil, PayToScriptHashScript(hash), nil
 	}
+	return hash, nil, nil
+}
 
-	return encoded.Bytes()
+// SerializeTransaction returns the canonical encoding of a Transaction
+func SerializeTransaction(tx transaction.Transaction) []byte {
+	return tx.Serialize()
 }
 
 // IsCoinbaseTransaction checks whether the transaction is coinbase
@@ -171,98 +260,135 @@ func IsCoinbaseTransaction(tx transaction.Transaction) bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-// SignTransaction signs each input of a Transaction
-func SignTransaction(tx *transaction.Transaction, privKey ecdsa.PrivateKey, prevTXs map[string]transaction.Transaction) {
+// SignTransaction signs each input of a Transaction spending an output
+// locked to a PubKeyHash with SigHashAll. Inputs spending an output locked by
+// a script are left to the caller, who builds their unlocking script with
+// SignInput. prevTXs must hold the outputs spent by the inputs.
+func SignTransaction(tx *transaction.Transaction, privKey keys.Signer, prevTXs map[string]transaction.Transaction) error {
 	if IsCoinbaseTransaction(*tx) {
-		return
+		return nil
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			fmt.Printf("Error: Previous transaction is not correct\n")
-			return
-		}
+	if err := checkPrevOutputs(*tx, prevTXs); err != nil {
+		return err
 	}
 
-	txCopy := TrimmedCopy(*tx)
-
-	for inID, vin := range txCopy.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+	for inID, vin := range tx.Vin {
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
+		if len(prevOut.Script) != 0 {
+			continue
+		}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+		signature, err := SignInput(*tx, inID, privKey, prevOut, transaction.SigHashAll)
 		if err != nil {
-			fmt.Printf("Error: %s\n", err)
-			return
+			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
-
 		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
 	}
+
+	return nil
 }
 
-// TrimmedCopy creates a trimmed copy of Transaction to be used in signing
-func TrimmedCopy(tx transaction.Transaction) transaction.Transaction {
-	var inputs []transaction.TXInput
-	var outputs []transaction.TXOutput
+// SignInput returns the signature of input inID of tx spending prevOut. The
+// signature commits to the lock of prevOut and to the parts of tx selected by
+// hashType, which is appended to it.
+func SignInput(tx transaction.Transaction, inID int, privKey keys.Signer, prevOut transaction.TXOutput, hashType transaction.SigHashType) ([]byte, error) {
+	return signWithSubscript(tx, inID, privKey, subscript(prevOut), hashType)
+}
 
-	for _, vin := range tx.Vin {
-		inputs = append(inputs, transaction.TXInput{vin.Txid, vin.Vout, nil, nil})
+// signWithSubscript signs input inID of tx spending an output locked by subscript
+func signWithSubscript(tx transaction.Transaction, inID int, privKey keys.Signer, subscript []byte, hashType transaction.SigHashType) ([]byte, error) {
+	hash, err := tx.SignatureHash(inID, subscript, hashType)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, vout := range tx.Vout {
-		outputs = append(outputs, transaction.TXOutput{vout.Value, vout.PubKeyHash})
+	signature, err := privKey.Sign(hash)
+	if err != nil {
+		return nil, err
 	}
+	return append(signature, byte(hashType)), nil
+}
 
-	txCopy := transaction.Transaction{tx.ID, inputs, outputs}
+// subscript returns the lock of an output committed to by the signatures
+// spending it, its script or else its PubKeyHash
+func subscript(out transaction.TXOutput) []byte {
+	if len(out.Script) != 0 {
+		return out.Script
+	}
+	return out.PubKeyHash
+}
+
+// verifySignature checks a signature made by signWithSubscript for input
+// inID of tx spending an output locked by subscript, against a public key of
+// any signature scheme. The last byte of the signature is its hash type.
+func verifySignature(tx transaction.Transaction, inID int, subscript, pubKey, signature []byte) bool {
+	item, ok := signatureItem(tx, inID, subscript, pubKey, signature)
+	return ok && item.Verifier.Verify(item.Digest, item.Signature)
+}
 
-	return txCopy
+// signatureItem parses what verifySignature checks into the digest signed,
+// the public key and the signature without its hash type. ok is false if the
+// key, the signature or its hash type is malformed.
+func signatureItem(tx transaction.Transaction, inID int, subscript, pubKey, signature []byte) (item keys.BatchItem, ok bool) {
+	verifier, err := keys.ParsePublicKey(pubKey)
+	if err != nil || len(signature) != keys.SignatureSize+1 {
+		return keys.BatchItem{}, false
+	}
+
+	hashType := transaction.SigHashType(signature[keys.SignatureSize])
+	hash, err := tx.SignatureHash(inID, subscript, hashType)
+	if err != nil {
+		return keys.BatchItem{}, false
+	}
+	return keys.BatchItem{Verifier: verifier, Digest: hash, Signature: signature[:keys.SignatureSize]}, true
+}
+
+// checkPrevOutputs checks that prevTXs holds the output spent by every input
+func checkPrevOutputs(tx transaction.Transaction, prevTXs map[string]transaction.Transaction) error {
+	for inID, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return fmt.Errorf("%w: input %d spends %x:%d", ErrMissingPrevTx, inID, vin.Txid, vin.Vout)
+		}
+	}
+	return nil
 }
 
 // VerifyTransaction verifies signatures of Transaction inputs
 func VerifyTransaction(tx transaction.Transaction, prevTXs map[string]transaction.Transaction) bool {
+	return CheckSignatures(tx, prevTXs) == nil
+}
+
+// CheckSignatures verifies that every input of a Transaction unlocks the
+// output it spends and returns ErrMissingPrevTx or ErrInvalidSignature for the
+// first failing input
+func CheckSignatures(tx transaction.Transaction, prevTXs map[string]transaction.Transaction) error {
+	return checkSignatures(tx, prevTXs, nil)
+}
+
+// inputError reports the failure of the script of input inID
+func inputError(inID int, err error) error {
+	return fmt.Errorf("%w: input %d: %w", ErrInvalidSignature, inID, err)
+}
+
+// checkSignatures is CheckSignatures, skipping the signatures found in cache
+// and adding the ones it verifies
+func checkSignatures(tx transaction.Transaction, prevTXs map[string]transaction.Transaction, cache *SigCache) error {
 	if IsCoinbaseTransaction(tx) {
-		return true
+		return nil
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			fmt.Printf("Error: Previous transaction is not correct\n")
-			return false
-		}
+	if err := checkPrevOutputs(tx, prevTXs); err != nil {
+		return err
 	}
 
-	txCopy := TrimmedCopy(tx)
-	curve := elliptic.P256()
-
 	for inID, vin := range tx.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-		if ecdsa.Verify(&rawPubKey, []byte(dataToSign), &r, &s) == false {
-			return false
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
+		if err := verifyInput(tx, inID, prevOut, &sigChecker{cache: cache}); err != nil {
+			return inputError(inID, err)
 		}
-		txCopy.Vin[inID].PubKey = nil
 	}
 
-	return true
+	return nil
 }
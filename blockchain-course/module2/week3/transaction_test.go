@@ -1,50 +1,90 @@
 package week3
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
 	"testing"
+
+	"blockchain-course/module1/keys"
+	"blockchain-course/module1/transaction"
+	week2 "blockchain-course/module1/week2"
 )
 
 func TestNewTransaction(t *testing.T) {
 	// Create a new wallet
-	wallet := NewWallet()
+	wallet := newWallet(t)
 	address := wallet.GetAddress()
 
 	// Create a coinbase transaction
-	cbTx := NewCoinbaseTX(string(address), "Test Coinbase")
-
-	if cbTx == nil {
-		t.Error("Failed to create coinbase transaction")
+	cbTx, err := NewCoinbaseTX(string(address), "Test Coinbase")
+	if err != nil {
+		t.Fatalf("Failed to create coinbase transaction: %v", err)
 	}
 
 	if !cbTx.IsCoinbase() {
 		t.Error("Transaction should be coinbase")
 	}
+
+	// Invalid addresses are rejected instead of paying an unspendable output
+	if _, err := NewCoinbaseTX("invalid", ""); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress, got %v", err)
+	}
+	// Well-formed Base58Check with an unknown version or a short hash is invalid too
+	hash := HashPubKey(newWallet(t).PublicKey)
+	for _, address := range [][]byte{
+		Base58CheckEncode(0x09, hash),
+		Base58CheckEncode(addressVersions[keys.SchemeP256], hash[:3]),
+		Base58CheckEncode(scriptHashVersion, hash[:3]),
+		[]byte("invalid"),
+	} {
+		if _, err := NewTXOutput(1, string(address)); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("Expected ErrInvalidAddress for %s, got %v", address, err)
+		}
+		output := TXOutput{Value: 1}
+		if err := output.Lock(address); !errors.Is(err, ErrInvalidAddress) || output.PubKeyHash != nil || output.Script != nil {
+			t.Errorf("Expected ErrInvalidAddress and no lock for %s, got %v", address, err)
+		}
+	}
 }
 
 func TestTXOutputLock(t *testing.T) {
 	// Create a new wallet
-	wallet := NewWallet()
+	wallet := newWallet(t)
 	address := wallet.GetAddress()
 
 	// Create a new TXOutput
-	output := TXOutput{100, nil}
+	output := TXOutput{Value: 100}
 
 	// Lock the output with the address
-	output.Lock(address)
+	if err := output.Lock(address); err != nil {
+		t.Fatalf("Failed to lock output: %v", err)
+	}
 
 	if output.PubKeyHash == nil {
 		t.Error("PubKeyHash should not be nil after locking")
 	}
+
+	locked := output
+	if err := output.Lock([]byte("invalid")); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress, got %v", err)
+	}
+	if !bytes.Equal(output.PubKeyHash, locked.PubKeyHash) || !bytes.Equal(output.Script, locked.Script) {
+		t.Error("Invalid address should leave the output unchanged")
+	}
 }
 
 func TestTXOutputIsLockedWithKey(t *testing.T) {
 	// Create a new wallet
-	wallet := NewWallet()
+	wallet := newWallet(t)
 	pubKeyHash := HashPubKey(wallet.PublicKey)
 
 	// Create a new TXOutput and lock it
-	output := TXOutput{100, nil}
-	output.Lock(wallet.GetAddress())
+	output := TXOutput{Value: 100}
+	if err := output.Lock(wallet.GetAddress()); err != nil {
+		t.Fatalf("Failed to lock output: %v", err)
+	}
 
 	// Check if the output is locked with the correct key
 	if !output.IsLockedWithKey(pubKeyHash) {
@@ -52,7 +92,7 @@ func TestTXOutputIsLockedWithKey(t *testing.T) {
 	}
 
 	// Create another wallet
-	anotherWallet := NewWallet()
+	anotherWallet := newWallet(t)
 	anotherPubKeyHash := HashPubKey(anotherWallet.PublicKey)
 
 	// Check if the output is not locked with another key
@@ -63,11 +103,11 @@ func TestTXOutputIsLockedWithKey(t *testing.T) {
 
 func TestTXInputUsesKey(t *testing.T) {
 	// Create a new wallet
-	wallet := NewWallet()
+	wallet := newWallet(t)
 	pubKeyHash := HashPubKey(wallet.PublicKey)
 
 	// Create a new TXInput
-	input := TXInput{[]byte("txid"), 0, nil, wallet.PublicKey}
+	input := TXInput{Txid: []byte("txid"), Vout: 0, PubKey: wallet.PublicKey}
 
 	// Check if the input uses the correct key
 	if !input.UsesKey(pubKeyHash) {
@@ -75,7 +115,7 @@ func TestTXInputUsesKey(t *testing.T) {
 	}
 
 	// Create another wallet
-	anotherWallet := NewWallet()
+	anotherWallet := newWallet(t)
 	anotherPubKeyHash := HashPubKey(anotherWallet.PublicKey)
 
 	// Check if the input does not use another key
@@ -86,7 +126,7 @@ func TestTXInputUsesKey(t *testing.T) {
 
 func TestTransactionHash(t *testing.T) {
 	// Create a new transaction
-	tx := Transaction{nil, nil, nil}
+	tx := Transaction{}
 	hash := tx.Hash()
 
 	if len(hash) != 32 {
@@ -96,16 +136,175 @@ func TestTransactionHash(t *testing.T) {
 
 func TestTransactionIsCoinbase(t *testing.T) {
 	// Create a coinbase transaction
-	cbTx := NewCoinbaseTX("address", "Test Coinbase")
+	cbTx, err := NewCoinbaseTX(string(newWallet(t).GetAddress()), "Test Coinbase")
+	if err != nil {
+		t.Fatalf("Failed to create coinbase transaction: %v", err)
+	}
 
 	if !cbTx.IsCoinbase() {
 		t.Error("Transaction should be coinbase")
 	}
 
 	// Create a regular transaction
-	tx := Transaction{nil, []TXInput{{[]byte("txid"), 0, nil, nil}}, nil}
+	tx := Transaction{Vin: []TXInput{{Txid: []byte("txid"), Vout: 0}}}
 
 	if tx.IsCoinbase() {
 		t.Error("Transaction should not be coinbase")
 	}
 }
+
+func TestTransactionErrors(t *testing.T) {
+	alice := newWallet(t)
+	bob := newWallet(t)
+	aliceAddress := string(alice.GetAddress())
+	bobAddress := string(bob.GetAddress())
+
+	bc, err := NewBlockchain(week2.NewBlockchain())
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	funding, err := NewCoinbaseTX(aliceAddress, "funding")
+	if err != nil {
+		t.Fatalf("Failed to create coinbase: %v", err)
+	}
+	if err := bc.AddBlockWithTransactions("funding", []*transaction.Transaction{funding}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+
+	if _, err := NewTransactionFromWallet(alice, bobAddress, 11, 0, bc); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("Expected ErrInsufficientFunds, got %v", err)
+	}
+	if _, err := NewTransactionFromWallet(alice, "invalid", 1, 0, bc); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress, got %v", err)
+	}
+	for _, c := range []struct{ amount, fee int }{
+		{0, 0},
+		{-1, 2},
+		{transaction.MaxMoney + 1, 0},
+		{1, -1},
+		{transaction.MaxMoney, 1},
+		{1, math.MaxInt},
+	} {
+		if _, err := NewTransactionFromWallet(alice, bobAddress, c.amount, c.fee, bc); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("Expected ErrInvalidAmount for amount %d and fee %d, got %v", c.amount, c.fee, err)
+		}
+	}
+
+	if found, err := bc.FindTransaction(funding.ID); err != nil || !bytes.Equal(found.ID, funding.ID) {
+		t.Errorf("Failed to find the funding transaction: %v", err)
+	}
+	if _, err := bc.FindTransaction([]byte("unknown")); !errors.Is(err, ErrTransactionNotFound) {
+		t.Errorf("Expected ErrTransactionNotFound, got %v", err)
+	}
+
+	tx, err := NewTransactionFromWallet(alice, bobAddress, 4, 1, bc)
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+
+	// Signing an input whose output is not provided fails
+	unknown := &transaction.Transaction{Vin: []transaction.TXInput{{Txid: []byte("unknown"), Vout: 0}}}
+	if err := bc.SignTransaction(unknown, alice.PrivateKey); !errors.Is(err, ErrMissingPrevTx) {
+		t.Errorf("Expected ErrMissingPrevTx, got %v", err)
+	}
+	outOfRange := map[string]transaction.Transaction{hex.EncodeToString(funding.ID): *funding}
+	tx.Vin[0].Vout = 1
+	if err := SignTransaction(tx, alice.PrivateKey, outOfRange); !errors.Is(err, ErrMissingPrevTx) {
+		t.Errorf("Expected ErrMissingPrevTx for a missing output, got %v", err)
+	}
+	tx.Vin[0].Vout = 0
+
+	if err := CheckSignatures(*tx, outOfRange); err != nil {
+		t.Errorf("Signed transaction should verify: %v", err)
+	}
+	tx.Vout[0].Value = 5
+	if err := CheckSignatures(*tx, outOfRange); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestNewTransactionWithFee(t *testing.T) {
+	wallets, err := NewWallets(WithDataDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Failed to create wallets: %v", err)
+	}
+	from := createWallet(t, wallets)
+	to := string(newWallet(t).GetAddress())
+
+	bc, err := NewBlockchain(week2.NewBlockchain())
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	funding, err := NewCoinbaseTX(from, "funding")
+	if err != nil {
+		t.Fatalf("Failed to create coinbase: %v", err)
+	}
+	if err := bc.AddBlockWithTransactions("funding", []*transaction.Transaction{funding}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+
+	tx, err := NewTransactionWithFee(wallets, from, to, 4, 1, bc)
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if len(tx.Vout) != 2 || tx.Vout[0].Value != 4 || tx.Vout[1].Value != 5 {
+		t.Errorf("Expected a payment of 4 and a change of 5, got %+v", tx.Vout)
+	}
+
+	// The keys of locked wallets cannot sign
+	wallets.ChangePassphrase("", "secret")
+	wallets.Lock()
+	if _, err := NewTransactionWithFee(wallets, from, to, 4, 1, bc); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("Expected ErrWalletLocked, got %v", err)
+	}
+}
+
+func TestSignatureSchemes(t *testing.T) {
+	prevOut := transaction.TXOutput{Value: 10, PubKeyHash: []byte("lock")}
+	tx := transaction.Transaction{
+		Vin:  []transaction.TXInput{{Txid: []byte("txid"), Vout: 0}},
+		Vout: []transaction.TXOutput{{Value: 10, PubKeyHash: []byte("someone")}},
+	}
+
+	var previous *Wallet
+	for _, scheme := range keys.Schemes {
+		wallet, err := NewWalletWithScheme(scheme)
+		if err != nil {
+			t.Fatalf("Failed to create %s wallet: %v", scheme, err)
+		}
+
+		// The scheme is recorded in the address and in the public key of inputs
+		address := string(wallet.GetAddress())
+		if addressScheme, err := AddressScheme(address); err != nil || addressScheme != scheme {
+			t.Errorf("Expected %s address, got %s, %v", scheme, addressScheme, err)
+		}
+		if output, err := NewTXOutput(1, address); err != nil || !ValidateAddress(address) || !bytes.Equal(output.PubKeyHash, HashPubKey(wallet.PublicKey)) {
+			t.Errorf("%s address should lock outputs to the hash of its key", scheme)
+		}
+		if wallet.PublicKey[0] != byte(scheme) {
+			t.Errorf("%s public key should start with its scheme", scheme)
+		}
+
+		// Signatures keep the same length whatever their leading bytes
+		for i := 0; i < 10; i++ {
+			signature, err := SignInput(tx, 0, wallet.PrivateKey, prevOut, transaction.SigHashAll)
+			if err != nil || len(signature) != keys.SignatureSize+1 {
+				t.Fatalf("Failed to sign with %s: %x, %v", scheme, signature, err)
+			}
+			if !verifySignature(tx, 0, prevOut.PubKeyHash, wallet.PublicKey, signature) {
+				t.Fatalf("%s signature failed to verify", scheme)
+			}
+			if previous != nil && verifySignature(tx, 0, prevOut.PubKeyHash, previous.PublicKey, signature) {
+				t.Fatalf("%s signature verified with the key of another wallet", scheme)
+			}
+		}
+		previous = wallet
+	}
+
+	if _, err := AddressScheme(string(Base58CheckEncode(scriptHashVersion, make([]byte, pubKeyHashLen)))); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress for a script hash address, got %v", err)
+	}
+	if ValidateAddress(string(Base58CheckEncode(0x7f, make([]byte, pubKeyHashLen)))) {
+		t.Error("Address of an unknown version should be invalid")
+	}
+}
@@ -0,0 +1,374 @@
+package week3
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week1"
+	week2 "blockchain-course/module1/week2"
+)
+
+// utxoFileVersion is the format of the saved UTXO set, a file with another
+// version is rejected so that the set gets reindexed
+const utxoFileVersion = 3
+
+// undoDepth is the number of most recent blocks whose undo data is kept,
+// bounding the depth of the reorganizations the set can follow
+const undoDepth = 100
+
+var (
+	// ErrOutputNotFound is returned when an input spends an output that is not in the UTXO set
+	ErrOutputNotFound = errors.New("output not found in the UTXO set")
+	// ErrUTXOFileVersion is returned when loading a UTXO set saved in another format
+	ErrUTXOFileVersion = errors.New("unsupported UTXO set file version")
+)
+
+// UTXOSet indexes the unspent outputs of the main chain by outpoint. It is a
+// week2.ChainObserver, so registering it on a blockchain keeps it up to date
+// as blocks are connected and disconnected.
+type UTXOSet struct {
+	mu      sync.RWMutex
+	params  week2.Params
+	tip     []byte
+	height  int64
+	outputs map[string]UnspentOutput
+	// undo holds the outputs spent by each of the last undoDepth connected
+	// blocks so that they can be restored when the block is disconnected
+	undo map[string][]UnspentOutput
+	// undoOrder holds the hashes of the blocks in undo, oldest first
+	undoOrder []string
+	// undoDepth is the number of blocks kept in undo
+	undoDepth int
+	// spent indexes the outpoints found in undo to recognise double spends
+	spent map[string]bool
+	// sigCache holds the signatures verified by block validation and by the
+	// mempool validating against the set
+	sigCache *SigCache
+}
+
+// utxoFile is the content of a saved UTXO set
+type utxoFile struct {
+	Version int
+	Tip     []byte
+	Height  int64
+	Outputs map[string]UnspentOutput
+	Undo    []blockUndo
+}
+
+// blockUndo is the undo data of a block in a saved UTXO set
+type blockUndo struct {
+	Block []byte
+	Spent []UnspentOutput
+}
+
+// NewUTXOSet creates an empty UTXOSet validating blocks with the default consensus parameters
+func NewUTXOSet() *UTXOSet {
+	return NewUTXOSetWithParams(week2.DefaultParams())
+}
+
+// NewUTXOSetWithParams creates an empty UTXOSet validating blocks with custom consensus parameters
+func NewUTXOSetWithParams(params week2.Params) *UTXOSet {
+	return &UTXOSet{
+		params:    params,
+		height:    -1,
+		outputs:   make(map[string]UnspentOutput),
+		undo:      make(map[string][]UnspentOutput),
+		undoDepth: undoDepth,
+		spent:     make(map[string]bool),
+		sigCache:  NewSigCache(DefaultSigCacheSize),
+	}
+}
+
+// Reindex rebuilds the set by connecting every block of the main chain
+func (u *UTXOSet) Reindex(chain *week2.Blockchain) error {
+	u.mu.Lock()
+	u.tip = nil
+	u.height = -1
+	u.outputs = make(map[string]UnspentOutput)
+	u.undo = make(map[string][]UnspentOutput)
+	u.undoOrder = nil
+	u.spent = make(map[string]bool)
+	u.mu.Unlock()
+
+	for height := int64(0); height <= chain.Height(); height++ {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+		if err := u.ConnectBlock(block); err != nil {
+			return fmt.Errorf("block %x at height %d: %w", block.Hash, height, err)
+		}
+	}
+	return nil
+}
+
+// ConnectBlock validates the transactions of the block, then spends the
+// outputs referenced by its inputs and adds its new outputs. A block breaking
+// a rule is rejected with a *ValidationError and leaves the set unchanged.
+func (u *UTXOSet) ConnectBlock(block *week1.Block) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	view, err := validateBlock(block, u)
+	if err != nil {
+		return err
+	}
+
+	for _, utxo := range view.undo {
+		key := outpointKey(utxo.TxID, utxo.Index)
+		delete(u.outputs, key)
+		u.spent[key] = true
+	}
+	for key, utxo := range view.created {
+		u.outputs[key] = utxo
+	}
+	u.addUndo(hex.EncodeToString(block.Hash), view.undo)
+	u.tip = block.Hash
+	u.height = block.Index
+
+	return nil
+}
+
+// addUndo records the outputs spent by a connected block, dropping the undo
+// data of the blocks deeper than undoDepth
+func (u *UTXOSet) addUndo(blockKey string, spent []UnspentOutput) {
+	u.undo[blockKey] = spent
+	u.undoOrder = append(u.undoOrder, blockKey)
+
+	for len(u.undoOrder) > u.undoDepth {
+		oldest := u.undoOrder[0]
+		for _, utxo := range u.undo[oldest] {
+			delete(u.spent, outpointKey(utxo.TxID, utxo.Index))
+		}
+		delete(u.undo, oldest)
+		u.undoOrder = u.undoOrder[1:]
+	}
+}
+
+// DisconnectBlock removes the outputs created by the block and restores the
+// outputs it spent. Blocks deeper than the undo data kept cannot be disconnected.
+func (u *UTXOSet) DisconnectBlock(block *week1.Block) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	blockKey := hex.EncodeToString(block.Hash)
+	undo, ok := u.undo[blockKey]
+	if !ok {
+		return fmt.Errorf("no undo data for block %x", block.Hash)
+	}
+
+	for _, tx := range block.Transactions {
+		for index := range tx.Vout {
+			delete(u.outputs, outpointKey(tx.ID, index))
+		}
+	}
+	for _, utxo := range undo {
+		key := outpointKey(utxo.TxID, utxo.Index)
+		u.outputs[key] = utxo
+		delete(u.spent, key)
+	}
+	delete(u.undo, blockKey)
+	if i := slices.Index(u.undoOrder, blockKey); i >= 0 {
+		u.undoOrder = slices.Delete(u.undoOrder, i, i+1)
+	}
+	u.tip = block.PrevBlockHash
+	u.height = block.Index - 1
+
+	return nil
+}
+
+// Tip returns the hash of the last block connected to the set
+func (u *UTXOSet) Tip() []byte {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return u.tip
+}
+
+// Height returns the height of the last block connected to the set, -1 when empty
+func (u *UTXOSet) Height() int64 {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return u.height
+}
+
+// Params returns the consensus parameters the set validates blocks with
+func (u *UTXOSet) Params() week2.Params {
+	return u.params
+}
+
+// SigCache returns the cache of the signatures verified by block validation,
+// to share with the mempool
+func (u *UTXOSet) SigCache() *SigCache {
+	return u.sigCache
+}
+
+// Count returns the number of unspent outputs
+func (u *UTXOSet) Count() int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return len(u.outputs)
+}
+
+// Get returns the unspent output at the given outpoint
+func (u *UTXOSet) Get(txID []byte, index int) (UnspentOutput, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	utxo, ok := u.outputs[outpointKey(txID, index)]
+	return utxo, ok
+}
+
+// FindUTXO returns the unspent outputs locked with the given public key hash,
+// ordered by transaction ID and output index
+func (u *UTXOSet) FindUTXO(pubKeyHash []byte) []UnspentOutput {
+	return u.findUTXO(func(out transaction.TXOutput) bool {
+		return out.IsLockedWithKey(pubKeyHash)
+	})
+}
+
+// FindScriptUTXO returns the unspent outputs locked to the hash of a redeem
+// script, ordered by transaction ID and output index
+func (u *UTXOSet) FindScriptUTXO(scriptHash []byte) []UnspentOutput {
+	return u.findUTXO(func(out transaction.TXOutput) bool {
+		return IsLockedWithScript(out, scriptHash)
+	})
+}
+
+func (u *UTXOSet) findUTXO(match func(transaction.TXOutput) bool) []UnspentOutput {
+	u.mu.RLock()
+	var utxos []UnspentOutput
+	for _, utxo := range u.outputs {
+		if match(utxo.Output) {
+			utxos = append(utxos, utxo)
+		}
+	}
+	u.mu.RUnlock()
+
+	sort.Slice(utxos, func(i, j int) bool {
+		if c := bytes.Compare(utxos[i].TxID, utxos[j].TxID); c != 0 {
+			return c < 0
+		}
+		return utxos[i].Index < utxos[j].Index
+	})
+	return utxos
+}
+
+// FindSpendableOutputs collects unspent outputs of pubKeyHash until they cover
+// amount, skipping coinbase outputs that cannot be spent in the next block yet
+func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	return u.collectSpendable(u.FindUTXO(pubKeyHash), amount)
+}
+
+// FindSpendableScriptOutputs collects unspent outputs locked to scriptHash
+// until they cover amount, as FindSpendableOutputs
+func (u *UTXOSet) FindSpendableScriptOutputs(scriptHash []byte, amount int) (int, map[string][]int) {
+	return u.collectSpendable(u.FindScriptUTXO(scriptHash), amount)
+}
+
+func (u *UTXOSet) collectSpendable(utxos []UnspentOutput, amount int) (int, map[string][]int) {
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0
+	next := u.Height() + 1
+
+	for _, utxo := range utxos {
+		if accumulated >= amount {
+			break
+		}
+		if !u.params.IsMature(utxo.Coinbase, utxo.Height, next) {
+			continue
+		}
+
+		txID := hex.EncodeToString(utxo.TxID)
+		accumulated += utxo.Output.Value
+		unspentOutputs[txID] = append(unspentOutputs[txID], utxo.Index)
+	}
+
+	return accumulated, unspentOutputs
+}
+
+// Balance returns the sum of the unspent outputs locked with the given public key hash
+func (u *UTXOSet) Balance(pubKeyHash []byte) int {
+	balance := 0
+	for _, utxo := range u.FindUTXO(pubKeyHash) {
+		balance += utxo.Output.Value
+	}
+	return balance
+}
+
+// SaveToFile atomically writes the set to path
+func (u *UTXOSet) SaveToFile(path string) error {
+	u.mu.RLock()
+	content := utxoFile{Version: utxoFileVersion, Tip: u.tip, Height: u.height, Outputs: u.outputs}
+	for _, key := range u.undoOrder {
+		hash, _ := hex.DecodeString(key)
+		content.Undo = append(content.Undo, blockUndo{Block: hash, Spent: u.undo[key]})
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(content)
+	u.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Make the content durable before the rename can replace the old file
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadUTXOSet reads a set saved with SaveToFile, validating blocks with params
+func LoadUTXOSet(path string, params week2.Params) (*UTXOSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var content utxoFile
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&content); err != nil {
+		return nil, err
+	}
+	if content.Version != utxoFileVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUTXOFileVersion, content.Version)
+	}
+
+	u := NewUTXOSetWithParams(params)
+	u.tip = content.Tip
+	u.height = content.Height
+	for key, utxo := range content.Outputs {
+		u.outputs[key] = utxo
+	}
+	for _, undo := range content.Undo {
+		for _, utxo := range undo.Spent {
+			u.spent[outpointKey(utxo.TxID, utxo.Index)] = true
+		}
+		u.addUndo(hex.EncodeToString(undo.Block), undo.Spent)
+	}
+	return u, nil
+}
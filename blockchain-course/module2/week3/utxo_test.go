@@ -0,0 +1,203 @@
+package week3
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week1"
+	week2 "blockchain-course/module1/week2"
+)
+
+// spendTX builds a transaction spending output index of prev, signed by wallet
+func spendTX(wallet *Wallet, prev *transaction.Transaction, index int, outputs ...transaction.TXOutput) *transaction.Transaction {
+	tx := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Txid: prev.ID, Vout: index, PubKey: wallet.PublicKey}},
+		Vout: outputs,
+	}
+
+	prevTXs := make(map[string]transaction.Transaction)
+	addPrevOutput(prevTXs, tx.Vin[0], prev.Vout[index])
+	SignTransaction(tx, wallet.PrivateKey, prevTXs)
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// coinbaseTX builds a coinbase paying value to the owner of pubKeyHash
+func coinbaseTX(data string, value int, pubKeyHash []byte) *transaction.Transaction {
+	tx := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Vout: -1, PubKey: []byte(data)}},
+		Vout: []transaction.TXOutput{{Value: value, PubKeyHash: pubKeyHash}},
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func TestUTXOSetFollowsChain(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := HashPubKey(aliceWallet.PublicKey)
+	bob := HashPubKey(newWallet(t).PublicKey)
+
+	chain := week2.NewBlockchain()
+	bc, err := NewBlockchain(chain)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+
+	coinbase := coinbaseTX("reward", 10, alice)
+	if err := bc.AddBlockWithTransactions("Reward", []*transaction.Transaction{coinbase}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+
+	// Pay Bob and spend the change again in the same block
+	pay := spendTX(aliceWallet, coinbase, 0,
+		transaction.TXOutput{Value: 4, PubKeyHash: bob},
+		transaction.TXOutput{Value: 6, PubKeyHash: alice})
+	change := spendTX(aliceWallet, pay, 1, transaction.TXOutput{Value: 6, PubKeyHash: bob})
+	if err := bc.AddBlockWithTransactions("Payment", []*transaction.Transaction{pay, change}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+
+	if bc.GetBalance(alice) != 0 || bc.GetBalance(bob) != 10 {
+		t.Errorf("Unexpected balances %d and %d", bc.GetBalance(alice), bc.GetBalance(bob))
+	}
+	if bc.UTXO.Count() != 2 {
+		t.Errorf("Expected 2 unspent outputs, got %d", bc.UTXO.Count())
+	}
+
+	acc, outputs := bc.FindSpendableOutputs(bob, 5)
+	if acc < 5 || len(outputs) == 0 {
+		t.Errorf("Expected spendable outputs covering 5, got %d", acc)
+	}
+
+	// A block spending an already spent output is rejected and leaves the set untouched
+	double := spendTX(aliceWallet, coinbase, 0, transaction.TXOutput{Value: 10, PubKeyHash: bob})
+	if err := bc.AddBlockWithTransactions("Double spend", []*transaction.Transaction{double}); !errors.Is(err, week2.ErrInvalidBlock) {
+		t.Errorf("Expected an invalid block, got %v", err)
+	}
+	if bc.Height() != 2 || bc.GetBalance(bob) != 10 {
+		t.Error("Rejected block should not change the chain or the UTXO set")
+	}
+
+	// Reindexing from scratch gives the same set
+	reindexed := NewUTXOSet()
+	if err := reindexed.Reindex(chain); err != nil {
+		t.Fatalf("Failed to reindex: %v", err)
+	}
+	if reindexed.Count() != bc.UTXO.Count() || reindexed.Balance(bob) != 10 {
+		t.Error("Reindexed set should match the maintained set")
+	}
+}
+
+func TestUTXOSetDisconnect(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := HashPubKey(aliceWallet.PublicKey)
+	utxo := NewUTXOSet()
+
+	coinbase := coinbaseTX("reward", 10, alice)
+	first := week2.NewBlockchain()
+	tip, _ := first.TipBlock()
+
+	block1, err := first.MineBlock(tip, "Reward", []*transaction.Transaction{coinbase})
+	if err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+	block2, err := first.MineBlock(block1, "Spend", []*transaction.Transaction{
+		spendTX(aliceWallet, coinbase, 0, transaction.TXOutput{Value: 10, PubKeyHash: []byte("bob")}),
+	})
+	if err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+
+	if err := utxo.ConnectBlock(block1); err != nil {
+		t.Fatalf("Failed to connect block: %v", err)
+	}
+	if err := utxo.ConnectBlock(block2); err != nil {
+		t.Fatalf("Failed to connect block: %v", err)
+	}
+	if utxo.Balance(alice) != 0 {
+		t.Error("Spent output should leave the set")
+	}
+
+	if err := utxo.DisconnectBlock(block2); err != nil {
+		t.Fatalf("Failed to disconnect block: %v", err)
+	}
+	if utxo.Balance(alice) != 10 {
+		t.Error("Disconnecting the spend should restore the output")
+	}
+	if string(utxo.Tip()) != string(block1.Hash) {
+		t.Error("Tip should move back to the parent block")
+	}
+
+	// The set survives a round trip through its file, undo data included
+	path := filepath.Join(t.TempDir(), "utxo.dat")
+	if err := utxo.SaveToFile(path); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	loaded, err := LoadUTXOSet(path, week2.DefaultParams())
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if err := loaded.DisconnectBlock(block1); err != nil {
+		t.Fatalf("Failed to disconnect loaded block: %v", err)
+	}
+	if loaded.Count() != 0 {
+		t.Errorf("Expected an empty set, got %d outputs", loaded.Count())
+	}
+}
+
+func TestUTXOSetUndoDepth(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := HashPubKey(aliceWallet.PublicKey)
+	utxo := NewUTXOSet()
+	utxo.undoDepth = 2
+
+	coinbase := coinbaseTX("reward", 10, alice)
+	chain := week2.NewBlockchain()
+	tip, _ := chain.TipBlock()
+
+	var blocks []*week1.Block
+	for _, txs := range [][]*transaction.Transaction{
+		{coinbase},
+		{spendTX(aliceWallet, coinbase, 0, transaction.TXOutput{Value: 10, PubKeyHash: []byte("bob")})},
+		{coinbaseTX("reward 3", 10, alice)},
+		{coinbaseTX("reward 4", 10, alice)},
+	} {
+		block, err := chain.MineBlock(tip, "Block", txs)
+		if err != nil {
+			t.Fatalf("Failed to mine block: %v", err)
+		}
+		if err := utxo.ConnectBlock(block); err != nil {
+			t.Fatalf("Failed to connect block: %v", err)
+		}
+		blocks = append(blocks, block)
+		tip = block
+	}
+
+	// Only the undo data of the last undoDepth blocks is kept
+	if len(utxo.undo) != 2 || len(utxo.spent) != 0 {
+		t.Errorf("Expected undo data for 2 blocks and no spent outputs, got %d and %d", len(utxo.undo), len(utxo.spent))
+	}
+
+	// and survives a round trip through the file in order
+	path := filepath.Join(t.TempDir(), "utxo.dat")
+	if err := utxo.SaveToFile(path); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	loaded, err := LoadUTXOSet(path, week2.DefaultParams())
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+
+	for _, set := range []*UTXOSet{utxo, loaded} {
+		for _, block := range []*week1.Block{blocks[3], blocks[2]} {
+			if err := set.DisconnectBlock(block); err != nil {
+				t.Fatalf("Failed to disconnect block: %v", err)
+			}
+		}
+		if err := set.DisconnectBlock(blocks[1]); err == nil {
+			t.Error("Disconnecting a block deeper than the undo data should fail")
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package week3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week1"
+	week2 "blockchain-course/module1/week2"
+)
+
+// maxCoinbaseDataLen bounds the free-form data carried by the coinbase input
+const maxCoinbaseDataLen = 100
+
+// ValidationRule identifies a transaction consensus rule
+type ValidationRule int
+
+const (
+	// RuleMalformed rejects transactions without inputs or outputs, with values
+	// out of [0, transaction.MaxMoney] or with malformed output locks
+	RuleMalformed ValidationRule = iota + 1
+	// RuleMissingOutput rejects inputs spending an output that never existed
+	RuleMissingOutput
+	// RuleDoubleSpend rejects inputs spending an output that is already spent
+	RuleDoubleSpend
+	// RuleDuplicateTransaction rejects transactions whose ID has unspent outputs
+	RuleDuplicateTransaction
+	// RuleOutputsExceedInputs rejects transactions creating more value than they spend
+	RuleOutputsExceedInputs
+	// RuleInvalidSignature rejects inputs not signed by the owner of the spent output,
+	// or whose unlocking script does not satisfy its locking script
+	RuleInvalidSignature
+	// RuleMultipleCoinbase rejects blocks with a coinbase that is not the first transaction
+	RuleMultipleCoinbase
+	// RuleOversizedCoinbase rejects coinbase data longer than maxCoinbaseDataLen
+	RuleOversizedCoinbase
+	// RuleExcessiveCoinbase rejects coinbases claiming more than the subsidy plus the fees of the block
+	RuleExcessiveCoinbase
+	// RuleImmatureCoinbase rejects inputs spending a coinbase output before CoinbaseMaturity blocks
+	RuleImmatureCoinbase
+	// RuleNonFinal rejects transactions whose lock time has not passed
+	RuleNonFinal
+)
+
+var ruleNames = map[ValidationRule]string{
+	RuleMalformed:            "malformed transaction",
+	RuleMissingOutput:        "missing output",
+	RuleDoubleSpend:          "double spend",
+	RuleDuplicateTransaction: "duplicate transaction",
+	RuleOutputsExceedInputs:  "outputs exceed inputs",
+	RuleInvalidSignature:     "invalid signature",
+	RuleMultipleCoinbase:     "multiple coinbase",
+	RuleOversizedCoinbase:    "oversized coinbase",
+	RuleExcessiveCoinbase:    "excessive coinbase",
+	RuleImmatureCoinbase:     "immature coinbase",
+	RuleNonFinal:             "non-final transaction",
+}
+
+func (r ValidationRule) String() string {
+	if name, ok := ruleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("rule %d", int(r))
+}
+
+// ValidationError reports the consensus rule broken by a transaction
+type ValidationError struct {
+	Rule   ValidationRule
+	TxID   []byte
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("transaction %x: %s: %s", e.TxID, e.Rule, e.Reason)
+}
+
+func ruleError(rule ValidationRule, tx *transaction.Transaction, format string, args ...interface{}) error {
+	return &ValidationError{Rule: rule, TxID: tx.ID, Reason: fmt.Sprintf(format, args...)}
+}
+
+// blockView stages the changes of a block on top of a UTXO set
+type blockView struct {
+	set     *UTXOSet
+	created map[string]UnspentOutput
+	spent   map[string]bool
+	undo    []UnspentOutput
+}
+
+// lookup returns the output at key if it is unspent in the view
+func (v *blockView) lookup(key string) (UnspentOutput, bool) {
+	if v.spent[key] {
+		return UnspentOutput{}, false
+	}
+	if utxo, ok := v.created[key]; ok {
+		return utxo, true
+	}
+	utxo, ok := v.set.outputs[key]
+	return utxo, ok
+}
+
+// validateBlock checks every transaction of the block against the set and
+// returns the staged changes. Transactions may spend outputs created earlier
+// in the same block. The input scripts are checked concurrently by a
+// verifyPool, and the Schnorr signatures of each of its workers together.
+// The caller must hold the lock of the set.
+func validateBlock(block *week1.Block, set *UTXOSet) (*blockView, error) {
+	view := &blockView{
+		set:     set,
+		created: make(map[string]UnspentOutput),
+		spent:   make(map[string]bool),
+	}
+	pool := newVerifyPool(set.sigCache)
+	defer pool.stop()
+
+	ctx := SpendContext{Height: block.Index, Timestamp: block.Timestamp, Params: set.params}
+	fees := 0
+	for i, tx := range block.Transactions {
+		if !tx.IsFinal(ctx.Height, ctx.Timestamp) {
+			return nil, ruleError(RuleNonFinal, tx, "locked until %d", tx.LockTime)
+		}
+
+		if tx.IsCoinbase() {
+			if i != 0 {
+				return nil, ruleError(RuleMultipleCoinbase, tx, "coinbase at position %d", i)
+			}
+			if len(tx.Vin[0].PubKey) > maxCoinbaseDataLen {
+				return nil, ruleError(RuleOversizedCoinbase, tx, "%d bytes of coinbase data", len(tx.Vin[0].PubKey))
+			}
+		} else {
+			fee, err := view.spend(tx, ctx, pool)
+			if err != nil {
+				return nil, err
+			}
+			if err := pool.failed(); err != nil {
+				return nil, err
+			}
+			var ok bool
+			if fees, ok = addValue(fees, fee); !ok {
+				return nil, ruleError(RuleMalformed, tx, "fees of the block exceed %d", transaction.MaxMoney)
+			}
+		}
+
+		if len(tx.Vout) == 0 {
+			return nil, ruleError(RuleMalformed, tx, "no outputs")
+		}
+		for index, out := range tx.Vout {
+			if err := validateOutput(tx, index, out); err != nil {
+				return nil, err
+			}
+
+			key := outpointKey(tx.ID, index)
+			if _, ok := view.lookup(key); ok {
+				return nil, ruleError(RuleDuplicateTransaction, tx, "output %d is already unspent", index)
+			}
+			view.created[key] = UnspentOutput{
+				TxID:     tx.ID,
+				Index:    index,
+				Output:   out,
+				Height:   block.Index,
+				Coinbase: tx.IsCoinbase(),
+			}
+			delete(view.spent, key)
+		}
+	}
+
+	// The coinbase may claim the subsidy and the fees left by the other transactions
+	if len(block.Transactions) > 0 && block.Transactions[0].IsCoinbase() {
+		coinbase := block.Transactions[0]
+		claimed, err := sumOutputs(coinbase)
+		if err != nil {
+			return nil, err
+		}
+		allowed, ok := addValue(fees, set.params.BlockSubsidy(block.Index))
+		if !ok {
+			return nil, ruleError(RuleExcessiveCoinbase, coinbase, "subsidy and fees exceed %d", transaction.MaxMoney)
+		}
+		if claimed > allowed {
+			return nil, ruleError(RuleExcessiveCoinbase, coinbase, "claims %d, allowed %d", claimed, allowed)
+		}
+	}
+
+	if err := pool.wait(); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// OutputLookup returns the unspent output at an outpoint
+type OutputLookup func(txID []byte, index int) (UnspentOutput, bool)
+
+// SpendContext describes the block a transaction is validated for
+type SpendContext struct {
+	Height    int64
+	Timestamp int64
+	Params    week2.Params
+	// SigCache holds the signatures already verified, it may be nil
+	SigCache *SigCache
+}
+
+// ValidateTransaction checks a regular transaction against the outputs it
+// spends and returns its fee, the value of the inputs not claimed by outputs
+func ValidateTransaction(tx *transaction.Transaction, lookup OutputLookup, ctx SpendContext) (int, error) {
+	return validateTransaction(tx, lookup, ctx, nil)
+}
+
+// validateTransaction is ValidateTransaction, leaving the input scripts to
+// pool when it is not nil
+func validateTransaction(tx *transaction.Transaction, lookup OutputLookup, ctx SpendContext, pool *verifyPool) (int, error) {
+	if !tx.IsFinal(ctx.Height, ctx.Timestamp) {
+		return 0, ruleError(RuleNonFinal, tx, "locked until %d", tx.LockTime)
+	}
+	if tx.IsCoinbase() {
+		return 0, ruleError(RuleMalformed, tx, "coinbase outside of a block")
+	}
+	if len(tx.Vin) == 0 {
+		return 0, ruleError(RuleMalformed, tx, "no inputs")
+	}
+	if len(tx.Vout) == 0 {
+		return 0, ruleError(RuleMalformed, tx, "no outputs")
+	}
+
+	prevTXs := make(map[string]transaction.Transaction)
+	inputs := 0
+	seen := make(map[string]bool)
+
+	for index, in := range tx.Vin {
+		key := outpointKey(in.Txid, in.Vout)
+		if seen[key] {
+			return 0, ruleError(RuleDoubleSpend, tx, "input %d spends %s twice", index, key)
+		}
+		seen[key] = true
+
+		utxo, ok := lookup(in.Txid, in.Vout)
+		if !ok {
+			return 0, ruleError(RuleMissingOutput, tx, "input %d spends unknown output %s", index, key)
+		}
+		if !ctx.Params.IsMature(utxo.Coinbase, utxo.Height, ctx.Height) {
+			return 0, ruleError(RuleImmatureCoinbase, tx, "input %d spends coinbase output %s from height %d at height %d",
+				index, key, utxo.Height, ctx.Height)
+		}
+		out := utxo.Output
+		if len(out.Script) == 0 && !bytes.Equal(HashPubKey(in.PubKey), out.PubKeyHash) {
+			return 0, ruleError(RuleInvalidSignature, tx, "input %d public key does not own %s", index, key)
+		}
+
+		if inputs, ok = addValue(inputs, out.Value); !ok {
+			return 0, ruleError(RuleMalformed, tx, "input values exceed %d", transaction.MaxMoney)
+		}
+		addPrevOutput(prevTXs, in, out)
+	}
+
+	outputs, err := sumOutputs(tx)
+	if err != nil {
+		return 0, err
+	}
+	if outputs > inputs {
+		return 0, ruleError(RuleOutputsExceedInputs, tx, "outputs %d exceed inputs %d", outputs, inputs)
+	}
+
+	if pool != nil {
+		pool.add(tx, prevTXs)
+	} else if err := checkSignatures(*tx, prevTXs, ctx.SigCache); err != nil {
+		return 0, ruleError(RuleInvalidSignature, tx, "%v", err)
+	}
+
+	return inputs - outputs, nil
+}
+
+// addValue returns total + value, ok is false if value is negative or the
+// sum exceeds transaction.MaxMoney
+func addValue(total, value int) (sum int, ok bool) {
+	if value < 0 || value > transaction.MaxMoney || total > transaction.MaxMoney-value {
+		return 0, false
+	}
+	return total + value, true
+}
+
+// sumOutputs validates the outputs of a transaction and returns their total value
+func sumOutputs(tx *transaction.Transaction) (int, error) {
+	total := 0
+	for index, out := range tx.Vout {
+		if err := validateOutput(tx, index, out); err != nil {
+			return 0, err
+		}
+		var ok bool
+		if total, ok = addValue(total, out.Value); !ok {
+			return 0, ruleError(RuleMalformed, tx, "output values exceed %d", transaction.MaxMoney)
+		}
+	}
+	return total, nil
+}
+
+// validateOutput checks the value and the lock of an output
+func validateOutput(tx *transaction.Transaction, index int, out transaction.TXOutput) error {
+	if out.Value < 0 {
+		return ruleError(RuleMalformed, tx, "negative value in output %d", index)
+	}
+	if out.Value > transaction.MaxMoney {
+		return ruleError(RuleMalformed, tx, "value of output %d exceeds %d", index, transaction.MaxMoney)
+	}
+	if len(out.Script) == 0 {
+		return nil
+	}
+	if len(out.PubKeyHash) != 0 {
+		return ruleError(RuleMalformed, tx, "output %d is locked by both a public key hash and a script", index)
+	}
+	if _, err := parseScript(out.Script); err != nil {
+		return ruleError(RuleMalformed, tx, "output %d: %v", index, err)
+	}
+	return nil
+}
+
+// spend validates a regular transaction, marks the outputs it spends as spent
+// and returns its fee. Its input scripts are left to pool.
+func (v *blockView) spend(tx *transaction.Transaction, ctx SpendContext, pool *verifyPool) (int, error) {
+	for index, in := range tx.Vin {
+		key := outpointKey(in.Txid, in.Vout)
+		if v.spent[key] || v.set.spent[key] {
+			return 0, ruleError(RuleDoubleSpend, tx, "input %d spends %s which is already spent", index, key)
+		}
+	}
+
+	lookup := func(txID []byte, index int) (UnspentOutput, bool) {
+		return v.lookup(outpointKey(txID, index))
+	}
+	fee, err := validateTransaction(tx, lookup, ctx, pool)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, in := range tx.Vin {
+		key := outpointKey(in.Txid, in.Vout)
+		if _, ok := v.created[key]; ok {
+			// Created earlier in the block, nothing to restore on disconnect
+			delete(v.created, key)
+		} else {
+			v.undo = append(v.undo, v.set.outputs[key])
+		}
+		v.spent[key] = true
+	}
+	return fee, nil
+}
+
+// addPrevOutput records the output spent by an input in the form expected by
+// SignTransaction and VerifyTransaction. The previous transactions only carry
+// the spent outputs, which is all signing needs.
+func addPrevOutput(prevTXs map[string]transaction.Transaction, in transaction.TXInput, out transaction.TXOutput) {
+	txID := hex.EncodeToString(in.Txid)
+	prevTX := prevTXs[txID]
+	prevTX.ID = in.Txid
+	for len(prevTX.Vout) <= in.Vout {
+		prevTX.Vout = append(prevTX.Vout, transaction.TXOutput{})
+	}
+	prevTX.Vout[in.Vout] = out
+	prevTXs[txID] = prevTX
+}
@@ -0,0 +1,390 @@
+package week3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"blockchain-course/module1/keys"
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week1"
+	week2 "blockchain-course/module1/week2"
+)
+
+// expectRule connects a block with the given transactions and checks the broken rule
+func expectRule(t *testing.T, utxo *UTXOSet, rule ValidationRule, txs ...*transaction.Transaction) {
+	t.Helper()
+
+	block := week1.NewBlockWithTransactions(rule.String(), []byte("parent"), txs)
+	block.SetHash()
+	count := utxo.Count()
+
+	err := utxo.ConnectBlock(block)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected %s, got %v", rule, err)
+	}
+	if validationErr.Rule != rule {
+		t.Errorf("Expected %s, got %s", rule, validationErr)
+	}
+	if utxo.Count() != count {
+		t.Errorf("Rejected block changed the UTXO set")
+	}
+}
+
+func TestValidationRules(t *testing.T) {
+	alice := newWallet(t)
+	bob := newWallet(t)
+	aliceHash := HashPubKey(alice.PublicKey)
+	bobHash := HashPubKey(bob.PublicKey)
+
+	utxo := NewUTXOSet()
+	funding := coinbaseTX("funding", 10, aliceHash)
+	fundingBlock := week1.NewBlockWithTransactions("funding", nil, []*transaction.Transaction{funding})
+	fundingBlock.SetHash()
+	if err := utxo.ConnectBlock(fundingBlock); err != nil {
+		t.Fatalf("Failed to connect funding block: %v", err)
+	}
+
+	pay := spendTX(alice, funding, 0, transaction.TXOutput{Value: 10, PubKeyHash: bobHash})
+	payAgain := spendTX(alice, funding, 0, transaction.TXOutput{Value: 9, PubKeyHash: aliceHash})
+	expectRule(t, utxo, RuleDoubleSpend, pay, payAgain)
+
+	unknown := coinbaseTX("never mined", 10, aliceHash)
+	expectRule(t, utxo, RuleMissingOutput, spendTX(alice, unknown, 0, transaction.TXOutput{Value: 10, PubKeyHash: bobHash}))
+
+	expectRule(t, utxo, RuleOutputsExceedInputs, spendTX(alice, funding, 0, transaction.TXOutput{Value: 11, PubKeyHash: bobHash}))
+
+	// Outputs whose sum wraps around to less than the inputs
+	overflow := spendTX(alice, funding, 0,
+		transaction.TXOutput{Value: math.MaxInt64, PubKeyHash: bobHash},
+		transaction.TXOutput{Value: math.MaxInt64, PubKeyHash: bobHash},
+		transaction.TXOutput{Value: 2, PubKeyHash: bobHash})
+	expectRule(t, utxo, RuleMalformed, overflow)
+	if _, err := ValidateTransaction(overflow, utxo.Get, SpendContext{Height: 1, Params: utxo.Params()}); err == nil {
+		t.Error("Transaction with overflowing outputs should be rejected")
+	}
+	expectRule(t, utxo, RuleMalformed, spendTX(alice, funding, 0, transaction.TXOutput{Value: transaction.MaxMoney + 1, PubKeyHash: bobHash}))
+
+	// Bob cannot spend the output of Alice with his own key
+	expectRule(t, utxo, RuleInvalidSignature, spendTX(bob, funding, 0, transaction.TXOutput{Value: 10, PubKeyHash: bobHash}))
+
+	// Tampering with a signed transaction breaks its signature
+	tampered := spendTX(alice, funding, 0, transaction.TXOutput{Value: 5, PubKeyHash: bobHash})
+	tampered.Vout[0].PubKeyHash = aliceHash
+	tampered.ID = tampered.Hash()
+	expectRule(t, utxo, RuleInvalidSignature, tampered)
+
+	expectRule(t, utxo, RuleMultipleCoinbase, coinbaseTX("first", 10, aliceHash), coinbaseTX("second", 10, aliceHash))
+	expectRule(t, utxo, RuleOversizedCoinbase, coinbaseTX(strings.Repeat("x", maxCoinbaseDataLen+1), 10, aliceHash))
+	expectRule(t, utxo, RuleDuplicateTransaction, funding)
+	expectRule(t, utxo, RuleMalformed, spendTX(alice, funding, 0))
+
+	// The coinbase may only claim the fees on top of the subsidy
+	expectRule(t, utxo, RuleExcessiveCoinbase, coinbaseTX("greedy", 11, aliceHash))
+	wrapping := coinbaseTX("wrapping", math.MaxInt64, aliceHash)
+	wrapping.Vout = append(wrapping.Vout, wrapping.Vout[0], transaction.TXOutput{Value: 2, PubKeyHash: aliceHash})
+	wrapping.ID = wrapping.Hash()
+	expectRule(t, utxo, RuleMalformed, wrapping)
+	// Outputs within bounds whose sum is not
+	for i := range wrapping.Vout[:2] {
+		wrapping.Vout[i].Value = transaction.MaxMoney
+	}
+	wrapping.ID = wrapping.Hash()
+	expectRule(t, utxo, RuleMalformed, wrapping)
+	withFee := spendTX(alice, funding, 0, transaction.TXOutput{Value: 9, PubKeyHash: bobHash})
+	expectRule(t, utxo, RuleExcessiveCoinbase, coinbaseTX("greedy", 12, aliceHash), withFee)
+
+	// A valid payment is accepted, after which spending the output again is a double spend
+	block := week1.NewBlockWithTransactions("payment", fundingBlock.Hash, []*transaction.Transaction{
+		coinbaseTX("fees", 11, aliceHash), withFee,
+	})
+	block.SetHash()
+	if err := utxo.ConnectBlock(block); err != nil {
+		t.Fatalf("Valid payment rejected: %v", err)
+	}
+	expectRule(t, utxo, RuleDoubleSpend, payAgain)
+}
+
+func TestIsValidChecksTransactions(t *testing.T) {
+	alice := newWallet(t)
+	aliceHash := HashPubKey(alice.PublicKey)
+
+	bc, err := NewBlockchain(week2.NewBlockchain())
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+
+	funding := coinbaseTX("funding", 10, aliceHash)
+	if err := bc.AddBlockWithTransactions("funding", []*transaction.Transaction{funding}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+
+	// A block overspending its input is rejected with the broken rule
+	overspend := spendTX(alice, funding, 0, transaction.TXOutput{Value: 20, PubKeyHash: aliceHash})
+	err = bc.AddBlockWithTransactions("overspend", []*transaction.Transaction{overspend})
+	var validationErr *ValidationError
+	if !errors.Is(err, week2.ErrInvalidBlock) || !errors.As(err, &validationErr) || validationErr.Rule != RuleOutputsExceedInputs {
+		t.Errorf("Expected an invalid block overspending its inputs, got %v", err)
+	}
+
+	if !bc.IsValid() {
+		t.Error("Blockchain with valid transactions should be valid")
+	}
+
+	// A chain accepted without the UTXO set can still be checked
+	unchecked := week2.NewBlockchain()
+	if err := unchecked.AddBlockWithTransactions("overspend", []*transaction.Transaction{overspend}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+	if (&Blockchain{Blockchain: unchecked}).IsValid() {
+		t.Error("Blockchain spending a missing output should be invalid")
+	}
+}
+
+// connectAt connects a block at the given height and timestamp
+func connectAt(utxo *UTXOSet, height, timestamp int64, txs ...*transaction.Transaction) error {
+	block := week1.NewBlockWithTransactions("", utxo.Tip(), txs)
+	block.Index = height
+	block.Timestamp = timestamp
+	block.SetHash()
+	return utxo.ConnectBlock(block)
+}
+
+// lockedSpendTX builds a transaction spending output index of prev with a lock time and sequence
+func lockedSpendTX(wallet *Wallet, prev *transaction.Transaction, index int, lockTime, sequence uint32) *transaction.Transaction {
+	tx := &transaction.Transaction{
+		Vin:      []transaction.TXInput{{Txid: prev.ID, Vout: index, PubKey: wallet.PublicKey, Sequence: sequence}},
+		Vout:     []transaction.TXOutput{prev.Vout[index]},
+		LockTime: lockTime,
+	}
+
+	prevTXs := make(map[string]transaction.Transaction)
+	addPrevOutput(prevTXs, tx.Vin[0], prev.Vout[index])
+	SignTransaction(tx, wallet.PrivateKey, prevTXs)
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// expectConnectRule checks the rule broken by a rejected block or transaction
+func expectConnectRule(t *testing.T, err error, rule ValidationRule) {
+	t.Helper()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Rule != rule {
+		t.Errorf("Expected %s, got %v", rule, err)
+	}
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	alice := newWallet(t)
+	aliceHash := HashPubKey(alice.PublicKey)
+
+	params := week2.DefaultParams()
+	params.CoinbaseMaturity = 3
+	utxo := NewUTXOSetWithParams(params)
+
+	reward := coinbaseTX("reward", 10, aliceHash)
+	if err := connectAt(utxo, 0, 0, reward); err != nil {
+		t.Fatalf("Failed to connect coinbase: %v", err)
+	}
+	if err := connectAt(utxo, 1, 0, coinbaseTX("filler", 10, aliceHash)); err != nil {
+		t.Fatalf("Failed to connect block: %v", err)
+	}
+
+	if amount, _ := utxo.FindSpendableOutputs(aliceHash, 10); amount != 0 {
+		t.Errorf("Immature coinbase outputs should not be spendable, found %d", amount)
+	}
+
+	spend := spendTX(alice, reward, 0, transaction.TXOutput{Value: 10, PubKeyHash: aliceHash})
+	expectConnectRule(t, connectAt(utxo, 2, 0, spend), RuleImmatureCoinbase)
+	if err := connectAt(utxo, 3, 0, spend); err != nil {
+		t.Errorf("Mature coinbase output should be spendable: %v", err)
+	}
+}
+
+func TestLockTime(t *testing.T) {
+	alice := newWallet(t)
+	aliceHash := HashPubKey(alice.PublicKey)
+
+	utxo := NewUTXOSet()
+	reward := coinbaseTX("reward", 10, aliceHash)
+	if err := connectAt(utxo, 0, 0, reward); err != nil {
+		t.Fatalf("Failed to connect coinbase: %v", err)
+	}
+
+	byHeight := lockedSpendTX(alice, reward, 0, 5, 0)
+	expectConnectRule(t, connectAt(utxo, 5, 0, byHeight), RuleNonFinal)
+
+	byTime := lockedSpendTX(alice, reward, 0, transaction.LockTimeThreshold+100, 0)
+	expectConnectRule(t, connectAt(utxo, 1000, transaction.LockTimeThreshold+100, byTime), RuleNonFinal)
+
+	// Final sequences opt out of the lock time
+	final := lockedSpendTX(alice, reward, 0, 5, transaction.MaxSequence)
+	if err := connectAt(utxo, 1, 0, final); err != nil {
+		t.Errorf("Transaction with final inputs should be accepted: %v", err)
+	}
+
+	// A signed lock time cannot be lowered
+	tampered := lockedSpendTX(alice, reward, 0, 5, 0)
+	tampered.LockTime = 0
+	tampered.ID = tampered.Hash()
+	lookup := func(txID []byte, index int) (UnspentOutput, bool) {
+		return UnspentOutput{TxID: txID, Index: index, Output: reward.Vout[0]}, true
+	}
+	_, err := ValidateTransaction(tampered, lookup, SpendContext{Height: 1, Params: week2.DefaultParams()})
+	expectConnectRule(t, err, RuleInvalidSignature)
+}
+
+// batchBlock returns a UTXO set holding n outputs of a wallet of scheme and
+// an unconnected block of n transactions spending them
+func batchBlock(tb testing.TB, scheme keys.Scheme, n int) (*UTXOSet, *week1.Block) {
+	tb.Helper()
+
+	wallet, err := NewWalletWithScheme(scheme)
+	if err != nil {
+		tb.Fatalf("Failed to create %s wallet: %v", scheme, err)
+	}
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+
+	params := week2.DefaultParams()
+	params.Subsidy = n
+	utxo := NewUTXOSetWithParams(params)
+	funding := coinbaseTX("funding", 1, pubKeyHash)
+	for i := 1; i < n; i++ {
+		funding.Vout = append(funding.Vout, funding.Vout[0])
+	}
+	funding.ID = funding.Hash()
+	if err := connectAt(utxo, 0, 0, funding); err != nil {
+		tb.Fatalf("Failed to connect funding block: %v", err)
+	}
+
+	var txs []*transaction.Transaction
+	for i := 0; i < n; i++ {
+		txs = append(txs, spendTX(wallet, funding, i, transaction.TXOutput{Value: 1, PubKeyHash: pubKeyHash}))
+	}
+	block := week1.NewBlockWithTransactions("batch", utxo.Tip(), txs)
+	block.Index = 1
+	block.SetHash()
+	return utxo, block
+}
+
+func TestBatchVerification(t *testing.T) {
+	utxo, block := batchBlock(t, keys.SchemeSchnorr, 20)
+	if _, err := validateBlock(block, utxo); err != nil {
+		t.Fatalf("Valid block rejected: %v", err)
+	}
+
+	// A well-formed but invalid signature passes the script and fails the batch,
+	// which reports the transaction holding it
+	bad := *block.Transactions[7]
+	bad.Vin = []transaction.TXInput{bad.Vin[0]}
+	bad.Vin[0].Signature = bytes.Clone(bad.Vin[0].Signature)
+	bad.Vin[0].Signature[keys.SignatureSize-1] ^= 0x01
+	bad.ID = bad.Hash()
+	block.Transactions[7] = &bad
+
+	_, err := validateBlock(block, utxo)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Rule != RuleInvalidSignature {
+		t.Fatalf("Expected %s, got %v", RuleInvalidSignature, err)
+	}
+	if !bytes.Equal(validationErr.TxID, bad.ID) {
+		t.Errorf("Expected transaction %x to be reported, got %x", bad.ID, validationErr.TxID)
+	}
+
+	// Transactions checked on their own are not batched
+	_, err = ValidateTransaction(&bad, utxo.Get, SpendContext{Height: 1, Params: utxo.Params()})
+	expectConnectRule(t, err, RuleInvalidSignature)
+}
+
+func TestBatchVerificationFillsSigCache(t *testing.T) {
+	utxo, block := batchBlock(t, keys.SchemeSchnorr, 20)
+	if _, err := validateBlock(block, utxo); err != nil {
+		t.Fatalf("Valid block rejected: %v", err)
+	}
+
+	// Every signature verified in the batch is cached
+	for _, tx := range block.Transactions {
+		prevOut, _ := utxo.Get(tx.Vin[0].Txid, tx.Vin[0].Vout)
+		item, ok := signatureItem(*tx, 0, prevOut.Output.PubKeyHash, tx.Vin[0].PubKey, tx.Vin[0].Signature)
+		if !ok {
+			t.Fatal("Signature should be well-formed")
+		}
+		if !utxo.SigCache().contains(item) {
+			t.Errorf("Signature of transaction %x should be cached", tx.ID)
+		}
+	}
+}
+
+func TestVerifyPool(t *testing.T) {
+	// Invalid signatures are found by the pool, for every scheme
+	for _, scheme := range keys.Schemes {
+		utxo, block := batchBlock(t, scheme, 30)
+		last := block.Transactions[29]
+		last.Vin[0].Signature[0] ^= 0x01
+		last.ID = last.Hash()
+
+		_, err := validateBlock(block, utxo)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Rule != RuleInvalidSignature || !bytes.Equal(validationErr.TxID, last.ID) {
+			t.Errorf("%s: expected %s for transaction %x, got %v", scheme, RuleInvalidSignature, last.ID, err)
+		}
+	}
+
+	// A rule broken after an invalid signature stops the block as well
+	utxo, block := batchBlock(t, keys.SchemeP256, 10)
+	block.Transactions[0].Vin[0].Signature[0] ^= 0x01
+	block.Transactions[0].ID = block.Transactions[0].Hash()
+	block.Transactions = append(block.Transactions, block.Transactions[5])
+	_, err := validateBlock(block, utxo)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || (validationErr.Rule != RuleInvalidSignature && validationErr.Rule != RuleDoubleSpend) {
+		t.Errorf("Expected an invalid signature or a double spend, got %v", err)
+	}
+}
+
+func TestBlockValidationUsesSigCache(t *testing.T) {
+	utxo, block := batchBlock(t, keys.SchemeSecp256k1, 5)
+	tx := block.Transactions[2]
+	tx.Vin[0].Signature[0] ^= 0x01
+	tx.ID = tx.Hash()
+	if _, err := validateBlock(block, utxo); err == nil {
+		t.Fatal("Block with an invalid signature should be rejected")
+	}
+
+	// A cached signature is taken as valid without being verified again
+	prevOut, _ := utxo.Get(tx.Vin[0].Txid, tx.Vin[0].Vout)
+	item, ok := signatureItem(*tx, 0, prevOut.Output.PubKeyHash, tx.Vin[0].PubKey, tx.Vin[0].Signature)
+	if !ok {
+		t.Fatal("Signature should be well-formed")
+	}
+	utxo.SigCache().add(item)
+	if _, err := validateBlock(block, utxo); err != nil {
+		t.Errorf("Block with a cached signature should be accepted, got %v", err)
+	}
+}
+
+// BenchmarkValidateBlock validates a block of single-input transactions,
+// whose inputs are checked by the workers of a verifyPool. Each worker verifies
+// its Schnorr signatures in one batch and the others one by one, so Schnorr
+// blocks only overtake P-256 blocks when each worker gets hundreds of inputs.
+func BenchmarkValidateBlock(b *testing.B) {
+	for _, scheme := range []keys.Scheme{keys.SchemeSchnorr, keys.SchemeSecp256k1, keys.SchemeP256} {
+		for _, n := range []int{10, 100, 500} {
+			utxo, block := batchBlock(b, scheme, n)
+			// Every iteration verifies the signatures
+			utxo.sigCache = nil
+			b.Run(fmt.Sprintf("%s/%d", scheme, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := validateBlock(block, utxo); err != nil {
+						b.Fatalf("Valid block rejected: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package week3
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"blockchain-course/module1/keys"
+	"blockchain-course/module1/transaction"
+)
+
+// signatureBatch collects the Schnorr signatures of the inputs checked by a
+// worker of a verifyPool, to verify them together once the inputs are checked
+type signatureBatch struct {
+	items []keys.BatchItem
+	// inputs are the transaction and input of each item
+	inputs []batchInput
+	// cache receives the signatures found valid, it may be nil
+	cache *SigCache
+}
+
+type batchInput struct {
+	txID []byte
+	inID int
+}
+
+func (b *signatureBatch) add(item keys.BatchItem, txID []byte, inID int) {
+	b.items = append(b.items, item)
+	b.inputs = append(b.inputs, batchInput{txID: txID, inID: inID})
+}
+
+// verify checks every signature of the batch and caches the valid ones. A
+// failing batch does not tell which signature is invalid, the signatures are
+// then checked one by one to report the first invalid input.
+func (b *signatureBatch) verify() error {
+	if keys.VerifyBatch(b.items) {
+		for _, item := range b.items {
+			b.cache.add(item)
+		}
+		return nil
+	}
+	for i, item := range b.items {
+		if !item.Verifier.Verify(item.Digest, item.Signature) {
+			in := b.inputs[i]
+			err := inputError(in.inID, fmt.Errorf("%w: signature does not match the public key", ErrScriptFailed))
+			return &ValidationError{Rule: RuleInvalidSignature, TxID: in.txID, Reason: err.Error()}
+		}
+		b.cache.add(item)
+	}
+	return nil
+}
+
+// errPoolStopped is the failure of a verifyPool stopped before its inputs are checked
+var errPoolStopped = errors.New("verification stopped")
+
+// inputJob is an input whose script is checked by a verifyPool
+type inputJob struct {
+	tx      *transaction.Transaction
+	inID    int
+	prevOut transaction.TXOutput
+}
+
+// verifyPool checks the input scripts of the transactions of a block on
+// several goroutines, while the other rules are checked by the caller. The
+// first failing input aborts the checks left.
+type verifyPool struct {
+	jobs  chan inputJob
+	cache *SigCache
+	wg    sync.WaitGroup
+
+	close sync.Once
+	// abort is closed on the first failure
+	abort chan struct{}
+	fail  sync.Once
+	err   error
+}
+
+// newVerifyPool starts one worker per CPU, checking signatures against cache
+func newVerifyPool(cache *SigCache) *verifyPool {
+	workers := runtime.GOMAXPROCS(0)
+	p := &verifyPool{
+		jobs:  make(chan inputJob, 4*workers),
+		cache: cache,
+		abort: make(chan struct{}),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// work checks inputs until the pool is closed, then verifies the Schnorr
+// signatures it deferred
+func (p *verifyPool) work() {
+	defer p.wg.Done()
+
+	batch := &signatureBatch{cache: p.cache}
+	for job := range p.jobs {
+		if p.aborted() {
+			// Drained so that add never blocks
+			continue
+		}
+		if err := verifyInput(*job.tx, job.inID, job.prevOut, &sigChecker{batch: batch, cache: p.cache}); err != nil {
+			p.setError(ruleError(RuleInvalidSignature, job.tx, "%v", inputError(job.inID, err)))
+		}
+	}
+	if p.aborted() {
+		return
+	}
+	if err := batch.verify(); err != nil {
+		p.setError(err)
+	}
+}
+
+// add queues the inputs of a regular transaction, prevTXs must hold the
+// outputs they spend
+func (p *verifyPool) add(tx *transaction.Transaction, prevTXs map[string]transaction.Transaction) {
+	for inID, in := range tx.Vin {
+		prevOut := prevTXs[hex.EncodeToString(in.Txid)].Vout[in.Vout]
+		p.jobs <- inputJob{tx: tx, inID: inID, prevOut: prevOut}
+	}
+}
+
+func (p *verifyPool) setError(err error) {
+	p.fail.Do(func() {
+		p.err = err
+		close(p.abort)
+	})
+}
+
+func (p *verifyPool) aborted() bool {
+	select {
+	case <-p.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// failed returns the first failure so far, so that the caller can stop early
+func (p *verifyPool) failed() error {
+	if !p.aborted() {
+		return nil
+	}
+	return p.err
+}
+
+// wait checks the inputs left and returns the first failure. The pool cannot
+// be used afterwards.
+func (p *verifyPool) wait() error {
+	p.close.Do(func() { close(p.jobs) })
+	p.wg.Wait()
+	return p.failed()
+}
+
+// stop aborts the checks left and waits for the workers to return
+func (p *verifyPool) stop() {
+	p.setError(errPoolStopped)
+	p.wait()
+}
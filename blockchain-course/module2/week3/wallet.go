@@ -1,42 +1,60 @@
 package week3
 
 import (
-	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
 
+	"blockchain-course/module1/keys"
 	"golang.org/x/crypto/ripemd160"
 )
 
-const version = byte(0x00)
+// addressVersions are the versions of the addresses paying to the hash of a
+// public key, which record the signature scheme of the key
+var addressVersions = map[keys.Scheme]byte{
+	keys.SchemeP256:      0x00,
+	keys.SchemeSecp256k1: 0x01,
+	keys.SchemeEd25519:   0x02,
+	keys.SchemeSchnorr:   0x03,
+}
+
+// scriptHashVersion is the version of the addresses locking outputs to the
+// hash of a redeem script, such as multisig addresses
+const scriptHashVersion = byte(0x05)
 const addressChecksumLen = 4
+const pubKeyHashLen = 20
 
-// Wallet stores private and public keys
+// Wallet stores private and public keys. PublicKey is the encoding of the
+// public key recorded in inputs, starting with its signature scheme.
 type Wallet struct {
-	PrivateKey ecdsa.PrivateKey
+	PrivateKey keys.Signer
 	PublicKey  []byte
 }
 
-// NewWallet creates and returns a Wallet
-func NewWallet() *Wallet {
-	private, public := newKeyPair()
-	wallet := Wallet{private, public}
+// NewWallet creates and returns a Wallet with a P-256 key
+func NewWallet() (*Wallet, error) {
+	return NewWalletWithScheme(keys.SchemeP256)
+}
+
+// NewWalletWithScheme creates a Wallet with a key of a signature scheme
+func NewWalletWithScheme(scheme keys.Scheme) (*Wallet, error) {
+	private, err := keys.GenerateKey(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	return walletFromSigner(private), nil
+}
 
-	return &wallet
+// walletFromSigner returns the Wallet of a private key
+func walletFromSigner(private keys.Signer) *Wallet {
+	return &Wallet{private, private.Public().Bytes()}
 }
 
 // GetAddress returns the wallet address
 func (w Wallet) GetAddress() []byte {
 	pubKeyHash := HashPubKey(w.PublicKey)
-	versionedPayload := append([]byte{version}, pubKeyHash...)
-	checksum := checksum(versionedPayload)
-	fullPayload := append(versionedPayload, checksum...)
-	address := Base58Encode(fullPayload)
 
-	return address
+	return Base58CheckEncode(addressVersions[w.PrivateKey.Scheme()], pubKeyHash)
 }
 
 // HashPubKey hashes public key
@@ -56,13 +74,45 @@ func HashPubKey(pubKey []byte) []byte {
 
 // ValidateAddress check if address if valid
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
+	addressVersion, pubKeyHash, err := Base58CheckDecode([]byte(address))
+	if err != nil {
+		return false
+	}
+	if _, ok := versionScheme(addressVersion); !ok && addressVersion != scriptHashVersion {
+		return false
+	}
 
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	return len(pubKeyHash) == pubKeyHashLen
+}
+
+// IsScriptHashAddress reports whether address is a valid address locking
+// outputs to the hash of a redeem script
+func IsScriptHashAddress(address string) bool {
+	addressVersion, _, err := Base58CheckDecode([]byte(address))
+	return err == nil && addressVersion == scriptHashVersion && ValidateAddress(address)
+}
+
+// AddressScheme returns the signature scheme of the key paid by an address
+func AddressScheme(address string) (keys.Scheme, error) {
+	if !ValidateAddress(address) {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+	addressVersion, _, _ := Base58CheckDecode([]byte(address))
+	scheme, ok := versionScheme(addressVersion)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s does not pay a public key", ErrInvalidAddress, address)
+	}
+	return scheme, nil
+}
+
+// versionScheme returns the signature scheme of an address version
+func versionScheme(addressVersion byte) (keys.Scheme, bool) {
+	for scheme, schemeVersion := range addressVersions {
+		if schemeVersion == addressVersion {
+			return scheme, true
+		}
+	}
+	return 0, false
 }
 
 // checksum generates a checksum for a public key
@@ -73,26 +123,16 @@ func checksum(payload []byte) []byte {
 	return secondSHA[:addressChecksumLen]
 }
 
-func newKeyPair() (ecdsa.PrivateKey, []byte) {
-	curve := elliptic.P256()
-	private, err := ecdsa.GenerateKey(curve, rand.Reader)
+// walletFromPrivateKey restores a Wallet from the encoding of its private
+// key. Files written before keys recorded their scheme hold raw P-256 keys.
+func walletFromPrivateKey(key []byte) (*Wallet, error) {
+	if len(key) == 32 {
+		key = append([]byte{byte(keys.SchemeP256)}, key...)
+	}
+	private, err := keys.ParsePrivateKey(key)
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		return nil, err
 	}
 
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-
-	return *private, pubKey
-}
-
-// Base58Encode encodes data to Base58
-func Base58Encode(input []byte) []byte {
-	// Implementation would go here
-	return input
-}
-
-// Base58Decode decodes Base58-encoded data
-func Base58Decode(input []byte) []byte {
-	// Implementation would go here
-	return input
+	return walletFromSigner(private), nil
 }
@@ -0,0 +1,229 @@
+package week3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// walletFileVersion is the format of the encrypted wallet file
+const walletFileVersion = 1
+
+// scrypt parameters recommended for interactive use
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptSalt   = 16
+	walletKeyLen = 32
+)
+
+var (
+	// ErrWrongPassphrase is returned when the passphrase does not decrypt the wallet file
+	ErrWrongPassphrase = errors.New("wrong wallet passphrase")
+	// ErrWalletLocked is returned when private keys are needed while the wallets are locked
+	ErrWalletLocked = errors.New("wallet is locked")
+	// ErrNoPassphrase is returned when wallets that were never given a passphrase are encrypted
+	ErrNoPassphrase = errors.New("wallet has no passphrase")
+)
+
+// kdfParams are the scrypt parameters deriving the file key from the passphrase
+type kdfParams struct {
+	Salt    []byte
+	N, R, P int
+}
+
+// newKDFParams returns the default parameters with a fresh salt
+func newKDFParams() (kdfParams, error) {
+	salt := make([]byte, scryptSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return kdfParams{}, err
+	}
+	return kdfParams{Salt: salt, N: scryptN, R: scryptR, P: scryptP}, nil
+}
+
+func (p kdfParams) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), p.Salt, p.N, p.R, p.P, walletKeyLen)
+}
+
+// sealedWallets is the content of the wallet file. The keys are encrypted
+// with AES-GCM, the addresses stay in clear so that locked wallets can list
+// them and are authenticated along with the keys.
+type sealedWallets struct {
+	Version    int
+	Addresses  []string
+	KDF        kdfParams
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+func (s *sealedWallets) additionalData() []byte {
+	return []byte(fmt.Sprintf("%d\n%s", s.Version, strings.Join(s.Addresses, "\n")))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// open decrypts the keys of the file
+func (s *sealedWallets) open(key []byte) (walletKeys, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return walletKeys{}, err
+	}
+
+	plaintext, err := gcm.Open(nil, s.Nonce, s.Ciphertext, s.additionalData())
+	if err != nil {
+		return walletKeys{}, ErrWrongPassphrase
+	}
+
+	var keys walletKeys
+	if err := gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&keys); err != nil {
+		return walletKeys{}, err
+	}
+	return keys, nil
+}
+
+// seal encrypts the current keys into ws.sealed, or returns ErrNoPassphrase
+func (ws *Wallets) seal() error {
+	if ws.key == nil {
+		return ErrNoPassphrase
+	}
+
+	var plaintext bytes.Buffer
+	if err := gob.NewEncoder(&plaintext).Encode(ws.walletKeys()); err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(ws.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	addresses := ws.GetAllAddresses()
+	sort.Strings(addresses)
+	sealed := &sealedWallets{
+		Version:   walletFileVersion,
+		Addresses: addresses,
+		KDF:       ws.kdf,
+		Nonce:     nonce,
+	}
+	sealed.Ciphertext = gcm.Seal(nil, nonce, plaintext.Bytes(), sealed.additionalData())
+	ws.sealed = sealed
+	return nil
+}
+
+// setPassphrase derives a new file key from passphrase with a fresh salt
+func (ws *Wallets) setPassphrase(passphrase string) error {
+	kdf, err := newKDFParams()
+	if err != nil {
+		return err
+	}
+	key, err := kdf.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	ws.kdf = kdf
+	ws.key = key
+	return nil
+}
+
+// HasPassphrase reports whether the wallets were given a passphrase, by
+// ChangePassphrase or by the file they were loaded from. The empty passphrase
+// leaves the file effectively unencrypted, so it must be chosen explicitly.
+func (ws *Wallets) HasPassphrase() bool {
+	return ws.locked || ws.key != nil
+}
+
+// IsLocked reports whether the private keys are unavailable
+func (ws *Wallets) IsLocked() bool {
+	return ws.locked
+}
+
+// Lock encrypts the keys and removes them from memory, only the addresses
+// remain known until Unlock
+func (ws *Wallets) Lock() error {
+	if ws.locked {
+		return nil
+	}
+	if err := ws.seal(); err != nil {
+		return err
+	}
+	ws.wipe()
+	return nil
+}
+
+// wipe forgets every secret, leaving the wallets locked
+func (ws *Wallets) wipe() {
+	ws.Wallets = make(map[string]*Wallet)
+	ws.Paths = make(map[string]string)
+	ws.next = make(map[chainID]uint32)
+	ws.seed = nil
+	ws.master = nil
+	ws.key = nil
+	ws.kdf = ws.sealed.KDF
+	ws.locked = true
+}
+
+// Unlock decrypts the keys with the passphrase, or returns ErrWrongPassphrase
+func (ws *Wallets) Unlock(passphrase string) error {
+	if !ws.locked {
+		return nil
+	}
+
+	key, err := ws.sealed.KDF.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	keys, err := ws.sealed.open(key)
+	if err != nil {
+		return err
+	}
+	if err := ws.restoreKeys(keys); err != nil {
+		return err
+	}
+
+	ws.key = key
+	ws.locked = false
+	return nil
+}
+
+// ChangePassphrase replaces the passphrase protecting the wallets, which are
+// left unlocked. The file is only updated by the next SaveToFile.
+func (ws *Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if ws.locked {
+		if err := ws.Unlock(oldPassphrase); err != nil {
+			return err
+		}
+	} else if ws.key != nil {
+		key, err := ws.kdf.deriveKey(oldPassphrase)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(key, ws.key) != 1 {
+			return ErrWrongPassphrase
+		}
+	} else if oldPassphrase != "" {
+		// Wallets never saved have the empty passphrase
+		return ErrWrongPassphrase
+	}
+
+	return ws.setPassphrase(newPassphrase)
+}
@@ -2,26 +2,162 @@ package week3
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"blockchain-course/module1/keys"
+)
+
+const (
+	// walletFile is the file of the default wallet
+	walletFile = "wallets.dat"
+	// namedWalletPrefix and walletFileExt name the files of the other wallets, wallets-NAME.dat
+	namedWalletPrefix = "wallets-"
+	walletFileExt     = ".dat"
+)
+
+var (
+	// ErrNoSeed is returned when deriving addresses from Wallets without a seed
+	ErrNoSeed = errors.New("wallets have no seed")
+	// ErrInvalidWalletName is returned for wallet names that cannot be used as file names
+	ErrInvalidWalletName = errors.New("invalid wallet name")
 )
 
-const walletFile = "wallets.dat"
+// walletNamePattern restricts names to characters safe in file names
+var walletNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// walletsConfig locates the wallet file
+type walletsConfig struct {
+	dir  string
+	name string
+}
+
+// WalletsOption configures NewWallets
+type WalletsOption func(*walletsConfig)
+
+// WithDataDir stores the wallet file in dir instead of the working directory
+func WithDataDir(dir string) WalletsOption {
+	return func(c *walletsConfig) {
+		c.dir = dir
+	}
+}
+
+// WithName selects a named wallet instead of the default one
+func WithName(name string) WalletsOption {
+	return func(c *walletsConfig) {
+		c.name = name
+	}
+}
+
+// walletPath returns the file of the named wallet in dir, the default wallet for an empty name
+func walletPath(dir, name string) (string, error) {
+	if name == "" {
+		return filepath.Join(dir, walletFile), nil
+	}
+	if !walletNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidWalletName, name)
+	}
+	return filepath.Join(dir, namedWalletPrefix+name+walletFileExt), nil
+}
+
+// ListWallets returns the sorted names of the wallets stored in dir, the
+// default wallet being the empty name
+func ListWallets(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
 
-// Wallets stores a collection of wallets
+	var names []string
+	for _, entry := range entries {
+		file := entry.Name()
+		switch {
+		case entry.IsDir():
+		case file == walletFile:
+			names = append(names, "")
+		case strings.HasPrefix(file, namedWalletPrefix) && strings.HasSuffix(file, walletFileExt):
+			name := strings.TrimSuffix(strings.TrimPrefix(file, namedWalletPrefix), walletFileExt)
+			if walletNamePattern.MatchString(name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// walletKeys is the content of the wallet file: the private key of every
+// random address encoded with its scheme, and the seed and chain lengths of
+// the derived addresses.
+type walletKeys struct {
+	Keys   map[string][]byte
+	Seed   []byte
+	Chains []hdChain
+}
+
+// hdChain records how many addresses have been derived on a chain of an account
+type hdChain struct {
+	Account uint32
+	Chain   uint32
+	Next    uint32
+}
+
+// chainID identifies a chain of an account
+type chainID struct {
+	account uint32
+	chain   uint32
+}
+
+// Wallets stores a collection of wallets. Wallets derived from the seed of
+// an HD wallet can be regenerated from the seed alone, random wallets cannot.
 type Wallets struct {
 	Wallets map[string]*Wallet
+	// Paths maps the addresses derived from the seed to their derivation path
+	Paths map[string]string
+
+	// file is the path of the wallet file
+	file string
+
+	seed   []byte
+	master *ExtendedKey
+	next   map[chainID]uint32
+
+	// sealed is the encrypted content of the wallet file, the only copy of
+	// the keys while locked
+	sealed *sealedWallets
+	locked bool
+	// kdf and key encrypt the wallet file, the key is only known while unlocked
+	kdf kdfParams
+	key []byte
 }
 
-// NewWallets creates Wallets and fills it from a file if it exists
-func NewWallets() (*Wallets, error) {
-	wallets := Wallets{}
+// NewWallets creates Wallets and fills it from its file if it exists. Without
+// options, the default wallet of the working directory is used.
+func NewWallets(options ...WalletsOption) (*Wallets, error) {
+	var config walletsConfig
+	for _, option := range options {
+		option(&config)
+	}
+	file, err := walletPath(config.dir, config.name)
+	if err != nil {
+		return nil, err
+	}
+
+	wallets := Wallets{file: file}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.Paths = make(map[string]string)
+	wallets.next = make(map[chainID]uint32)
 
-	err := wallets.LoadFromFile()
+	err = wallets.LoadFromFile()
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
@@ -29,23 +165,179 @@ func NewWallets() (*Wallets, error) {
 	return &wallets, nil
 }
 
+// File returns the path of the wallet file
+func (ws *Wallets) File() string {
+	return ws.file
+}
+
 // CreateWallet creates and adds a new wallet to Wallets
-func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
-	address := fmt.Sprintf("%s", wallet.GetAddress())
+func (ws *Wallets) CreateWallet() (string, error) {
+	wallet, err := NewWallet()
+	if err != nil {
+		return "", err
+	}
+	address := string(wallet.GetAddress())
+
+	ws.Wallets[address] = wallet
+
+	return address, nil
+}
+
+// CreateWalletWithScheme adds a new random wallet with a key of a signature
+// scheme. Unlike derived addresses, it is not recovered by the mnemonic.
+func (ws *Wallets) CreateWalletWithScheme(scheme keys.Scheme) (string, error) {
+	wallet, err := NewWalletWithScheme(scheme)
+	if err != nil {
+		return "", err
+	}
+	address := string(wallet.GetAddress())
+
+	ws.Wallets[address] = wallet
+
+	return address, nil
+}
+
+// HasSeed reports whether Wallets can derive addresses
+func (ws *Wallets) HasSeed() bool {
+	return ws.master != nil
+}
+
+// GenerateSeed creates a random mnemonic, makes its seed the source of derived
+// addresses and returns it. The mnemonic is the backup of every derived address.
+func (ws *Wallets) GenerateSeed(passphrase string) (string, error) {
+	entropy, err := NewEntropy(DefaultEntropyBits)
+	if err != nil {
+		return "", err
+	}
+	mnemonic, err := NewMnemonic(entropy)
+	if err != nil {
+		return "", err
+	}
+	if err := ws.RestoreSeed(mnemonic, passphrase); err != nil {
+		return "", err
+	}
+	return mnemonic, nil
+}
+
+// RestoreSeed makes the seed of a mnemonic the source of derived addresses.
+// The addresses derived from a previous seed are dropped.
+func (ws *Wallets) RestoreSeed(mnemonic, passphrase string) error {
+	seed, err := NewSeed(mnemonic, passphrase)
+	if err != nil {
+		return err
+	}
+	return ws.setSeed(seed, nil)
+}
+
+// setSeed replaces the seed and derives the given number of addresses on each chain
+func (ws *Wallets) setSeed(seed []byte, chains []hdChain) error {
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return err
+	}
+
+	for address := range ws.Paths {
+		delete(ws.Wallets, address)
+	}
+	ws.Paths = make(map[string]string)
+	ws.next = make(map[chainID]uint32)
+	ws.seed = seed
+	ws.master = master
+
+	for _, chain := range chains {
+		for i := uint32(0); i < chain.Next; i++ {
+			if _, err := ws.DeriveWallet(chain.Account, chain.Chain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeriveWallet derives the next address of a chain of an account, such as
+// ExternalChain for receiving addresses or InternalChain for change
+func (ws *Wallets) DeriveWallet(account, chain uint32) (string, error) {
+	if ws.master == nil {
+		return "", ErrNoSeed
+	}
+
+	id := chainID{account: account, chain: chain}
+	path := DerivationPath(account, chain, ws.next[id])
+	key, err := ws.master.Derive(path)
+	if err != nil {
+		return "", err
+	}
+	wallet, err := key.Wallet()
+	if err != nil {
+		return "", err
+	}
 
+	address := string(wallet.GetAddress())
 	ws.Wallets[address] = wallet
+	ws.Paths[address] = path
+	ws.next[id]++
+	return address, nil
+}
+
+// Discover regenerates the addresses of an account after RestoreSeed. Each
+// chain is scanned until gapLimit consecutive addresses are unused, and
+// only the addresses up to the last used one are kept.
+func (ws *Wallets) Discover(account uint32, gapLimit int, used func(pubKeyHash []byte) bool) error {
+	if ws.master == nil {
+		return ErrNoSeed
+	}
+
+	var chains []hdChain
+	for id, next := range ws.next {
+		if id.account != account {
+			chains = append(chains, hdChain{Account: id.account, Chain: id.chain, Next: next})
+		}
+	}
+
+	for _, chain := range []uint32{ExternalChain, InternalChain} {
+		found := ws.next[chainID{account: account, chain: chain}]
+		for index, gap := found, 0; gap < gapLimit; index++ {
+			key, err := ws.master.Derive(DerivationPath(account, chain, index))
+			if err != nil {
+				return err
+			}
+			wallet, err := key.Wallet()
+			if err != nil {
+				return err
+			}
+
+			if used(HashPubKey(wallet.PublicKey)) {
+				found, gap = index+1, 0
+			} else {
+				gap++
+			}
+		}
+		chains = append(chains, hdChain{Account: account, Chain: chain, Next: found})
+	}
 
-	return address
+	return ws.setSeed(ws.seed, chains)
 }
 
-// GetWallet returns a wallet by its address
-func (ws Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+// GetWallet returns the wallet of an address, or ErrUnknownAddress.
+// The keys of locked wallets are not available.
+func (ws *Wallets) GetWallet(address string) (*Wallet, error) {
+	if ws.locked {
+		return nil, ErrWalletLocked
+	}
+
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAddress, address)
+	}
+	return wallet, nil
 }
 
-// GetAllAddresses returns all wallet addresses
+// GetAllAddresses returns all wallet addresses, which remain known while locked
 func (ws *Wallets) GetAllAddresses() []string {
+	if ws.locked {
+		return append([]string(nil), ws.sealed.Addresses...)
+	}
+
 	var addresses []string
 
 	for address := range ws.Wallets {
@@ -55,44 +347,138 @@ func (ws *Wallets) GetAllAddresses() []string {
 	return addresses
 }
 
-// LoadFromFile loads wallets from the file
+// LoadFromFile loads wallets from the file. The wallets are locked unless the
+// file is protected by the empty passphrase.
 func (ws *Wallets) LoadFromFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+	if _, err := os.Stat(ws.file); os.IsNotExist(err) {
 		return err
 	}
 
-	fileContent, err := ioutil.ReadFile(walletFile)
+	fileContent, err := os.ReadFile(ws.file)
 	if err != nil {
 		return err
 	}
 
-	var wallets Wallets
-	gob.Register(elliptic.P256())
-	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
-	if err != nil {
+	var sealed sealedWallets
+	err = gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&sealed)
+	if err != nil || sealed.Version == 0 {
+		// Files written before encryption hold the keys in clear, they are
+		// encrypted on the next save
+		var content walletKeys
+		if legacyErr := gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&content); legacyErr != nil {
+			return legacyErr
+		}
+		ws.sealed = nil
+		ws.key = nil
+		ws.locked = false
+		return ws.restoreKeys(content)
+	}
+	if sealed.Version != walletFileVersion {
+		return fmt.Errorf("unsupported wallet file version %d", sealed.Version)
+	}
+
+	ws.sealed = &sealed
+	ws.wipe()
+	if err := ws.Unlock(""); err != nil && !errors.Is(err, ErrWrongPassphrase) {
 		return err
 	}
+	return nil
+}
 
-	ws.Wallets = wallets.Wallets
+// restoreKeys replaces the wallets with the content of a wallet file
+func (ws *Wallets) restoreKeys(content walletKeys) error {
+	wallets := make(map[string]*Wallet)
+	for _, key := range content.Keys {
+		wallet, err := walletFromPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		// Addresses are derived again since older files hold the addresses
+		// of earlier encodings of the public keys
+		wallets[string(wallet.GetAddress())] = wallet
+	}
+	ws.Wallets = wallets
+	ws.Paths = make(map[string]string)
+	ws.next = make(map[chainID]uint32)
+	ws.seed = nil
+	ws.master = nil
 
+	// Derived addresses are not stored, they are regenerated from the seed
+	if content.Seed != nil {
+		return ws.setSeed(content.Seed, content.Chains)
+	}
 	return nil
 }
 
-// SaveToFile saves wallets to a file
-func (ws Wallets) SaveToFile() {
-	var content bytes.Buffer
+// walletKeys returns the content of the wallet file for the wallets
+func (ws *Wallets) walletKeys() walletKeys {
+	content := walletKeys{Keys: make(map[string][]byte), Seed: ws.seed}
+	for id, next := range ws.next {
+		content.Chains = append(content.Chains, hdChain{Account: id.account, Chain: id.chain, Next: next})
+	}
+	for address, wallet := range ws.Wallets {
+		if _, derived := ws.Paths[address]; derived {
+			continue
+		}
+		content.Keys[address] = wallet.PrivateKey.Bytes()
+	}
+	return content
+}
 
-	gob.Register(elliptic.P256())
+// SaveToFile encrypts the wallets and atomically replaces the file, readable
+// by its owner only. Wallets that were never given a passphrase are not saved,
+// ErrNoPassphrase is returned until ChangePassphrase sets one.
+func (ws *Wallets) SaveToFile() error {
+	if ws.locked {
+		return ErrWalletLocked
+	}
+	if err := ws.seal(); err != nil {
+		return err
+	}
+
+	var content bytes.Buffer
+	if err := gob.NewEncoder(&content).Encode(ws.sealed); err != nil {
+		return err
+	}
 
-	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
+	if err := os.MkdirAll(filepath.Dir(ws.file), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ws.file), filepath.Base(ws.file)+".tmp")
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(content.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Make the content durable before the rename can replace the old file
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), ws.file); err != nil {
+		return err
 	}
+	return syncDir(filepath.Dir(ws.file))
+}
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+// syncDir flushes the entries of a directory, making a rename into it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
-		fmt.Printf("Error: %s\n", err)
+		return err
 	}
+	defer d.Close()
+
+	return d.Sync()
 }
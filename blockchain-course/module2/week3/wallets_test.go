@@ -0,0 +1,295 @@
+package week3
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"blockchain-course/module1/keys"
+)
+
+func TestWalletsRegenerateFromSeed(t *testing.T) {
+	dir := t.TempDir()
+
+	wallets, err := NewWallets(WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("Failed to create wallets: %v", err)
+	}
+	if _, err := wallets.DeriveWallet(0, ExternalChain); !errors.Is(err, ErrNoSeed) {
+		t.Errorf("Expected ErrNoSeed, got %v", err)
+	}
+
+	mnemonic, err := wallets.GenerateSeed("")
+	if err != nil {
+		t.Fatalf("Failed to generate seed: %v", err)
+	}
+	first, _ := wallets.DeriveWallet(0, ExternalChain)
+	wallets.DeriveWallet(0, ExternalChain)
+	change, _ := wallets.DeriveWallet(0, InternalChain)
+	random := createWallet(t, wallets)
+	if wallets.Paths[change] != DerivationPath(0, InternalChain, 0) {
+		t.Errorf("Unexpected path %s for the change address", wallets.Paths[change])
+	}
+
+	// The empty passphrase must be chosen explicitly
+	if err := wallets.SaveToFile(); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Expected ErrNoPassphrase, got %v", err)
+	}
+	if err := wallets.ChangePassphrase("", ""); err != nil || !wallets.HasPassphrase() {
+		t.Fatalf("Failed to set the empty passphrase: %v", err)
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		t.Fatalf("Failed to save wallets: %v", err)
+	}
+
+	// The file stores the seed and regenerates the same addresses
+	loaded, err := NewWallets(WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("Failed to load wallets: %v", err)
+	}
+	expected := wallets.GetAllAddresses()
+	addresses := loaded.GetAllAddresses()
+	sort.Strings(expected)
+	sort.Strings(addresses)
+	if len(addresses) != 4 || len(addresses) != len(expected) {
+		t.Fatalf("Expected %d addresses, got %d", len(expected), len(addresses))
+	}
+	for i := range expected {
+		if addresses[i] != expected[i] {
+			t.Errorf("Address %s was not regenerated", expected[i])
+		}
+	}
+
+	// The mnemonic alone recovers the used derived addresses, not the random one
+	restored, _ := NewWallets(WithDataDir(dir))
+	if err := restored.RestoreSeed(mnemonic, ""); err != nil {
+		t.Fatalf("Failed to restore seed: %v", err)
+	}
+	firstHash := HashPubKey(wallets.Wallets[first].PublicKey)
+	err = restored.Discover(0, 5, func(pubKeyHash []byte) bool {
+		return string(pubKeyHash) == string(firstHash)
+	})
+	if err != nil {
+		t.Fatalf("Failed to discover addresses: %v", err)
+	}
+	if _, ok := restored.Wallets[first]; !ok || len(restored.Paths) != 1 {
+		t.Errorf("Expected only the used address to be restored, got %v", restored.Paths)
+	}
+	if _, ok := restored.Wallets[random]; !ok {
+		t.Error("Random wallets of the file should be kept")
+	}
+
+	// Another passphrase gives other addresses
+	other, _ := NewWallets(WithDataDir(dir))
+	other.RestoreSeed(mnemonic, "other")
+	if address, _ := other.DeriveWallet(0, ExternalChain); address == first {
+		t.Error("Passphrase should change the derived addresses")
+	}
+}
+
+func TestWalletFileEncryption(t *testing.T) {
+	dir := t.TempDir()
+
+	wallets, _ := NewWallets(WithDataDir(dir))
+	address := createWallet(t, wallets)
+	key := wallets.Wallets[address].PrivateKey.Bytes()
+	if err := wallets.ChangePassphrase("", "secret"); err != nil {
+		t.Fatalf("Failed to set passphrase: %v", err)
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		t.Fatalf("Failed to save wallets: %v", err)
+	}
+
+	info, err := os.Stat(wallets.File())
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Wallet file should only be readable by its owner, got %v %v", info.Mode(), err)
+	}
+	content, _ := os.ReadFile(wallets.File())
+	if bytes.Contains(content, key) {
+		t.Error("Wallet file should not contain private keys in clear")
+	}
+
+	// A protected file loads locked, with its addresses but not its keys
+	loaded, err := NewWallets(WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("Failed to load wallets: %v", err)
+	}
+	if !loaded.IsLocked() || len(loaded.Wallets) != 0 {
+		t.Fatal("Wallets protected by a passphrase should load locked")
+	}
+	if addresses := loaded.GetAllAddresses(); len(addresses) != 1 || addresses[0] != address {
+		t.Errorf("Locked wallets should list their addresses, got %v", addresses)
+	}
+	if err := loaded.SaveToFile(); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("Expected ErrWalletLocked, got %v", err)
+	}
+
+	if err := loaded.Unlock("wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+	if err := loaded.Unlock("secret"); err != nil {
+		t.Fatalf("Failed to unlock: %v", err)
+	}
+	if wallet, ok := loaded.Wallets[address]; !ok || string(wallet.GetAddress()) != address {
+		t.Error("Unlocked wallets should hold the private keys")
+	}
+
+	if err := loaded.Lock(); err != nil || !loaded.IsLocked() || len(loaded.Wallets) != 0 {
+		t.Errorf("Lock should remove the keys from memory, got %v", err)
+	}
+
+	// Changing the passphrase requires the current one
+	if err := loaded.ChangePassphrase("wrong", "other"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+	if err := loaded.ChangePassphrase("secret", "other"); err != nil {
+		t.Fatalf("Failed to change passphrase: %v", err)
+	}
+	if err := loaded.ChangePassphrase("secret", "again"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Old passphrase should no longer be accepted, got %v", err)
+	}
+	loaded.SaveToFile()
+
+	reloaded, _ := NewWallets(WithDataDir(dir))
+	if err := reloaded.Unlock("other"); err != nil || len(reloaded.Wallets) != 1 {
+		t.Errorf("Wallets should unlock with the new passphrase, got %v", err)
+	}
+}
+
+func TestLegacyWalletFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// Files written before encryption hold the raw P-256 keys, without their scheme
+	wallet := newWallet(t)
+	key := wallet.PrivateKey.Bytes()[1:]
+	address := string(wallet.GetAddress())
+	var content bytes.Buffer
+	gob.NewEncoder(&content).Encode(walletKeys{Keys: map[string][]byte{address: key}})
+	os.WriteFile(filepath.Join(dir, walletFile), content.Bytes(), 0644)
+
+	wallets, err := NewWallets(WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("Failed to load legacy file: %v", err)
+	}
+	if _, ok := wallets.Wallets[address]; !ok || wallets.IsLocked() {
+		t.Fatal("Legacy file should load unlocked")
+	}
+
+	if err := wallets.SaveToFile(); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Legacy file should need a passphrase to be saved, got %v", err)
+	}
+	wallets.ChangePassphrase("", "secret")
+	if err := wallets.SaveToFile(); err != nil {
+		t.Fatalf("Failed to save wallets: %v", err)
+	}
+	migrated, _ := os.ReadFile(wallets.File())
+	if bytes.Contains(migrated, key) {
+		t.Error("Saving should encrypt the legacy file")
+	}
+}
+
+func TestWalletSchemes(t *testing.T) {
+	dir := t.TempDir()
+
+	wallets, _ := NewWallets(WithDataDir(dir))
+	addresses := make(map[string]keys.Scheme)
+	for _, scheme := range keys.Schemes {
+		address, err := wallets.CreateWalletWithScheme(scheme)
+		if err != nil {
+			t.Fatalf("Failed to create %s wallet: %v", scheme, err)
+		}
+		addresses[address] = scheme
+	}
+	wallets.ChangePassphrase("", "")
+	if err := wallets.SaveToFile(); err != nil {
+		t.Fatalf("Failed to save wallets: %v", err)
+	}
+
+	loaded, err := NewWallets(WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("Failed to load wallets: %v", err)
+	}
+	for address, scheme := range addresses {
+		wallet, err := loaded.GetWallet(address)
+		if err != nil || wallet.PrivateKey.Scheme() != scheme {
+			t.Errorf("Expected %s wallet for %s, got %v", scheme, address, err)
+		}
+	}
+}
+
+func TestNamedWallets(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"", "alice", "bob"} {
+		wallets, err := NewWallets(WithDataDir(dir), WithName(name))
+		if err != nil {
+			t.Fatalf("Failed to create wallet %q: %v", name, err)
+		}
+		createWallet(t, wallets)
+		wallets.ChangePassphrase("", "")
+		if err := wallets.SaveToFile(); err != nil {
+			t.Fatalf("Failed to save wallet %q: %v", name, err)
+		}
+	}
+
+	names, err := ListWallets(dir)
+	if err != nil || len(names) != 3 || names[0] != "" || names[1] != "alice" || names[2] != "bob" {
+		t.Errorf("Expected the default, alice and bob wallets, got %q %v", names, err)
+	}
+
+	// Each wallet keeps its own addresses
+	alice, _ := NewWallets(WithDataDir(dir), WithName("alice"))
+	bob, _ := NewWallets(WithDataDir(dir), WithName("bob"))
+	if alice.GetAllAddresses()[0] == bob.GetAllAddresses()[0] {
+		t.Error("Named wallets should not share addresses")
+	}
+
+	if _, err := NewWallets(WithDataDir(dir), WithName("../escape")); !errors.Is(err, ErrInvalidWalletName) {
+		t.Errorf("Expected ErrInvalidWalletName, got %v", err)
+	}
+}
+
+// newWallet returns a new P-256 wallet
+func newWallet(t testing.TB) *Wallet {
+	t.Helper()
+	wallet, err := NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	return wallet
+}
+
+// createWallet adds a new random wallet to ws and returns its address
+func createWallet(t testing.TB, ws *Wallets) string {
+	t.Helper()
+	address, err := ws.CreateWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	return address
+}
+
+func TestGetWallet(t *testing.T) {
+	wallets, err := NewWallets(WithDataDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Failed to create wallets: %v", err)
+	}
+	address := createWallet(t, wallets)
+
+	if wallet, err := wallets.GetWallet(address); err != nil || string(wallet.GetAddress()) != address {
+		t.Errorf("Expected the wallet of %s, got %v", address, err)
+	}
+	if _, err := wallets.GetWallet("unknown"); !errors.Is(err, ErrUnknownAddress) {
+		t.Errorf("Expected ErrUnknownAddress, got %v", err)
+	}
+
+	wallets.ChangePassphrase("", "secret")
+	wallets.Lock()
+	if _, err := wallets.GetWallet(address); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("Expected ErrWalletLocked, got %v", err)
+	}
+}
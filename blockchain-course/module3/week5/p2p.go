@@ -2,13 +2,19 @@ package week5
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week1"
 	"blockchain-course/module1/week2"
+	"blockchain-course/module2/mempool"
+	"blockchain-course/module2/week3"
 )
 
 // Node represents a P2P node in the network
@@ -19,6 +25,11 @@ type Node struct {
 	peersMutex sync.RWMutex
 	Server     *http.Server
 	Blockchain *week2.Blockchain
+	// Mempool holds the transactions received from peers, nil ignores them
+	Mempool *mempool.Mempool
+
+	miningMutex  sync.Mutex
+	cancelMining context.CancelFunc
 }
 
 // Peer represents a peer in the network
@@ -45,7 +56,7 @@ func NewNode(address string, port int, blockchain *week2.Blockchain) *Node {
 
 	// Set up HTTP server for handling requests
 	node.Server = &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", address, port),
+		Addr:    net.JoinHostPort(address, strconv.Itoa(port)),
 		Handler: node.createRouter(),
 	}
 
@@ -57,7 +68,7 @@ func (n *Node) Start() error {
 	fmt.Printf("Starting node at %s:%d\n", n.Address, n.Port)
 
 	// Start listening for connections
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", n.Address, n.Port))
+	listener, err := net.Listen("tcp", net.JoinHostPort(n.Address, strconv.Itoa(n.Port)))
 	if err != nil {
 		return err
 	}
@@ -94,7 +105,7 @@ func (n *Node) AddPeer(address string, port int) error {
 	n.peersMutex.Lock()
 	defer n.peersMutex.Unlock()
 
-	peerAddress := fmt.Sprintf("%s:%d", address, port)
+	peerAddress := net.JoinHostPort(address, strconv.Itoa(port))
 
 	// Check if peer already exists
 	if _, exists := n.Peers[peerAddress]; exists {
@@ -126,7 +137,7 @@ func (n *Node) RemovePeer(address string, port int) {
 	n.peersMutex.Lock()
 	defer n.peersMutex.Unlock()
 
-	peerAddress := fmt.Sprintf("%s:%d", address, port)
+	peerAddress := net.JoinHostPort(address, strconv.Itoa(port))
 
 	// Check if peer exists
 	peer, exists := n.Peers[peerAddress]
@@ -175,11 +186,11 @@ func (n *Node) HandleMessage(msg *Message) {
 	case "block":
 		// Handle block message
 		fmt.Println("Received block message")
-		// In a real implementation, this would deserialize and add the block to the blockchain
+		n.handleBlock(msg.Payload)
 	case "transaction":
 		// Handle transaction message
 		fmt.Println("Received transaction message")
-		// In a real implementation, this would deserialize and add the transaction to the mempool
+		n.handleTransaction(msg.Payload)
 	case "get_blocks":
 		// Handle get blocks request
 		fmt.Println("Received get blocks request")
@@ -192,6 +203,119 @@ func (n *Node) HandleMessage(msg *Message) {
 	}
 }
 
+// handleBlock deserializes a block received from a peer and hands it to the blockchain,
+// which stores it on the right branch and reorganizes if it makes a heavier chain
+func (n *Node) handleBlock(payload []byte) {
+	if n.Blockchain == nil {
+		return
+	}
+
+	block, err := week1.DeserializeBlock(payload)
+	if err != nil {
+		fmt.Printf("Error deserializing block: %s\n", err)
+		return
+	}
+
+	status, err := n.Blockchain.ProcessBlock(block)
+	if err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+		return
+	}
+
+	switch status {
+	case week2.BlockMainChain:
+		// Our own block would no longer extend the tip
+		n.abortMining()
+		fmt.Printf("Block %x is the new tip\n", block.Hash)
+	case week2.BlockSideChain:
+		fmt.Printf("Block %x stored on a side branch\n", block.Hash)
+	case week2.BlockOrphan:
+		fmt.Printf("Block %x is an orphan, waiting for its parent\n", block.Hash)
+	}
+}
+
+// MineBlock mines a block on top of the current tip and adds it to the
+// blockchain. Mining is aborted if a block received from a peer becomes the
+// new tip first.
+func (n *Node) MineBlock(data string, txs []*transaction.Transaction) (*week1.Block, week2.MiningStats, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n.miningMutex.Lock()
+	if n.cancelMining != nil {
+		n.cancelMining()
+	}
+	n.cancelMining = cancel
+	n.miningMutex.Unlock()
+
+	// The tip is read once the cancel func is registered, so that a block
+	// becoming the tip in between is either read here or aborts the mining
+	tip, err := n.Blockchain.TipBlock()
+	if err != nil {
+		return nil, week2.MiningStats{}, err
+	}
+
+	block, stats, err := n.Blockchain.MineBlockContext(ctx, tip, data, txs)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	if _, err := n.Blockchain.ProcessBlock(block); err != nil {
+		return nil, stats, err
+	}
+	return block, stats, nil
+}
+
+// MineMempool mines a block made of the mempool transactions paying the
+// highest fee rates, within maxSize bytes, and a coinbase paying the block
+// subsidy and their fees to address
+func (n *Node) MineMempool(address string, maxSize int) (*week1.Block, week2.MiningStats, error) {
+	var txs []*transaction.Transaction
+	fees := 0
+	if n.Mempool != nil {
+		txs, fees = n.Mempool.BlockTemplate(maxSize)
+	}
+
+	height := n.Blockchain.Height() + 1
+	reward := n.Blockchain.Params().BlockSubsidy(height) + fees
+	coinbase, err := week3.NewCoinbaseTXWithReward(address, fmt.Sprintf("Reward at height %d", height), reward)
+	if err != nil {
+		return nil, week2.MiningStats{}, err
+	}
+
+	return n.MineBlock("", append([]*transaction.Transaction{coinbase}, txs...))
+}
+
+// abortMining cancels the block being mined, if any
+func (n *Node) abortMining() {
+	n.miningMutex.Lock()
+	defer n.miningMutex.Unlock()
+
+	if n.cancelMining != nil {
+		n.cancelMining()
+		n.cancelMining = nil
+	}
+}
+
+// handleTransaction deserializes a transaction received from a peer and adds it to the mempool
+func (n *Node) handleTransaction(payload []byte) {
+	if n.Mempool == nil {
+		return
+	}
+
+	tx, err := transaction.DeserializeTransaction(payload)
+	if err != nil {
+		fmt.Printf("Error deserializing transaction: %s\n", err)
+		return
+	}
+
+	if err := n.Mempool.Add(tx); err != nil {
+		fmt.Printf("Rejected transaction %x: %s\n", tx.ID, err)
+		return
+	}
+	fmt.Printf("Transaction %x added to the mempool\n", tx.ID)
+}
+
 // DiscoverPeers discovers new peers in the network
 func (n *Node) DiscoverPeers(bootstrapNodes []string) {
 	fmt.Println("Discovering peers...")
@@ -1,7 +1,15 @@
 package week5
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"blockchain-course/module1/transaction"
+	"blockchain-course/module1/week2"
+	"blockchain-course/module2/mempool"
+	"blockchain-course/module2/week3"
 )
 
 func TestNewNode(t *testing.T) {
@@ -102,3 +110,104 @@ func TestMessageTypes(t *testing.T) {
 		node.HandleMessage(msg)
 	}
 }
+
+func TestHandleBlockMessage(t *testing.T) {
+	bc := week2.NewBlockchain()
+	node := NewNode("localhost", 8080, bc)
+
+	// Mine a block on another node's copy of the tip and relay it
+	tip, _ := bc.TipBlock()
+	block, err := bc.MineBlock(tip, "Relayed block", nil)
+	if err != nil {
+		t.Fatalf("Failed to mine block: %v", err)
+	}
+
+	node.HandleMessage(&Message{Type: "block", Payload: block.Serialize()})
+
+	if bc.Height() != 1 {
+		t.Errorf("Relayed block should extend the chain, height is %d", bc.Height())
+	}
+}
+
+func TestMineBlockAbortedByNewTip(t *testing.T) {
+	params := week2.DefaultParams()
+	params.InitialBits = week2.TargetBitsToCompact(100)
+	bc, err := week2.NewBlockchainWithParams(week2.NewMemoryStorage(), params)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	node := NewNode("localhost", 8080, bc)
+
+	done := make(chan error)
+	go func() {
+		_, _, err := node.MineBlock("Never found", nil)
+		done <- err
+	}()
+
+	// Simulate a competing block becoming the tip until the miner gives up
+	for {
+		node.abortMining()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected mining to be cancelled, got %v", err)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestTransactionMessageFeedsMempool(t *testing.T) {
+	wallet, err := week3.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	address := string(wallet.GetAddress())
+
+	chain := week2.NewBlockchain()
+	bc, err := week3.NewBlockchain(chain)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	funding, err := week3.NewCoinbaseTX(address, "funding")
+	if err != nil {
+		t.Fatalf("Failed to create coinbase: %v", err)
+	}
+	if err := bc.AddBlockWithTransactions("funding", []*transaction.Transaction{funding}); err != nil {
+		t.Fatalf("Failed to add block: %v", err)
+	}
+
+	node := NewNode("localhost", 8080, chain)
+	node.Mempool = mempool.New(bc.UTXO, mempool.DefaultConfig())
+	chain.AddObserver(node.Mempool)
+
+	payment, err := week3.NewTXOutput(9, address)
+	if err != nil {
+		t.Fatalf("Failed to create output: %v", err)
+	}
+	tx := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Txid: funding.ID, Vout: 0, PubKey: wallet.PublicKey}},
+		Vout: []transaction.TXOutput{*payment},
+	}
+	if err := bc.SignTransaction(tx, wallet.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	tx.ID = tx.Hash()
+
+	node.HandleMessage(&Message{Type: "transaction", Payload: tx.Serialize()})
+	if node.Mempool.Get(tx.ID) == nil {
+		t.Fatal("Relayed transaction should be in the mempool")
+	}
+
+	block, _, err := node.MineMempool(address, 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to mine mempool: %v", err)
+	}
+	if len(block.Transactions) != 2 || node.Mempool.Count() != 0 {
+		t.Errorf("Mined block should take the pooled transaction, %d left", node.Mempool.Count())
+	}
+	if block.Transactions[0].Vout[0].Value != 11 {
+		t.Errorf("Coinbase should claim the subsidy and the fee, got %d", block.Transactions[0].Vout[0].Value)
+	}
+}
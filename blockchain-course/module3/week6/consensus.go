@@ -2,11 +2,13 @@ package week6
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
-	"math"
 	"math/big"
+
+	"blockchain-course/module1/week2"
 )
 
 // Consensus defines the interface for consensus algorithms
@@ -18,19 +20,27 @@ type Consensus interface {
 
 // Block represents a block in the blockchain
 type Block struct {
-	Index     int64
-	Timestamp int64
-	Data      []byte
-	PrevHash  []byte
-	Hash      []byte
-	Nonce     int64
-	Validator string // For PoS
+	Index      int64
+	Timestamp  int64
+	Data       []byte
+	PrevHash   []byte
+	Hash       []byte
+	Nonce      int64
+	Difficulty int    // For PoW, the number of leading zero bits the block was mined with
+	Validator  string // For PoS
 }
 
 // PoW represents Proof of Work consensus
 type PoW struct {
 	Blockchain *Blockchain
 	Difficulty int
+
+	// RetargetInterval is the number of blocks between difficulty adjustments, 0 disables them
+	RetargetInterval int
+	// TargetBlockTime is the desired time between blocks in seconds
+	TargetBlockTime int64
+	// Workers is the number of mining goroutines, 0 uses one per CPU
+	Workers int
 }
 
 // PoS represents Proof of Stake consensus
@@ -62,27 +72,36 @@ func (pow *PoW) Start() error {
 	return nil
 }
 
-// ValidateBlock validates a block using PoW
+// ValidateBlock validates a block using PoW: it must be mined at the
+// difficulty expected after the blocks of the blockchain, and its hash must
+// commit to its contents and be below the target of that difficulty
 func (pow *PoW) ValidateBlock(block *Block) bool {
-	// Check if the hash meets the difficulty requirement
-	// For difficulty D, target = max_target / D
-	// max_target for 256-bit hash is 2^224 (most significant 28 bytes can be non-zero)
-	maxTarget := new(big.Int)
-	maxTarget.Exp(big.NewInt(2), big.NewInt(224), nil) // 2^224
+	if block.Difficulty < 1 || block.Difficulty > 255 || block.Difficulty != pow.nextDifficulty() {
+		return false
+	}
 
-	target := new(big.Int)
-	target.Div(maxTarget, big.NewInt(int64(pow.Difficulty)))
+	hash := sha256.Sum256(pow.prepareData(block, block.Nonce))
+	if !bytes.Equal(hash[:], block.Hash) {
+		return false
+	}
 
-	hashInt := new(big.Int)
-	hashInt.SetBytes(block.Hash)
+	hashInt := new(big.Int).SetBytes(hash[:])
+	return hashInt.Cmp(difficultyTarget(block.Difficulty)) == -1
+}
 
-	return hashInt.Cmp(target) == -1
+// difficultyTarget returns the value a hash must be below to have difficulty leading zero bits
+func difficultyTarget(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(256-difficulty))
 }
 
 // ProposeBlock proposes a new block using PoW
 func (pow *PoW) ProposeBlock(block *Block) error {
 	fmt.Println("Proposing block with Proof of Work")
 
+	// Mine at the difficulty required for the next block
+	pow.AdjustDifficulty()
+	block.Difficulty = pow.Difficulty
+
 	// Perform mining
 	nonce, hash := pow.MineBlock(block)
 	block.Nonce = nonce
@@ -91,47 +110,89 @@ func (pow *PoW) ProposeBlock(block *Block) error {
 	return nil
 }
 
+// AdjustDifficulty sets Difficulty to the difficulty of the next block
+func (pow *PoW) AdjustDifficulty() {
+	pow.Difficulty = pow.nextDifficulty()
+}
+
+// nextDifficulty returns the difficulty of the block following the blockchain.
+// It is the difficulty of the last block, or Difficulty before any block was
+// mined, retargeted every RetargetInterval blocks: intervals mined more than
+// twice as fast as TargetBlockTime add one bit of difficulty, intervals more
+// than twice as slow remove one.
+func (pow *PoW) nextDifficulty() int {
+	if pow.Blockchain == nil || len(pow.Blockchain.Blocks) == 0 {
+		return pow.Difficulty
+	}
+
+	blocks := pow.Blockchain.Blocks
+	last := blocks[len(blocks)-1]
+	difficulty := last.Difficulty
+	if difficulty == 0 {
+		difficulty = pow.Difficulty
+	}
+	if pow.RetargetInterval <= 0 || len(blocks)%pow.RetargetInterval != 0 {
+		return difficulty
+	}
+
+	first := blocks[len(blocks)-pow.RetargetInterval]
+	actual := last.Timestamp - first.Timestamp
+	expected := pow.TargetBlockTime * int64(pow.RetargetInterval)
+
+	if actual < expected/2 {
+		difficulty++
+	} else if actual > expected*2 && difficulty > 1 {
+		difficulty--
+	}
+	return difficulty
+}
+
+// MiningStats reports the work done by a mining run
+type MiningStats = week2.MiningStats
+
+// ErrNonceSpaceExhausted is returned when no nonce satisfies the target
+var ErrNonceSpaceExhausted = week2.ErrNonceSpaceExhausted
+
 // MineBlock performs the mining process
 func (pow *PoW) MineBlock(block *Block) (int64, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := int64(0)
-
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-pow.Difficulty))
-
-	fmt.Printf("Mining block with difficulty %d...\n", pow.Difficulty)
-
-	for nonce < math.MaxInt64 {
-		data := pow.prepareData(block, nonce)
-		hash = sha256.Sum256(data)
-		hashInt.SetBytes(hash[:])
-
-		if hashInt.Cmp(target) == -1 {
-			fmt.Printf("Block mined with nonce %d\n", nonce)
-			break
-		} else {
-			nonce++
-		}
+	nonce, hash, _, _ := pow.MineBlockContext(context.Background(), block)
+	return nonce, hash
+}
+
+// MineBlockContext mines the block at its difficulty, Difficulty if it has
+// none, with Workers goroutines, each searching its own range of the nonce
+// space, or one per CPU if Workers is not set. Mining stops at the first
+// solution or with the context error when ctx is done.
+func (pow *PoW) MineBlockContext(ctx context.Context, block *Block) (int64, []byte, MiningStats, error) {
+	if block.Difficulty == 0 {
+		block.Difficulty = pow.Difficulty
 	}
 
-	return nonce, hash[:]
+	header, nonceOffset := pow.prepareHeader(block)
+	return week2.MineHeader(ctx, header, nonceOffset, difficultyTarget(block.Difficulty), pow.Workers)
 }
 
-// prepareData prepares the data for hashing
+// prepareData prepares the data for hashing, committing to the difficulty of the block
 func (pow *PoW) prepareData(block *Block, nonce int64) []byte {
+	data, nonceOffset := pow.prepareHeader(block)
+	binary.BigEndian.PutUint64(data[nonceOffset:], uint64(nonce))
+	return data
+}
+
+// prepareHeader returns the data hashed for the block with a zero nonce and
+// the offset of the 8 byte nonce in it
+func (pow *PoW) prepareHeader(block *Block) ([]byte, int) {
 	data := bytes.Join(
 		[][]byte{
 			block.PrevHash,
 			block.Data,
 			IntToHex(block.Timestamp),
-			IntToHex(int64(pow.Difficulty)),
-			IntToHex(nonce),
+			IntToHex(int64(block.Difficulty)),
 		},
 		[]byte{},
 	)
-
-	return data
+	nonceOffset := len(data)
+	return append(data, IntToHex(0)...), nonceOffset
 }
 
 // NewPoS creates a new Proof of Stake consensus
@@ -1,7 +1,12 @@
 package week6
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestNewPoW(t *testing.T) {
@@ -25,34 +30,54 @@ func TestPoWValidateBlock(t *testing.T) {
 	blockchain := &Blockchain{}
 	pow := NewPoW(blockchain, 4)
 
-	// Create a block with an invalid hash for difficulty 4
 	block := &Block{
 		Index:     0,
 		Timestamp: 1234567890,
 		Data:      []byte("test data"),
 		PrevHash:  []byte("previous hash"),
-		Hash:      []byte{0x01, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d},
-		Nonce:     12345,
+	}
+	if err := pow.ProposeBlock(block); err != nil {
+		t.Fatalf("Failed to propose block: %v", err)
 	}
 
-	// This should fail because the hash doesn't meet the difficulty requirement
-	if pow.ValidateBlock(block) {
-		t.Error("Block should not be valid with this hash")
+	// A mined block is valid
+	if !pow.ValidateBlock(block) {
+		t.Error("Mined block should be valid")
 	}
 
-	// Create a block with a valid hash for difficulty 4
-	block2 := &Block{
-		Index:     0,
-		Timestamp: 1234567890,
-		Data:      []byte("test data"),
-		PrevHash:  []byte("previous hash"),
-		Hash:      []byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c},
-		Nonce:     12345,
+	// The hash must commit to the contents of the block
+	tampered := *block
+	tampered.Data = []byte("other data")
+	if pow.ValidateBlock(&tampered) {
+		t.Error("Block with tampered data should not be valid")
 	}
 
-	// This should pass because the hash meets the difficulty requirement
-	if !pow.ValidateBlock(block2) {
-		t.Error("Block should be valid with this hash")
+	// A hash below the target that is not the hash of the block is rejected
+	forged := *block
+	forged.Hash = make([]byte, 32)
+	if pow.ValidateBlock(&forged) {
+		t.Error("Block with a forged hash should not be valid")
+	}
+
+	// A block mined at a lower difficulty than the expected one is rejected
+	easy := &Block{
+		Index:      0,
+		Timestamp:  1234567890,
+		Data:       []byte("test data"),
+		PrevHash:   []byte("previous hash"),
+		Difficulty: 1,
+	}
+	easy.Nonce, easy.Hash = pow.MineBlock(easy)
+	if pow.ValidateBlock(easy) {
+		t.Error("Block mined below the expected difficulty should not be valid")
+	}
+
+	// Lowering the difficulty of a mined block breaks its hash
+	lowered := *block
+	lowered.Difficulty = 1
+	pow.Difficulty = 1
+	if pow.ValidateBlock(&lowered) {
+		t.Error("Block with a rewritten difficulty should not be valid")
 	}
 }
 
@@ -194,3 +219,60 @@ func TestIntToHex(t *testing.T) {
 		}
 	}
 }
+
+func TestPoWAdjustDifficulty(t *testing.T) {
+	blockchain := &Blockchain{}
+	pow := NewPoW(blockchain, 4)
+	pow.RetargetInterval = 2
+	pow.TargetBlockTime = 60
+
+	// Two blocks one second apart are far faster than the target
+	blockchain.Blocks = []*Block{{Timestamp: 1000}, {Timestamp: 1001}}
+	pow.AdjustDifficulty()
+	if pow.Difficulty != 5 {
+		t.Errorf("Expected difficulty 5 after fast interval, got %d", pow.Difficulty)
+	}
+
+	// Not on a retarget boundary, the difficulty is kept
+	blockchain.Blocks = append(blockchain.Blocks, &Block{Timestamp: 2000})
+	pow.AdjustDifficulty()
+	if pow.Difficulty != 5 {
+		t.Errorf("Difficulty should not change between retargets, got %d", pow.Difficulty)
+	}
+
+	// A slow interval lowers the difficulty again
+	blockchain.Blocks = append(blockchain.Blocks, &Block{Timestamp: 3000})
+	pow.AdjustDifficulty()
+	if pow.Difficulty != 4 {
+		t.Errorf("Expected difficulty 4 after slow interval, got %d", pow.Difficulty)
+	}
+}
+
+func TestPoWMineBlockContext(t *testing.T) {
+	pow := NewPoW(&Blockchain{}, 8)
+	pow.Workers = 4
+	block := &Block{Timestamp: 1234567890, Data: []byte("parallel"), PrevHash: []byte("previous hash")}
+
+	nonce, hash, stats, err := pow.MineBlockContext(context.Background(), block)
+	if err != nil {
+		t.Fatalf("Mining failed: %v", err)
+	}
+	if hash[0] != 0 {
+		t.Errorf("Hash %x does not meet difficulty 8", hash)
+	}
+	expected := sha256.Sum256(pow.prepareData(block, nonce))
+	if !bytes.Equal(hash, expected[:]) {
+		t.Error("Returned hash should match the nonce")
+	}
+	if stats.Workers != 4 || stats.Hashes == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// An impossible difficulty only ends when the context is cancelled
+	block.Difficulty = 200
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, _, err := pow.MineBlockContext(ctx, block); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+}
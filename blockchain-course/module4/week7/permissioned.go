@@ -1,14 +1,14 @@
 package week7
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
 	"math/big"
 	"time"
+
+	"blockchain-course/module1/keys"
 )
 
 // PermissionedBlockchain represents a permissioned blockchain
@@ -34,15 +34,15 @@ type Member struct {
 	Name        string
 	Role        string
 	Certificate *x509.Certificate
-	PrivateKey  *ecdsa.PrivateKey
-	PublicKey   *ecdsa.PublicKey
+	PrivateKey  keys.Signer
+	PublicKey   keys.Verifier
 }
 
 // CertificateAuthority represents a certificate authority
 type CertificateAuthority struct {
 	Certificate *x509.Certificate
-	PrivateKey  *ecdsa.PrivateKey
-	PublicKey   *ecdsa.PublicKey
+	PrivateKey  keys.Signer
+	PublicKey   keys.Verifier
 }
 
 // Policy represents an access control policy
@@ -59,11 +59,28 @@ type PolicyRule struct {
 	Permissions []string
 }
 
-// NewPermissionedBlockchain creates a new permissioned blockchain
+// NewPermissionedBlockchain creates a new permissioned blockchain whose
+// certificate authority and members use P-256 keys
 func NewPermissionedBlockchain() *PermissionedBlockchain {
 	// Create a certificate authority
 	ca := NewCertificateAuthority()
 
+	return newPermissionedBlockchain(ca)
+}
+
+// NewPermissionedBlockchainWithScheme creates a new permissioned blockchain
+// whose certificate authority and members use keys of a signature scheme.
+// X.509 certificates support P-256 and Ed25519 but not secp256k1.
+func NewPermissionedBlockchainWithScheme(scheme keys.Scheme) (*PermissionedBlockchain, error) {
+	ca, err := NewCertificateAuthorityWithScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPermissionedBlockchain(ca), nil
+}
+
+func newPermissionedBlockchain(ca *CertificateAuthority) *PermissionedBlockchain {
 	return &PermissionedBlockchain{
 		Channels: make(map[string]*Channel),
 		Members:  make(map[string]*Member),
@@ -75,15 +92,30 @@ func NewPermissionedBlockchain() *PermissionedBlockchain {
 	}
 }
 
-// NewCertificateAuthority creates a new certificate authority
+// NewCertificateAuthority creates a new certificate authority with a P-256 key
 func NewCertificateAuthority() *CertificateAuthority {
-	// Generate a private key
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca, err := NewCertificateAuthorityWithScheme(keys.SchemeP256)
 	if err != nil {
-		fmt.Printf("Error generating private key: %s\n", err)
+		fmt.Printf("Error creating certificate authority: %s\n", err)
 		return nil
 	}
 
+	return ca
+}
+
+// NewCertificateAuthorityWithScheme creates a new certificate authority with
+// a key of a signature scheme supported by X.509
+func NewCertificateAuthorityWithScheme(scheme keys.Scheme) (*CertificateAuthority, error) {
+	// Generate a private key
+	privateKey, err := keys.GenerateKey(scheme)
+	if err != nil {
+		return nil, fmt.Errorf("error generating private key: %w", err)
+	}
+	signer, err := keys.CryptoSigner(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create a certificate template
 	template := &x509.Certificate{
 		SerialNumber: big.NewInt(1),
@@ -102,24 +134,22 @@ func NewCertificateAuthority() *CertificateAuthority {
 	}
 
 	// Create a self-signed certificate
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
 	if err != nil {
-		fmt.Printf("Error creating certificate: %s\n", err)
-		return nil
+		return nil, fmt.Errorf("error creating certificate: %w", err)
 	}
 
 	// Parse the certificate
 	cert, err := x509.ParseCertificate(certBytes)
 	if err != nil {
-		fmt.Printf("Error parsing certificate: %s\n", err)
-		return nil
+		return nil, fmt.Errorf("error parsing certificate: %w", err)
 	}
 
 	return &CertificateAuthority{
 		Certificate: cert,
 		PrivateKey:  privateKey,
-		PublicKey:   &privateKey.PublicKey,
-	}
+		PublicKey:   privateKey.Public(),
+	}, nil
 }
 
 // RegisterMember registers a new member in the permissioned blockchain
@@ -129,11 +159,19 @@ func (pb *PermissionedBlockchain) RegisterMember(id, name, role string) (*Member
 		return nil, fmt.Errorf("member already exists")
 	}
 
-	// Generate a private key for the member
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	// Generate a private key for the member, of the scheme of the CA
+	privateKey, err := keys.GenerateKey(pb.CA.PrivateKey.Scheme())
 	if err != nil {
 		return nil, fmt.Errorf("error generating private key: %s", err)
 	}
+	signer, err := keys.CryptoSigner(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	caSigner, err := keys.CryptoSigner(pb.CA.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a certificate template for the member
 	template := &x509.Certificate{
@@ -152,7 +190,7 @@ func (pb *PermissionedBlockchain) RegisterMember(id, name, role string) (*Member
 	}
 
 	// Create a certificate for the member
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, pb.CA.Certificate, &privateKey.PublicKey, pb.CA.PrivateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, pb.CA.Certificate, signer.Public(), caSigner)
 	if err != nil {
 		return nil, fmt.Errorf("error creating certificate: %s", err)
 	}
@@ -170,7 +208,7 @@ func (pb *PermissionedBlockchain) RegisterMember(id, name, role string) (*Member
 		Role:        role,
 		Certificate: cert,
 		PrivateKey:  privateKey,
-		PublicKey:   &privateKey.PublicKey,
+		PublicKey:   privateKey.Public(),
 	}
 
 	// Add the member to the blockchain
@@ -1,7 +1,10 @@
 package week7
 
 import (
+	"errors"
 	"testing"
+
+	"blockchain-course/module1/keys"
 )
 
 func TestNewPermissionedBlockchain(t *testing.T) {
@@ -48,6 +51,27 @@ func TestNewCertificateAuthority(t *testing.T) {
 	}
 }
 
+func TestCertificateAuthoritySchemes(t *testing.T) {
+	pb, err := NewPermissionedBlockchainWithScheme(keys.SchemeEd25519)
+	if err != nil {
+		t.Fatalf("Failed to create Ed25519 permissioned blockchain: %v", err)
+	}
+	member, err := pb.RegisterMember("member1", "Test Member", "user")
+	if err != nil {
+		t.Fatalf("Failed to register member: %v", err)
+	}
+	if member.PublicKey.Scheme() != keys.SchemeEd25519 {
+		t.Errorf("Member key should use the scheme of the CA, got %s", member.PublicKey.Scheme())
+	}
+	if !pb.VerifyMember("member1") {
+		t.Error("Ed25519 member certificate should verify")
+	}
+
+	if _, err := NewCertificateAuthorityWithScheme(keys.SchemeSecp256k1); !errors.Is(err, keys.ErrUnsupportedScheme) {
+		t.Errorf("Expected ErrUnsupportedScheme for secp256k1, got %v", err)
+	}
+}
+
 func TestRegisterMember(t *testing.T) {
 	pb := NewPermissionedBlockchain()
 